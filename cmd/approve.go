@@ -0,0 +1,65 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"time"
+
+	"github.com/ExpediaGroup/vsync/syncer"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(approveCmd)
+}
+
+var approveCmd = &cobra.Command{
+	Use:           "approve [<plan-id>]",
+	Short:         "Approves the plan of deletes and updates waiting in destination sync path",
+	Long:          `Without plan id, shows the pending plan and its tasks. With plan id, approves it so next destination cycle syncs its tasks, as long as the plan is still pending and not expired`,
+	Args:          cobra.MaximumNArgs(1),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		_, destinationStore, err := getCheckedStore("destination")
+		if err != nil {
+			return err
+		}
+
+		if len(args) == 0 {
+			plan, ok, err := syncer.PlanFromStore(destinationStore)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				log.Info().Str("store", destinationStore.String()).Msg("no plan is waiting for approval")
+				return nil
+			}
+			for _, task := range plan.Tasks {
+				log.Info().Str("path", task.Path).Str("operation", task.Op).Int64("version", task.Insight.Version).Str("deleted", task.Insight.Deleted).Msg("task in plan")
+			}
+			log.Info().Str("plan", plan.ID).Int("deletes", plan.Deletes).Int("updates", plan.Updates).Time("created", plan.Created).Time("expires", plan.Expires).Bool("expired", plan.Expired(time.Now())).Msg("plan is waiting for approval")
+			return nil
+		}
+
+		plan, err := syncer.Approve(destinationStore, args[0], time.Now())
+		if err != nil {
+			return err
+		}
+		log.Info().Str("plan", plan.ID).Int("deletes", plan.Deletes).Int("updates", plan.Updates).Str("store", destinationStore.String()).Msg("approved plan, next destination cycle syncs its tasks")
+		return nil
+	},
+}
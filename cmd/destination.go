@@ -16,9 +16,8 @@ package cmd
 
 import (
 	"context"
-	"crypto/sha256"
+	"errors"
 	"fmt"
-	"hash"
 	"os"
 	"os/signal"
 	"strings"
@@ -27,11 +26,9 @@ import (
 	"time"
 
 	"github.com/ExpediaGroup/vsync/apperr"
-	"github.com/ExpediaGroup/vsync/consul"
 	"github.com/ExpediaGroup/vsync/syncer"
 	"github.com/ExpediaGroup/vsync/transformer"
 	"github.com/ExpediaGroup/vsync/vault"
-	"github.com/hashicorp/consul/api/watch"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -40,12 +37,32 @@ import (
 func init() {
 	viper.SetDefault("name", "destination") // name is required for mount checks and telemetry
 	viper.SetDefault("numBuckets", 1)       // we need atleast one bucket to store info
+	viper.SetDefault("bucketing", syncer.BucketingModulo)
+	viper.SetDefault("indexFanout", 0)
+	viper.SetDefault("prefixDepth", 1)
+	viper.SetDefault("bucketCompression", syncer.CompressionNone)
+	viper.SetDefault("maxBucketSize", syncer.DefaultMaxBucketSize)
+	viper.SetDefault("hashAlgorithm", syncer.HashSHA256)
+	viper.SetDefault("indexSerialization", syncer.SerializationFmt)
 	viper.SetDefault("destination.tick", "10s")
 	viper.SetDefault("destination.timeout", "5m")
 	viper.SetDefault("destination.syncPath", "vsync/")
 	viper.SetDefault("destination.numWorkers", 1) // we need atleast 1 worker or else the sync routine will be blocked
+	viper.SetDefault("destination.replayHistory", false)
+	viper.SetDefault("destination.conflictPolicy", syncer.ConflictOverwrite)
+	viper.SetDefault("destination.conflictPrefix", "vsync-conflicts/")
+	viper.SetDefault("destination.ownership", false)
+	viper.SetDefault("destination.quarantine.prefix", "vsync-quarantine/")
+	viper.SetDefault("destination.approval.deletes", false)
+	viper.SetDefault("destination.approval.maxUpdates", 0)
+	viper.SetDefault("destination.approval.ttl", "24h")
+	viper.SetDefault("destination.journal.cycles", 10)
+	viper.SetDefault("destination.verifyFingerprints", false)
+	viper.SetDefault("destination.auditInterval", "1h")
 	viper.SetDefault("origin.syncPath", "vsync/")
 	viper.SetDefault("origin.renewToken", true)
+	viper.SetDefault("origin.store.type", syncer.StoreConsul)
+	viper.SetDefault("destination.store.type", syncer.StoreConsul)
 
 	if err := viper.BindPFlags(destinationCmd.PersistentFlags()); err != nil {
 		log.Panic().
@@ -81,15 +98,14 @@ var destinationCmd = &cobra.Command{
 
 		// initial configs
 		name := viper.GetString("name")
-		numBuckets := viper.GetInt("numBuckets")
 		tick := viper.GetDuration("destination.tick")
 		timeout := viper.GetDuration("destination.timeout")
+		auditInterval := viper.GetDuration("destination.auditInterval")
 		numWorkers := viper.GetInt("destination.numWorkers")
 		originSyncPath := viper.GetString("origin.syncPath")
 		originMounts := viper.GetStringSlice("origin.mounts")
 		destinationSyncPath := viper.GetString("destination.syncPath")
 		destinationMounts := viper.GetStringSlice("destination.mounts")
-		hasher := sha256.New()
 
 		// deprecated
 		syncPathDepr := viper.GetString("syncPath")
@@ -108,6 +124,24 @@ var destinationCmd = &cobra.Command{
 			return apperr.New(fmt.Sprintf("parameter %q deprecated, use %q", "destination.dc", "destination.consul.dc"), ErrInitialize, op, apperr.Fatal)
 		}
 
+		layout, err := getLayout("destination")
+		if err != nil {
+			return err
+		}
+
+		fingerprinter, err := getFingerprinter("destination")
+		if err != nil {
+			return err
+		}
+		if auditInterval < 0 {
+			return apperr.New(fmt.Sprintf("%q must not be negative", "destination.auditInterval"), ErrInitialize, op, apperr.Fatal)
+		}
+		syncer.VerifyFingerprints = viper.GetBool("destination.verifyFingerprints")
+		if syncer.VerifyFingerprints && fingerprinter == nil {
+			log.Error().Str("mode", "destination").Msg("verifying fingerprints needs a fingerprint key")
+			return apperr.New(fmt.Sprintf("%q needs %q or %q", "destination.verifyFingerprints", "fingerprint.key", "fingerprint.keyFile"), ErrInitialize, op, apperr.Fatal)
+		}
+
 		// telemetry client
 		telemetryClient.AddTags("mpaas_application_name:vsync_" + name)
 
@@ -148,14 +182,26 @@ var destinationCmd = &cobra.Command{
 		originSyncPath = originSyncPath + "origin/"
 		destinationSyncPath = destinationSyncPath + "destination/"
 
-		err = destinationConsul.SyncPathChecks(destinationSyncPath, consul.StdCheck)
+		destinationStore, err := getStore("destination", destinationConsul, destinationVault, destinationSyncPath)
+		if err != nil {
+			log.Debug().Err(err).Str("path", destinationSyncPath).Msg("cannot get sync info store on destination")
+			return apperr.New(fmt.Sprintf("cannot get sync info store for %q", destinationSyncPath), err, op, apperr.Fatal, ErrInitialize)
+		}
+
+		originStore, err := getStore("origin", originConsul, originVault, originSyncPath)
+		if err != nil {
+			log.Debug().Err(err).Str("path", originSyncPath).Msg("cannot get sync info store on origin")
+			return apperr.New(fmt.Sprintf("cannot get sync info store for %q", originSyncPath), err, op, apperr.Fatal, ErrInitialize)
+		}
+
+		err = destinationStore.Checks()
 		if err != nil {
 			log.Debug().Err(err).Msg("failures on sync path checks on destination")
 			return apperr.New(fmt.Sprintf("sync path checks failed for %q", destinationSyncPath), err, op, apperr.Fatal, ErrInitialize)
 		}
 		log.Info().Str("path", destinationSyncPath).Msg("sync path passed initial checks on destination")
 
-		err = originConsul.SyncPathChecks(originSyncPath, consul.StdCheck)
+		err = originStore.Checks()
 		if err != nil {
 			log.Debug().Err(err).Msg("failures on sync path checks on origin")
 			return apperr.New(fmt.Sprintf("sync path checks failed for %q", originSyncPath), err, op, apperr.Fatal, ErrInitialize)
@@ -163,7 +209,7 @@ var destinationCmd = &cobra.Command{
 		log.Info().Str("path", originSyncPath).Msg("sync path passed initial checks on origin")
 
 		// initialize destination sync path
-		initialized, err := destinationConsul.IsSyncPathInitialized(destinationSyncPath)
+		initialized, err := destinationStore.IsInitialized()
 		if err != nil {
 			log.Debug().Err(err).Str("path", destinationSyncPath).Msg("failures on checking if sync path is initalized on destination")
 			return apperr.New(fmt.Sprintf("sync path %q already initialized check failed", destinationSyncPath), err, op, apperr.Fatal, ErrInitialize)
@@ -171,15 +217,16 @@ var destinationCmd = &cobra.Command{
 		if initialized {
 			log.Info().Str("path", destinationSyncPath).Msg("path is already initialized")
 		} else {
-			destinationInfo, err := syncer.NewInfo(numBuckets, hasher)
+			destinationInfo, err := layout.NewInfo()
 			if err != nil {
-				log.Debug().Err(err).Int("numBuckets", numBuckets).Str("path", destinationSyncPath).Msg("failure in creating new destination sync info, while checking if destination sync path exists")
-				return apperr.New(fmt.Sprintf("sync path %q not initialized already, could not create new destination info with buckets %q", destinationSyncPath, numBuckets), err, op, apperr.Fatal, ErrInitialize)
+				log.Debug().Err(err).Int("numBuckets", layout.NumBuckets).Str("bucketing", layout.Bucketing).Str("hashAlgorithm", layout.HashAlgorithm).Str("path", destinationSyncPath).Msg("failure in creating new destination sync info, while checking if destination sync path exists")
+				return apperr.New(fmt.Sprintf("sync path %q not initialized already, could not create new destination info", destinationSyncPath), err, op, apperr.Fatal, ErrInitialize)
 			}
 
-			err = syncer.InfoToConsul(destinationConsul, destinationInfo, destinationSyncPath)
+			// nothing published yet, any index present now is another writer
+			err = syncer.InfoToStore(destinationStore, destinationInfo, syncer.Revision{})
 			if err != nil {
-				log.Debug().Err(err).Str("path", destinationSyncPath).Msg("cannot initialize sync info in destination consul")
+				log.Debug().Err(err).Str("path", destinationSyncPath).Msg("cannot initialize sync info in destination store")
 				return apperr.New(fmt.Sprintf("sync path %q not initialized already, could not initialize now", destinationSyncPath), err, op, apperr.Fatal, ErrInitialize)
 			}
 
@@ -232,16 +279,91 @@ var destinationCmd = &cobra.Command{
 		}
 		log.Info().Strs("mounts", destinationMounts).Msg("mounts passed initial checks on destination")
 
+		// kinds of delete mirrored from origin for each destination mount
+		syncer.DeletePolicies, err = getDeletePolicies(destinationVault, destinationMounts)
+		if err != nil {
+			return err
+		}
+
+		// destination mounts with nothing but origin secrets
+		syncer.StrictMirrors, err = getStrictMirrors(destinationMounts)
+		if err != nil {
+			return err
+		}
+
+		// replaying history deletes and destroys versions which cannot be read in origin
+		if viper.GetBool("destination.replayHistory") {
+			log.Info().Msg("replay history is true, so every origin version is written to destination and version numbers line up")
+			syncer.ReplayHistory = true
+			for _, mount := range destinationMounts {
+				for _, action := range []string{"delete", "destroy"} {
+					p := fmt.Sprintf("%s%s/", mount, action)
+					err = destinationVault.CheckTokenPermissions(p, vault.CheckUpdate)
+					if err != nil {
+						log.Debug().Err(err).Str("path", p).Msg("vault token missing update permission for replaying history")
+						return apperr.New(fmt.Sprintf("vault token missing update permission on path %q for replaying history", p), err, op, apperr.Fatal, ErrInitialize)
+					}
+				}
+			}
+		}
+
+		// secrets changed in destination outside of vsync
+		syncer.ConflictPolicy = viper.GetString("destination.conflictPolicy")
+		if err := syncer.CheckConflictPolicy(syncer.ConflictPolicy); err != nil {
+			log.Debug().Err(err).Msg("invalid conflict policy")
+			return apperr.New(fmt.Sprintf("invalid conflict policy"), err, op, apperr.Fatal, ErrInitialize)
+		}
+		syncer.ConflictPrefix = viper.GetString("destination.conflictPrefix")
+		if syncer.ConflictPolicy == syncer.ConflictCopy && !strings.HasSuffix(syncer.ConflictPrefix, "/") {
+			return apperr.New(fmt.Sprintf("conflict prefix %q must end with /", syncer.ConflictPrefix), ErrInitialize, op, apperr.Fatal)
+		}
+		log.Info().Str("policy", syncer.ConflictPolicy).Msg("destination secrets changed outside of vsync are handled with conflict policy")
+
+		// secrets created by hand in destination are never overwritten or deleted
+		if viper.GetBool("destination.ownership") {
+			log.Info().Msg("ownership is true, so destination secrets without provenance of vsync are reported as conflicts and not changed")
+			syncer.Ownership = true
+		}
+
+		// secrets deleted by vsync are archived first
+		if err := getQuarantine(destinationVault); err != nil {
+			return err
+		}
+		if syncer.QuarantineMount != "" {
+			log.Info().Str("mount", syncer.QuarantineMount).Str("prefix", syncer.QuarantinePrefix).Msg("destination secrets are quarantined before deleting")
+		}
+
+		// destructive tasks wait for an operator
+		syncer.ApproveDeletes = viper.GetBool("destination.approval.deletes")
+		syncer.ApproveUpdatesOver = viper.GetInt("destination.approval.maxUpdates")
+		syncer.PlanTTL = viper.GetDuration("destination.approval.ttl")
+		if syncer.ApproveUpdatesOver < 0 || syncer.PlanTTL <= 0 {
+			return apperr.New(fmt.Sprintf("%q must not be negative and %q must be positive", "destination.approval.maxUpdates", "destination.approval.ttl"), ErrInitialize, op, apperr.Fatal)
+		}
+		if syncer.ApproveDeletes && syncer.IgnoreDeletes {
+			return apperr.New(fmt.Sprintf("deletes cannot wait for approval when ignore deletes is true"), ErrInitialize, op, apperr.Fatal)
+		}
+		if syncer.ApproveDeletes || syncer.ApproveUpdatesOver > 0 {
+			log.Info().Bool("deletes", syncer.ApproveDeletes).Int("maxUpdates", syncer.ApproveUpdatesOver).Dur("ttl", syncer.PlanTTL).Msg("destructive tasks wait for approval")
+		}
+
+		// changes of recent cycles can be undone
+		syncer.JournalCycles = viper.GetInt("destination.journal.cycles")
+		if syncer.JournalCycles < 0 {
+			return apperr.New(fmt.Sprintf("%q must not be negative", "destination.journal.cycles"), ErrInitialize, op, apperr.Fatal)
+		}
+
 		log.Info().Msg("********** starting destination sync **********\n")
 
 		// prepare for getting sync data from origin
-		go prepareWatch(ctx, originConsul, originSyncPath, triggerCh, errCh)
-		go prepareTicker(ctx, originConsul, originSyncPath, tick, triggerCh, errCh)
+		go prepareWatch(ctx, originStore, triggerCh, errCh)
+		go prepareTicker(ctx, originStore, tick, triggerCh, errCh)
 		go destinationSync(ctx, name,
-			originConsul, originSyncPath, originVault, originMounts,
-			destinationConsul, destinationSyncPath, destinationVault, destinationMounts,
+			originStore, originVault, originMounts,
+			destinationStore, destinationVault, destinationMounts,
 			pack,
-			hasher, numBuckets, timeout, numWorkers,
+			layout, timeout, auditInterval, numWorkers,
+			fingerprinter,
 			triggerCh, errCh)
 
 		// origin token renewer go routine
@@ -290,57 +412,24 @@ var destinationCmd = &cobra.Command{
 	},
 }
 
-func prepareWatch(ctx context.Context, originConsul *consul.Client, originSyncPath string, triggerCh chan bool, errCh chan error) {
+func prepareWatch(ctx context.Context, originStore syncer.Store, triggerCh chan bool, errCh chan error) {
 	const op = apperr.Op("cmd.destination.prepareWatch")
-	syncIndex := originSyncPath + "index"
-
-	// prepare the watch
-	plan, err := watch.Parse(map[string]interface{}{
-		"type":       "key",
-		"stale":      true,
-		"key":        syncIndex,
-		"datacenter": originConsul.Dc,
-	})
-	if err != nil {
-		log.Debug().Err(err).
-			Str("key", syncIndex).Str("origin", originConsul.Dc).
-			Msg("cannot make plan for key watch in origin from destination")
-		errCh <- apperr.New(fmt.Sprintf("cannot make plan for key %q watch in origin %q from destination", syncIndex, originConsul.Dc), err, op, apperr.Fatal, ErrInvalidCPath)
-	}
-
-	// handler to send data to another kv channel
-	plan.HybridHandler = func(blockParamVal watch.BlockingParamVal, val interface{}) {
-		// TODO: test blockParamVal https://github.com/hashicorp/consul/blob/master/api/watch/plan_test.go
-		if val == nil {
-			log.Debug().Msg("nil value received from consul watch")
-			return
-		}
 
+	// watch blocks till context is done
+	err := syncer.WatchIndex(ctx, originStore, func() {
 		triggerCh <- true
 		telemetryClient.Count("vsync.destination.watch.triggered", 1)
-		log.Info().Msg("consul watch triggered for getting sync index from origin consul")
+		log.Info().Msg("watch triggered for getting sync index from origin store")
+	})
+	if err != nil {
+		log.Debug().Err(err).Str("store", originStore.String()).Msg("failure while performing watch on origin store")
+		errCh <- apperr.New(fmt.Sprintf("failure while performing watch from destination to origin store %q", originStore), err, op, apperr.Fatal, ErrInitialize)
+		return
 	}
-
-	// create a new go routine because plan run will block
-	go func() {
-		err = plan.Run(originConsul.Address)
-		log.Debug().Str("trigger", "context done").Str("path", syncIndex).Msg("closed consul watch")
-		if err != nil {
-			log.Debug().Err(err).Msg("failure while performing consul watch run")
-			errCh <- apperr.New(fmt.Sprintf("failure while performing consul watch from destination to origin %q", originConsul.Dc), err, op, apperr.Fatal, ErrInitialize)
-		}
-	}()
-
-	// lock the current go routine
-	// if context is done then stop the plan
-	<-ctx.Done()
-	plan.Stop()
-	time.Sleep(100 * time.Microsecond)
-	log.Debug().Str("trigger", "context done").Str("path", syncIndex).Msg("closed prepare watch")
+	log.Debug().Str("trigger", "context done").Str("store", originStore.String()).Msg("closed prepare watch")
 }
 
-func prepareTicker(ctx context.Context, originConsul *consul.Client, originSyncPath string, tick time.Duration, triggerCh chan bool, errCh chan error) {
-	syncIndex := originSyncPath + "index"
+func prepareTicker(ctx context.Context, originStore syncer.Store, tick time.Duration, triggerCh chan bool, errCh chan error) {
 	ticker := time.NewTicker(tick)
 
 	for {
@@ -348,11 +437,11 @@ func prepareTicker(ctx context.Context, originConsul *consul.Client, originSyncP
 		case <-ctx.Done():
 			ticker.Stop()
 			time.Sleep(100 * time.Microsecond)
-			log.Debug().Str("trigger", "context done").Str("path", syncIndex).Msg("closed consul get sync index timer for path")
+			log.Debug().Str("trigger", "context done").Str("store", originStore.String()).Msg("closed get sync index timer for store")
 			return
 		case <-ticker.C:
 			telemetryClient.Count("vsync.destination.timer.triggered", 1)
-			log.Info().Msg("timer triggered for getting sync index from origin consul")
+			log.Info().Msg("timer triggered for getting sync index from origin store")
 			triggerCh <- true
 		}
 	}
@@ -360,14 +449,25 @@ func prepareTicker(ctx context.Context, originConsul *consul.Client, originSyncP
 
 // destinationSync compares sync entries then update actual and sync entries
 func destinationSync(ctx context.Context, name string,
-	originConsul *consul.Client, originSyncPath string, originVault *vault.Client, originMounts []string,
-	destinationConsul *consul.Client, destinationSyncPath string, destinationVault *vault.Client, destinationMounts []string,
+	originStore syncer.Store, originVault *vault.Client, originMounts []string,
+	destinationStore syncer.Store, destinationVault *vault.Client, destinationMounts []string,
 	pack transformer.Pack,
-	hasher hash.Hash, numBuckets int, timeout time.Duration, numWorkers int,
+	layout syncer.Layout, timeout time.Duration, auditInterval time.Duration, numWorkers int,
+	fingerprinter *syncer.Fingerprinter,
 	triggerCh chan bool, errCh chan error) {
 
 	const op = apperr.Op("cmd.destinationSync")
 
+	// buckets by hash, kept across cycles
+	cache := syncer.NewCache()
+
+	// fingerprint verification and strict mirror walks read every destination secret
+	// they audit on their own interval, so a trigger without origin changes does not read any bucket
+	// prunes found by last strict mirror walk are kept till they are synced, like while they wait for approval
+	audits := syncer.VerifyFingerprints || len(syncer.StrictMirrors) > 0
+	var lastAudit time.Time
+	var prunes []syncer.Task
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -423,46 +523,102 @@ func destinationSync(ctx context.Context, name string,
 				}
 			}
 
-			// origin sync info
-			originfo, err := syncer.NewInfo(numBuckets, hasher)
+			// sync index as this cycle starts, saving fails if undo or rebuild changes it meanwhile
+			since, err := syncer.IndexRevision(destinationStore)
 			if err != nil {
-				log.Debug().Err(err).Int("numBuckets", numBuckets).Str("path", originSyncPath).Msg("failure in initializing origin sync info")
-				errCh <- apperr.New(fmt.Sprintf("cannot create new sync info in path %q", originSyncPath), err, apperr.Fatal, op, ErrInitialize)
+				log.Debug().Err(err).Str("store", destinationStore.String()).Msg("cannot get revision of destination sync index")
+				errCh <- apperr.New(fmt.Sprintf("cannot get revision of sync index in store %q", destinationStore), err, op, ErrInvalidInfo)
 
 				syncCancel()
 				time.Sleep(100 * time.Microsecond)
-				log.Warn().Msg("incomplete sync cycle, failure in creating new origin sync info\n")
+				log.Warn().Msg("incomplete sync cycle, failure in getting revision of destination sync index\n")
 				continue
 			}
 
-			err = syncer.InfoFromConsul(originConsul, originfo, originSyncPath)
+			// destination sync index first, so that origin merkle index nodes with same hash come from cache
+			destinationInfo, err := syncer.NewInfo(layout.NumBuckets)
 			if err != nil {
-				log.Debug().Err(err).Str("path", originSyncPath).Msg("cannot get sync info from origin consul")
-				errCh <- apperr.New(fmt.Sprintf("cannot get sync info in path %q", originSyncPath), err, apperr.Fatal, op, ErrInvalidInfo)
+				log.Debug().Err(err).Int("numBuckets", layout.NumBuckets).Str("store", destinationStore.String()).Msg("failure in initializing destination sync info")
+				errCh <- apperr.New(fmt.Sprintf("cannot create new sync info in store %q", destinationStore), err, apperr.Fatal, op, ErrInitialize)
 
 				syncCancel()
 				time.Sleep(100 * time.Microsecond)
-				log.Warn().Msg("incomplete sync cycle, failure in getting origin sync info\n")
+				log.Warn().Msg("incomplete sync cycle, failure in  creating new destination sync info\n")
 				continue
 			}
-			log.Info().Msg("retrieved origin sync info")
 
-			// destination sync info
-			destinationInfo, err := syncer.NewInfo(numBuckets, hasher)
+			// inconsistent merkle index leaves bucket hashes empty, reading buckets finds them and reindexes
+			err = syncer.IndexFromStore(destinationStore, destinationInfo, cache)
+			if err != nil && !errors.Is(err, syncer.ErrInconsistent) {
+				log.Debug().Err(err).Str("store", destinationStore.String()).Msg("cannot get sync index from destination store")
+				errCh <- apperr.New(fmt.Sprintf("cannot get sync index in store %q", destinationStore), err, op, apperr.Fatal, ErrInvalidInfo)
+
+				syncCancel()
+				time.Sleep(100 * time.Microsecond)
+				log.Warn().Msg("incomplete sync cycle, failure in getting destination sync index\n")
+				continue
+			}
+
+			// origin sync index
+			originfo, err := syncer.NewInfo(layout.NumBuckets)
 			if err != nil {
-				log.Debug().Err(err).Int("numBuckets", numBuckets).Str("path", destinationSyncPath).Msg("failure in initializing destination sync info")
-				errCh <- apperr.New(fmt.Sprintf("cannot create new sync info in path %q", destinationSyncPath), err, apperr.Fatal, op, ErrInitialize)
+				log.Debug().Err(err).Int("numBuckets", layout.NumBuckets).Str("store", originStore.String()).Msg("failure in initializing origin sync info")
+				errCh <- apperr.New(fmt.Sprintf("cannot create new sync info in store %q", originStore), err, apperr.Fatal, op, ErrInitialize)
 
 				syncCancel()
 				time.Sleep(100 * time.Microsecond)
-				log.Warn().Msg("incomplete sync cycle, failure in  creating new destination sync info\n")
+				log.Warn().Msg("incomplete sync cycle, failure in creating new origin sync info\n")
+				continue
+			}
+
+			err = syncer.IndexFromStore(originStore, originfo, cache)
+			if errors.Is(err, syncer.ErrInconsistent) {
+				// origin may be publishing right now, next trigger will bring the complete sync info
+				log.Debug().Err(err).Str("store", originStore.String()).Msg("inconsistent sync index from origin store")
+				errCh <- apperr.New(fmt.Sprintf("inconsistent sync index in store %q", originStore), err, op, ErrInvalidInfo)
+
+				syncCancel()
+				time.Sleep(100 * time.Microsecond)
+				log.Warn().Msg("incomplete sync cycle, origin sync index is inconsistent, may be origin is publishing\n")
+				continue
+			}
+			if err != nil {
+				log.Debug().Err(err).Str("store", originStore.String()).Msg("cannot get sync index from origin store")
+				errCh <- apperr.New(fmt.Sprintf("cannot get sync index in store %q", originStore), err, apperr.Fatal, op, ErrInvalidInfo)
+
+				syncCancel()
+				time.Sleep(100 * time.Microsecond)
+				log.Warn().Msg("incomplete sync cycle, failure in getting origin sync index\n")
+				continue
+			}
+			originHeader := originfo.Header()
+			log.Info().Str("origin", originHeader.Origin).Str("cycleStart", originHeader.CycleStart).Int("paths", originHeader.NumPaths).Int("formatVersion", originHeader.FormatVersion).Msg("retrieved origin sync index")
+
+			// same index, nothing to migrate, no audit due and no prunes left, so no need to read any bucket
+			destinationHeader := destinationInfo.Header()
+			audit := audits && time.Since(lastAudit) >= auditInterval
+			if originfo.InSync(destinationInfo) && destinationHeader.SameLayout(layout.Header) && destinationHeader.Compression == layout.Compression && !audit && len(prunes) == 0 {
+				log.Info().Msg("no changes from origin")
+
+				syncCancel()
+				time.Sleep(500 * time.Microsecond)
+				telemetryClient.Count("vsync.destination.cycle", 1, "status:success")
+				log.Info().Msg("completed sync cycle, same index as origin\n")
 				continue
 			}
 
-			err = syncer.InfoFromConsul(destinationConsul, destinationInfo, destinationSyncPath)
+			// destination buckets first, so that origin buckets with same hash come from cache
+			reindexed := false
+			err = syncer.BucketsFromStore(destinationStore, destinationInfo, cache)
+			if errors.Is(err, syncer.ErrInconsistent) {
+				// an earlier save was interrupted, buckets are the truth so reindex and save at end of cycle
+				log.Warn().Err(err).Str("store", destinationStore.String()).Msg("inconsistent destination sync info, reindexing")
+				err = destinationInfo.Reindex()
+				reindexed = true
+			}
 			if err != nil {
-				log.Debug().Err(err).Str("path", destinationSyncPath).Msg("cannot get sync info from destination consul")
-				errCh <- apperr.New(fmt.Sprintf("cannot get sync info in path %q", destinationSyncPath), err, op, apperr.Fatal, ErrInvalidInfo)
+				log.Debug().Err(err).Str("store", destinationStore.String()).Msg("cannot get sync info from destination store")
+				errCh <- apperr.New(fmt.Sprintf("cannot get sync info in store %q", destinationStore), err, op, apperr.Fatal, ErrInvalidInfo)
 
 				syncCancel()
 				time.Sleep(100 * time.Microsecond)
@@ -471,12 +627,125 @@ func destinationSync(ctx context.Context, name string,
 			}
 			log.Info().Msg("retrieved destination sync info")
 
+			err = syncer.BucketsFromStore(originStore, originfo, cache)
+			if errors.Is(err, syncer.ErrInconsistent) {
+				// origin may be publishing right now, next trigger will bring the complete sync info
+				log.Debug().Err(err).Str("store", originStore.String()).Msg("inconsistent sync info from origin store")
+				errCh <- apperr.New(fmt.Sprintf("inconsistent sync info in store %q", originStore), err, op, ErrInvalidInfo)
+
+				syncCancel()
+				time.Sleep(100 * time.Microsecond)
+				log.Warn().Msg("incomplete sync cycle, origin sync info is inconsistent, may be origin is publishing\n")
+				continue
+			}
+			if err != nil {
+				log.Debug().Err(err).Str("store", originStore.String()).Msg("cannot get sync info from origin store")
+				errCh <- apperr.New(fmt.Sprintf("cannot get sync info in store %q", originStore), err, apperr.Fatal, op, ErrInvalidInfo)
+
+				syncCancel()
+				time.Sleep(100 * time.Microsecond)
+				log.Warn().Msg("incomplete sync cycle, failure in getting origin sync info\n")
+				continue
+			}
+			log.Info().Int("cachedBuckets", cache.Sweep()).Msg("retrieved origin sync info")
+
+			// migrate destination sync info if number of buckets, bucketing, index fanout or hashing is changed in config
+			// origin can have a different layout, compare takes care of it
+			migrated := false
+			if !destinationHeader.SameLayout(layout.Header) {
+				migratedInfo, err := destinationInfo.Rebucket(layout.Header)
+				if err != nil {
+					log.Debug().Err(err).Int("numBuckets", layout.NumBuckets).Str("bucketing", layout.Bucketing).Msg("cannot migrate destination sync info")
+					errCh <- apperr.New(fmt.Sprintf("cannot migrate destination sync info from %d to %d buckets", destinationHeader.NumBuckets, layout.NumBuckets), err, op, apperr.Fatal, ErrInvalidInfo)
+
+					syncCancel()
+					time.Sleep(100 * time.Microsecond)
+					log.Warn().Msg("incomplete sync cycle, failure in migrating destination sync info\n")
+					continue
+				}
+				destinationInfo = migratedInfo
+				migrated = true
+				log.Info().Int("from", destinationHeader.NumBuckets).Int("to", layout.NumBuckets).Str("bucketing", layout.Bucketing).Int("fanout", layout.Fanout).Msg("migrated destination sync info to new buckets")
+			}
+
+			// compression can change any time, readers follow the header
+			err = destinationInfo.SetEncoding(layout.Compression, layout.MaxBucketSize)
+			if err != nil {
+				errCh <- apperr.New(fmt.Sprintf("cannot set bucket compression %q for destination sync info", layout.Compression), err, op, apperr.Fatal, ErrInvalidInfo)
+
+				syncCancel()
+				time.Sleep(100 * time.Microsecond)
+				log.Warn().Msg("incomplete sync cycle, failure in setting bucket compression for destination sync info\n")
+				continue
+			}
+			if destinationInfo.Header().Compression != destinationHeader.Compression {
+				migrated = true
+				log.Info().Str("from", destinationHeader.Compression).Str("to", layout.Compression).Msg("migrated destination sync info to new bucket compression")
+			}
+
+			// fingerprints were enabled, disabled or made with a new key in origin
+			// take them from origin for unchanged versions instead of rewriting every secret
+			if destinationInfo.Header().FingerprintKey != originHeader.FingerprintKey {
+				adopted := destinationInfo.AdoptFingerprints(originfo)
+				migrated = true
+				log.Info().Int("paths", adopted).Str("fingerprintKey", originHeader.FingerprintKey).Msg("adopted fingerprints from origin")
+			}
+
+			// secrets changed outside of vsync get a fingerprint different from origin
+			if syncer.VerifyFingerprints && audit {
+				if fingerprinter.KeyID() != originHeader.FingerprintKey {
+					log.Warn().Str("originKey", originHeader.FingerprintKey).Str("destinationKey", fingerprinter.KeyID()).Msg("fingerprint key is different from origin, cannot verify destination secrets")
+				} else {
+					var wg sync.WaitGroup
+					inPathCh := make(chan string, numWorkers)
+					for i := 0; i < numWorkers; i++ {
+						wg.Add(1)
+						go syncer.VerifyFingerprint(syncCtx,
+							&wg, i,
+							destinationVault,
+							destinationInfo, pack, fingerprinter,
+							inPathCh,
+							errCh)
+					}
+					go sendPaths(syncCtx, inPathCh, destinationInfo.Paths())
+					wg.Wait()
+				}
+			}
+
+			err = destinationInfo.Reindex()
+			if err != nil {
+				errCh <- apperr.New(fmt.Sprintf("cannot reindex destination info"), err, op, ErrInvalidInfo)
+			}
+
 			// compare sync info
 			addTasks, updateTasks, deleteTasks, errs := originfo.Compare(destinationInfo)
 			for _, err := range errs {
 				errCh <- apperr.New(fmt.Sprintf("cannot compare origin and destination infos"), err, op, ErrInvalidInsight)
 			}
 
+			// changes undone by an operator are not synced again till origin moves on
+			addTasks, updateTasks, deleteTasks = filterUndone(destinationStore, addTasks, updateTasks, deleteTasks, errCh)
+
+			// secrets in destination which origin never had, pruned as delete tasks
+			if len(syncer.StrictMirrors) > 0 {
+				if audit {
+					prunes = strictMirror(destinationVault, originfo, pack, errCh)
+				}
+				deleteTasks = append(deleteTasks, prunes...)
+			}
+			if audit {
+				lastAudit = time.Now()
+			}
+
+			// deletes and too many updates wait for approval, adds and the rest keep flowing
+			approved := false
+			if syncer.ApproveDeletes || syncer.ApproveUpdatesOver > 0 {
+				updateTasks, deleteTasks, approved = gatePlan(destinationStore, updateTasks, deleteTasks, errCh)
+			}
+			if !syncer.ApproveDeletes || approved {
+				prunes = nil
+			}
+
 			telemetryClient.Gauge("vsync.destination.paths.to_be_processed", float64(len(addTasks)), "operation:add")
 			telemetryClient.Gauge("vsync.destination.paths.to_be_processed", float64(len(updateTasks)), "operation:update")
 			telemetryClient.Gauge("vsync.destination.paths.to_be_processed", float64(len(deleteTasks)), "operation:delete")
@@ -486,28 +755,32 @@ func destinationSync(ctx context.Context, name string,
 
 			// create go routines for fetch and save and inturn saves to destination sync info
 			var wg sync.WaitGroup
+			var journal *syncer.Journal
+			if syncer.JournalCycles > 0 {
+				journal = syncer.NewJournal(time.Now())
+			}
 			inTaskCh := make(chan syncer.Task, numWorkers)
 			for i := 0; i < numWorkers; i++ {
 				wg.Add(1)
 				go syncer.FetchAndSave(syncCtx,
 					&wg, i,
 					originVault, destinationVault,
-					destinationInfo, pack,
+					destinationInfo, pack, originHeader.Origin, journal,
 					inTaskCh,
 					errCh)
 			}
 
-			// create go routine to save sync info to consul
+			// create go routine to save sync info to store
 			// 1 buffer to unblock this main routine in case timeout closes gather go routine
 			// so no one exists to send data in saved channel which blocks the main routine
 			saveCh := make(chan bool, 1)
 			doneCh := make(chan bool, 1)
-			go saveInfoToConsul(syncCtx,
-				destinationInfo, destinationConsul, destinationSyncPath,
+			go saveInfoToStore(syncCtx,
+				destinationInfo, destinationStore, since,
 				saveCh, doneCh, errCh)
 
-			// no changes
-			if len(addTasks) == 0 && len(updateTasks) == 0 && len(deleteTasks) == 0 {
+			// no changes, but a migrated or reindexed sync info still needs to be saved
+			if len(addTasks) == 0 && len(updateTasks) == 0 && len(deleteTasks) == 0 && !migrated && !reindexed {
 				log.Info().Msg("no changes from origin")
 
 				syncCancel()
@@ -526,17 +799,33 @@ func destinationSync(ctx context.Context, name string,
 			// 	which takes at most 1 minute * number of retries per client call
 			wg.Wait()
 
+			// journal of this cycle for vsync destination undo
+			if journal.Len() > 0 {
+				if err := syncer.JournalToStore(destinationStore, journal, syncer.JournalCycles); err != nil {
+					errCh <- apperr.New(fmt.Sprintf("cannot save journal of cycle %q", journal.Cycle), err, op, ErrInvalidInfo)
+				} else {
+					log.Info().Str("cycle", journal.Cycle).Int("changes", journal.Len()).Msg("saved journal of destination changes, undo with vsync destination undo --cycle")
+				}
+			}
+
+			// tasks of approved plan which failed are held again in a new plan next cycle
+			if approved {
+				if err := syncer.ClearPlan(destinationStore); err != nil {
+					errCh <- apperr.New(fmt.Sprintf("cannot clear approved plan"), err, op, ErrInvalidInfo)
+				}
+			}
+
 			err = destinationInfo.Reindex()
 			if err != nil {
 				errCh <- apperr.New(fmt.Sprintf("cannot reindex destination info"), err, op, ErrInvalidInfo)
 			}
 
-			// trigger save info to consul and wait for done
+			// trigger save info to store and wait for done
 			saveCh <- true
 			close(saveCh)
 
 			if ok := <-doneCh; ok {
-				log.Info().Int("buckets", numBuckets).Msg("saved origin sync info in consul")
+				log.Info().Int("buckets", layout.NumBuckets).Str("bucketing", layout.Bucketing).Str("store", destinationStore.String()).Msg("saved destination sync info in store")
 			} else {
 				errCh <- apperr.New(fmt.Sprintf("cannot save origin, mostly due to timeout"), ErrTimout, op, apperr.Fatal)
 			}
@@ -586,6 +875,235 @@ func getTransfomerPack() (transformer.Pack, error) {
 	return p, nil
 }
 
+// getDeletePolicies from config, vault token needs update on destroy and undelete paths of mounts allowing them
+func getDeletePolicies(v *vault.Client, destinationMounts []string) (map[string]syncer.DeletePolicy, error) {
+	const op = apperr.Op("cmd.getDeletePolicies")
+	policies := map[string]syncer.DeletePolicy{}
+
+	ds := []struct {
+		Mount string   `json:"mount"`
+		Allow []string `json:"allow"`
+	}{}
+	err := viper.UnmarshalKey("destination.deletions", &ds)
+	if err != nil {
+		log.Debug().Err(err).Str("lookup", "destination.deletions").Msg("cannot get or unmarshal delete policies from config")
+		return policies, apperr.New(fmt.Sprintf("cannot get or unmarshal delete policies from config %q", "destination.deletions"), err, op, apperr.Fatal, ErrInitialize)
+	}
+
+	for _, d := range ds {
+		known := false
+		for _, mount := range destinationMounts {
+			if d.Mount == mount {
+				known = true
+			}
+		}
+		if !known {
+			log.Debug().Str("mount", d.Mount).Strs("mounts", destinationMounts).Msg("delete policy for a mount which is not in destination mounts")
+			return policies, apperr.New(fmt.Sprintf("delete policy for mount %q not in destination mounts", d.Mount), ErrInitialize, op, apperr.Fatal)
+		}
+
+		policy, err := syncer.NewDeletePolicy(d.Allow)
+		if err != nil {
+			log.Debug().Err(err).Str("mount", d.Mount).Strs("allow", d.Allow).Msg("cannot get delete policy")
+			return policies, apperr.New(fmt.Sprintf("cannot get delete policy for mount %q", d.Mount), err, op, apperr.Fatal, ErrInitialize)
+		}
+
+		for _, kind := range []string{syncer.DeletedDestroy, syncer.Undelete} {
+			if !policy[kind] {
+				continue
+			}
+			p := fmt.Sprintf("%s%s/", d.Mount, kind)
+			err = v.CheckTokenPermissions(p, vault.CheckUpdate)
+			if err != nil {
+				log.Debug().Err(err).Str("path", p).Msg("vault token missing update permission for delete policy")
+				return policies, apperr.New(fmt.Sprintf("vault token missing update permission on path %q for delete policy", p), err, op, apperr.Fatal, ErrInitialize)
+			}
+		}
+
+		policies[d.Mount] = policy
+		log.Info().Str("mount", d.Mount).Strs("allow", d.Allow).Msg("delete policy for destination mount")
+	}
+	return policies, nil
+}
+
+// getStrictMirrors from config, a mount deleting unmanaged secrets cannot ignore deletes
+func getStrictMirrors(destinationMounts []string) (map[string]string, error) {
+	const op = apperr.Op("cmd.getStrictMirrors")
+	mirrors := map[string]string{}
+
+	ms := []struct {
+		Mount  string `json:"mount"`
+		Action string `json:"action"`
+	}{}
+	err := viper.UnmarshalKey("destination.strictMirror", &ms)
+	if err != nil {
+		log.Debug().Err(err).Str("lookup", "destination.strictMirror").Msg("cannot get or unmarshal strict mirror mounts from config")
+		return mirrors, apperr.New(fmt.Sprintf("cannot get or unmarshal strict mirror mounts from config %q", "destination.strictMirror"), err, op, apperr.Fatal, ErrInitialize)
+	}
+
+	for _, m := range ms {
+		known := false
+		for _, mount := range destinationMounts {
+			if m.Mount == mount {
+				known = true
+			}
+		}
+		if !known {
+			log.Debug().Str("mount", m.Mount).Strs("mounts", destinationMounts).Msg("strict mirror for a mount which is not in destination mounts")
+			return mirrors, apperr.New(fmt.Sprintf("strict mirror for mount %q not in destination mounts", m.Mount), ErrInitialize, op, apperr.Fatal)
+		}
+
+		if m.Action == "" {
+			m.Action = syncer.MirrorReport
+		}
+		if err := syncer.CheckMirrorAction(m.Action); err != nil {
+			return mirrors, apperr.New(fmt.Sprintf("cannot get strict mirror for mount %q", m.Mount), err, op, apperr.Fatal, ErrInitialize)
+		}
+		if m.Action == syncer.MirrorDelete && syncer.IgnoreDeletes {
+			return mirrors, apperr.New(fmt.Sprintf("strict mirror of mount %q cannot delete when ignore deletes is true", m.Mount), ErrInitialize, op, apperr.Fatal)
+		}
+
+		mirrors[m.Mount] = m.Action
+		log.Info().Str("mount", m.Mount).Str("action", m.Action).Msg("strict mirror for destination mount")
+	}
+	return mirrors, nil
+}
+
+// strictMirror walks strict mirror mounts, reports destination secrets without origin counterpart or returns prune tasks for them
+// deleting needs tombstones in origin, only then a path missing in origin sync info is really gone and not just missed by origin walk
+// prunes are delete tasks, so they wait for approval like other deletes and run in fetch and save workers
+func strictMirror(destinationVault *vault.Client, originfo *syncer.Info, pack transformer.Pack, errCh chan error) []syncer.Task {
+	const op = apperr.Op("cmd.strictMirror")
+
+	counterparts := originfo.Counterparts(destinationVault, pack)
+	tombstones := originfo.Header().Tombstones
+	// unknown origin paths cannot be transformed back from destination paths, so any of them stops pruning
+	unknown := len(originfo.Header().Unknown)
+
+	prunes := []syncer.Task{}
+	for mount, action := range syncer.StrictMirrors {
+		metaPath := strings.TrimSuffix(mount, "/")
+		if destinationVault.KVVersion(mount) == 2 {
+			metaPath = fmt.Sprintf("%smetadata", mount)
+		}
+		listed, _, errs := destinationVault.GetAllPaths([]string{metaPath})
+		for _, err := range errs {
+			errCh <- apperr.New(fmt.Sprintf("cannot recursively walk through strict mirror path %q", metaPath), err, op, ErrInvalidVPath)
+		}
+
+		unmanaged := syncer.Unmanaged(destinationVault, listed, counterparts)
+		telemetryClient.Gauge("vsync.destination.paths.unmanaged", float64(len(unmanaged)), "mount:"+mount)
+		if action == syncer.MirrorDelete && !tombstones {
+			log.Warn().Str("mount", mount).Msg("origin does not record tombstones, so unmanaged secrets are only reported and not deleted")
+			action = syncer.MirrorReport
+		}
+		if action == syncer.MirrorDelete && unknown > 0 {
+			log.Warn().Str("mount", mount).Int("unknown", unknown).Msg("origin could not walk or read some paths, so unmanaged secrets are only reported and not deleted")
+			action = syncer.MirrorReport
+		}
+
+		for _, path := range unmanaged {
+			if action == syncer.MirrorReport {
+				log.Warn().Str("path", path).Msg("destination secret has no counterpart in origin")
+				continue
+			}
+			kind, err := syncer.PruneKind(destinationVault, path)
+			if err != nil {
+				errCh <- apperr.New(fmt.Sprintf("cannot check path %q without counterpart in origin", path), err, op)
+				continue
+			}
+			if kind != "" {
+				prunes = append(prunes, syncer.Task{Path: path, Op: "prune", Insight: syncer.Insight{Deleted: kind}})
+			}
+		}
+		log.Info().Str("mount", mount).Str("action", action).Int("unmanaged", len(unmanaged)).Msg("strict mirror of destination mount")
+	}
+	return prunes
+}
+
+// filterUndone drops tasks making changes again which an operator undid, a store error keeps all tasks
+func filterUndone(s syncer.Store, addTasks []syncer.Task, updateTasks []syncer.Task, deleteTasks []syncer.Task, errCh chan error) ([]syncer.Task, []syncer.Task, []syncer.Task) {
+	const op = apperr.Op("cmd.filterUndone")
+
+	marks, err := syncer.MarksFromStore(s)
+	if err != nil {
+		errCh <- apperr.New(fmt.Sprintf("cannot get undone marks, syncing all tasks"), err, op, ErrInvalidInfo)
+		return addTasks, updateTasks, deleteTasks
+	}
+	if len(marks) == 0 {
+		return addTasks, updateTasks, deleteTasks
+	}
+
+	before := len(addTasks) + len(updateTasks) + len(deleteTasks)
+	addTasks, addChanged := syncer.FilterUndone(marks, addTasks)
+	updateTasks, updateChanged := syncer.FilterUndone(marks, updateTasks)
+	deleteTasks, deleteChanged := syncer.FilterUndone(marks, deleteTasks)
+	skipped := before - len(addTasks) - len(updateTasks) - len(deleteTasks)
+	if skipped > 0 {
+		log.Info().Int("count", skipped).Msg("paths undone by an operator are not synced again till origin changes them")
+	}
+	telemetryClient.Gauge("vsync.destination.paths.undone", float64(skipped))
+
+	if addChanged || updateChanged || deleteChanged {
+		if err := syncer.MarksToStore(s, marks); err != nil {
+			errCh <- apperr.New(fmt.Sprintf("cannot save undone marks"), err, op, ErrInvalidInfo)
+		}
+	}
+	return addTasks, updateTasks, deleteTasks
+}
+
+// gatePlan holds tasks which need approval in pending plan of destination store and returns tasks which can go on
+// an approved plan with the same tasks lets all of them go on and is true, a store error holds them for safety
+func gatePlan(s syncer.Store, updateTasks []syncer.Task, deleteTasks []syncer.Task, errCh chan error) ([]syncer.Task, []syncer.Task, bool) {
+	const op = apperr.Op("cmd.gatePlan")
+
+	updates, deletes, held := syncer.Gate(updateTasks, deleteTasks)
+	telemetryClient.Gauge("vsync.destination.paths.held", float64(len(held)))
+
+	pending, ok, err := syncer.PlanFromStore(s)
+	if err != nil {
+		errCh <- apperr.New(fmt.Sprintf("cannot get pending plan, holding tasks which need approval"), err, op, ErrInvalidInfo)
+		return updates, deletes, false
+	}
+	if len(held) == 0 {
+		if ok {
+			log.Info().Str("plan", pending.ID).Msg("nothing needs approval anymore, removing pending plan")
+			if err := syncer.ClearPlan(s); err != nil {
+				errCh <- apperr.New(fmt.Sprintf("cannot remove pending plan %q", pending.ID), err, op, ErrInvalidInfo)
+			}
+		}
+		return updates, deletes, false
+	}
+
+	now := time.Now()
+	if ok && pending.Holds(held) {
+		if !pending.Expired(now) {
+			approved, err := syncer.Approved(s, pending)
+			if err != nil {
+				errCh <- apperr.New(fmt.Sprintf("cannot get approval of plan %q", pending.ID), err, op, ErrInvalidInfo)
+			}
+			if approved {
+				log.Info().Str("plan", pending.ID).Int("deletes", pending.Deletes).Int("updates", pending.Updates).Msg("plan is approved, syncing its tasks")
+				return updateTasks, deleteTasks, true
+			}
+			log.Warn().Str("plan", pending.ID).Int("deletes", pending.Deletes).Int("updates", pending.Updates).Time("expires", pending.Expires).Msg("plan is waiting for approval, run vsync approve with its id")
+			return updates, deletes, false
+		}
+		log.Warn().Str("plan", pending.ID).Time("expired", pending.Expires).Msg("plan expired without approval, making a new plan")
+	}
+
+	plan, err := syncer.NewPlan(held, now, syncer.PlanTTL)
+	if err == nil {
+		err = syncer.PlanToStore(s, plan)
+	}
+	if err != nil {
+		errCh <- apperr.New(fmt.Sprintf("cannot save plan, holding tasks which need approval"), err, op, ErrInvalidInfo)
+		return updates, deletes, false
+	}
+	log.Warn().Str("plan", plan.ID).Int("deletes", plan.Deletes).Int("updates", plan.Updates).Time("expires", plan.Expires).Msg("new plan is waiting for approval, run vsync approve with its id")
+	return updates, deletes, false
+}
+
 // tasks to update destination based on origin
 func sendTasks(ctx context.Context, taskCh chan syncer.Task, addTasks []syncer.Task, updateTasks []syncer.Task, deleteTasks []syncer.Task) {
 	defer close(taskCh)
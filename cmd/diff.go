@@ -0,0 +1,88 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/ExpediaGroup/vsync/apperr"
+	"github.com/ExpediaGroup/vsync/syncer"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	diffCmd.Flags().String("prefix", "", "origin path prefix to compare, like secret/data/app1")
+
+	destinationCmd.AddCommand(diffCmd)
+}
+
+var diffCmd = &cobra.Command{
+	Use:           "diff --prefix <path>",
+	Short:         "Shows tasks next destination cycle would do for origin paths under a prefix",
+	Long:          `Compares origin and destination sync info only under prefix, like all secrets of one application, without changing anything. With prefix bucketing only the subtree of prefix is compared`,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		const op = apperr.Op("cmd.diff")
+
+		prefix, _ := cmd.Flags().GetString("prefix")
+		if prefix == "" {
+			return apperr.New(fmt.Sprintf("give origin path prefix with %q", "--prefix"), ErrInitialize, op, apperr.Fatal)
+		}
+		numBuckets := viper.GetInt("numBuckets")
+
+		_, originStore, err := getCheckedStore("origin")
+		if err != nil {
+			return err
+		}
+		_, destinationStore, err := getCheckedStore("destination")
+		if err != nil {
+			return err
+		}
+
+		originfo, err := syncer.NewInfo(numBuckets)
+		if err == nil {
+			err = syncer.InfoFromStore(originStore, originfo)
+		}
+		if err != nil {
+			return apperr.New(fmt.Sprintf("cannot get sync info in store %q", originStore), err, op, apperr.Fatal, ErrInvalidInfo)
+		}
+		destinationInfo, err := syncer.NewInfo(numBuckets)
+		if err == nil {
+			err = syncer.InfoFromStore(destinationStore, destinationInfo)
+		}
+		if err != nil {
+			return apperr.New(fmt.Sprintf("cannot get sync info in store %q", destinationStore), err, op, apperr.Fatal, ErrInvalidInfo)
+		}
+
+		addTasks, updateTasks, deleteTasks, errs := originfo.ComparePrefix(destinationInfo, prefix)
+		for _, err := range errs {
+			log.Error().Err(err).Str("prefix", prefix).Msg("cannot compare origin and destination infos")
+		}
+		for _, tasks := range [][]syncer.Task{addTasks, updateTasks, deleteTasks} {
+			for _, task := range tasks {
+				log.Info().Str("path", task.Path).Str("operation", task.Op).Int64("version", task.Insight.Version).Str("deleted", task.Insight.Deleted).Msg("task under prefix")
+			}
+		}
+		log.Info().Str("prefix", prefix).Int("add", len(addTasks)).Int("update", len(updateTasks)).Int("delete", len(deleteTasks)).Msg("compared origin and destination under prefix")
+
+		if len(errs) > 0 {
+			return apperr.New(fmt.Sprintf("cannot compare %d buckets under prefix %q", len(errs), prefix), ErrInvalidInfo, op, apperr.Fatal)
+		}
+		return nil
+	},
+}
@@ -16,9 +16,7 @@ package cmd
 
 import (
 	"context"
-	"crypto/sha256"
 	"fmt"
-	"hash"
 	"os"
 	"os/signal"
 	"strings"
@@ -27,7 +25,6 @@ import (
 	"time"
 
 	"github.com/ExpediaGroup/vsync/apperr"
-	"github.com/ExpediaGroup/vsync/consul"
 	"github.com/ExpediaGroup/vsync/syncer"
 	"github.com/ExpediaGroup/vsync/vault"
 	"github.com/rs/zerolog/log"
@@ -38,10 +35,25 @@ import (
 func init() {
 	viper.SetDefault("name", "origin") // name is required for mount checks and telemetry
 	viper.SetDefault("numBuckets", 1)  // we need atleast one bucket to store info
+	viper.SetDefault("bucketing", syncer.BucketingModulo)
+	viper.SetDefault("indexFanout", 0)
+	viper.SetDefault("prefixDepth", 1)
+	viper.SetDefault("bucketCompression", syncer.CompressionNone)
+	viper.SetDefault("maxBucketSize", syncer.DefaultMaxBucketSize)
+	viper.SetDefault("hashAlgorithm", syncer.HashSHA256)
+	viper.SetDefault("indexSerialization", syncer.SerializationFmt)
 	viper.SetDefault("origin.tick", "10s")
 	viper.SetDefault("origin.timeout", "5m")
 	viper.SetDefault("origin.syncPath", "vsync/")
 	viper.SetDefault("origin.numWorkers", 1) // we need atleast 1 worker or else the sync routine will be blocked
+	viper.SetDefault("origin.tombstones", false)
+	viper.SetDefault("origin.tombstoneTTL", "720h")
+	viper.SetDefault("origin.secretMetadata", false)
+	viper.SetDefault("origin.store.type", syncer.StoreConsul)
+	viper.SetDefault("origin.maxPathDrop", 0)
+	viper.SetDefault("origin.maxPathDropPercent", 0)
+
+	originCmd.Flags().Bool("origin.acceptDrop", false, "publish the next sync info even if its number of paths dropped more than allowed")
 
 	if err := viper.BindPFlags(originCmd.PersistentFlags()); err != nil {
 		log.Panic().
@@ -77,13 +89,11 @@ var originCmd = &cobra.Command{
 
 		// initial configs
 		name := viper.GetString("name")
-		numBuckets := viper.GetInt("numBuckets")
 		tick := viper.GetDuration("origin.tick")
 		timeout := viper.GetDuration("origin.timeout")
 		numWorkers := viper.GetInt("origin.numWorkers")
 		originSyncPath := viper.GetString("origin.syncPath")
 		originMounts := viper.GetStringSlice("origin.mounts")
-		hasher := sha256.New()
 
 		// deprecated
 		syncPathDepr := viper.GetString("syncPath")
@@ -97,6 +107,27 @@ var originCmd = &cobra.Command{
 			return apperr.New(fmt.Sprintf("parameter %q deprecated, use %q", "origin.dc", "origin.consul.dc"), ErrInitialize, op, apperr.Fatal)
 		}
 
+		layout, err := getLayout("origin")
+		if err != nil {
+			return err
+		}
+
+		syncer.MaxPathDrop = viper.GetInt("origin.maxPathDrop")
+		syncer.MaxPathDropPercent = viper.GetFloat64("origin.maxPathDropPercent")
+		syncer.AcceptDrop = viper.GetBool("origin.acceptDrop")
+		if syncer.MaxPathDrop < 0 || syncer.MaxPathDropPercent < 0 || syncer.MaxPathDropPercent > 100 {
+			return apperr.New(fmt.Sprintf("%q must not be negative and %q must be between 0 and 100", "origin.maxPathDrop", "origin.maxPathDropPercent"), ErrInitialize, op, apperr.Fatal)
+		}
+
+		syncer.Tombstones = viper.GetBool("origin.tombstones")
+		syncer.TombstoneTTL = viper.GetDuration("origin.tombstoneTTL")
+		syncer.SecretMetadata = viper.GetBool("origin.secretMetadata")
+
+		fingerprinter, err := getFingerprinter("origin")
+		if err != nil {
+			return err
+		}
+
 		// telemetry client
 		telemetryClient.AddTags("mpaas_application_name:vsync_" + name)
 
@@ -113,7 +144,13 @@ var originCmd = &cobra.Command{
 		}
 		originSyncPath = originSyncPath + "origin/" // adds type into sync path, useful in case we use same syncPath in same consul
 
-		err = originConsul.SyncPathChecks(originSyncPath, consul.StdCheck)
+		originStore, err := getStore("origin", originConsul, originVault, originSyncPath)
+		if err != nil {
+			log.Debug().Err(err).Str("path", originSyncPath).Msg("cannot get sync info store on origin")
+			return apperr.New(fmt.Sprintf("cannot get sync info store for %q", originSyncPath), err, op, apperr.Fatal, ErrInitialize)
+		}
+
+		err = originStore.Checks()
 		if err != nil {
 			log.Debug().Err(err).Str("path", originSyncPath).Msg("failures on sync path checks on origin")
 			return apperr.New(fmt.Sprintf("sync path checks failed for %q", originSyncPath), err, op, apperr.Fatal, ErrInitialize)
@@ -135,6 +172,11 @@ var originCmd = &cobra.Command{
 				log.Debug().Err(err).Msg("failures on mount checks on origin")
 				return apperr.New(fmt.Sprintf("failures on mount checks on origin"), err, op, apperr.Fatal, ErrInitialize)
 			}
+			// kv v1 has no versions, insights are fingerprints of content
+			if originVault.KVVersion(mount) == 1 && fingerprinter == nil {
+				log.Debug().Str("mount", mount).Msg("kv v1 mount on origin without fingerprint key")
+				return apperr.New(fmt.Sprintf("kv v1 mount %q needs %q or %q", mount, "fingerprint.key", "fingerprint.keyFile"), ErrInitialize, op, apperr.Fatal)
+			}
 		}
 		log.Info().Strs("mounts", originMounts).Msg("mounts passed initial checks on origin")
 
@@ -147,10 +189,11 @@ var originCmd = &cobra.Command{
 
 		// start the sync go routine
 		go originSync(ctx, name,
-			originConsul, originVault,
+			originStore, originVault,
 			tick, timeout,
-			originSyncPath, originMounts,
-			hasher, numBuckets, numWorkers,
+			originMounts,
+			layout, numWorkers,
+			fingerprinter,
 			errCh)
 
 		// origin token renewer go routine
@@ -197,15 +240,24 @@ var originCmd = &cobra.Command{
 }
 
 func originSync(ctx context.Context, name string,
-	originConsul *consul.Client, originVault *vault.Client,
+	originStore syncer.Store, originVault *vault.Client,
 	tick time.Duration, timeout time.Duration,
-	originSyncPath string, originMounts []string,
-	hasher hash.Hash, numBuckets int, numWorkers int,
+	originMounts []string,
+	layout syncer.Layout, numWorkers int,
+	fingerprinter *syncer.Fingerprinter,
 	errCh chan error) {
 	const op = apperr.Op("cmd.originSync")
 
+	// operator accepts a drop of paths only for the next publish
+	acceptDrop := syncer.AcceptDrop
+
 	metaPaths := []string{}
 	for _, mount := range originMounts {
+		if originVault.KVVersion(mount) == 1 {
+			// kv v1 secrets are listed right under mount
+			metaPaths = append(metaPaths, strings.TrimSuffix(mount, "/"))
+			continue
+		}
 		metaPaths = append(metaPaths, fmt.Sprintf("%smetadata", mount))
 	}
 
@@ -220,7 +272,7 @@ func originSync(ctx context.Context, name string,
 			telemetryClient.Count("vsync.origin.cycle", 1, "status:stopped")
 			log.Debug().Str("trigger", "context done").Msg("closed origin sync")
 			return
-		case <-ticker.C:
+		case cycleStart := <-ticker.C:
 
 			telemetryClient.Count("vsync.origin.cycle", 1, "status:started")
 			log.Info().Msg("")
@@ -243,17 +295,42 @@ func originSync(ctx context.Context, name string,
 				}
 			}
 
+			// sync index as this cycle starts, publish fails if another writer changes it meanwhile
+			since, err := syncer.IndexRevision(originStore)
+			if err != nil {
+				errCh <- apperr.New(fmt.Sprintf("cannot get revision of sync index in store %q", originStore), err, op, ErrInvalidInfo)
+
+				syncCancel()
+				time.Sleep(500 * time.Microsecond)
+				telemetryClient.Count("vsync.origin.cycle", 1, "status:failure")
+				log.Info().Msg("incomplete sync cycle, failure in getting revision of sync index\n")
+				continue
+			}
+
 			// create new sync info
-			originfo, err := syncer.NewInfo(numBuckets, hasher)
+			originfo, err := layout.NewInfo()
 			if err != nil {
-				errCh <- apperr.New(fmt.Sprintf("cannot create new sync info in path %q", originSyncPath), err, op, apperr.Fatal, ErrInitialize)
+				errCh <- apperr.New(fmt.Sprintf("cannot create new sync info in store %q", originStore), err, op, apperr.Fatal, ErrInitialize)
+
+				syncCancel()
+				time.Sleep(500 * time.Microsecond)
+				telemetryClient.Count("vsync.origin.cycle", 1, "status:failure")
+				log.Info().Msg("incomplete sync cycle, failure in creating new sync info\n")
+				return
 			}
+			originfo.SetOrigin(name, cycleStart)
+			originfo.SetFingerprintKey(fingerprinter.KeyID())
+			originfo.SetTombstones(syncer.Tombstones)
+			originfo.SetSecretMetadata(syncer.SecretMetadata)
 
 			// walk recursively to get all secret absolute paths
-			paths, errs := originVault.GetAllPaths(metaPaths)
+			// subtrees which cannot be listed are unknown, destinations keep what they have under them
+			paths, failed, errs := originVault.GetAllPaths(metaPaths)
 			for _, err := range errs {
-				// TODO: make sure this does not print the same last error because we are using range
-				errCh <- apperr.New(fmt.Sprintf("cannot recursively walk through paths %q", metaPaths), err, op, apperr.Fatal, ErrInitialize)
+				errCh <- apperr.New(fmt.Sprintf("cannot recursively walk through paths %q", metaPaths), err, op, ErrInitialize)
+			}
+			for _, metaPath := range failed {
+				originfo.MarkUnknown(syncer.UnknownSubtree(originVault, metaPath))
 			}
 			telemetryClient.Gauge("vsync.origin.paths.to_be_processed", float64(len(paths)))
 			log.Info().Int("numPaths", len(paths)).Msg("generating origin sync info for paths")
@@ -265,18 +342,18 @@ func originSync(ctx context.Context, name string,
 				wg.Add(1)
 				go syncer.GenerateInsight(syncCtx,
 					&wg, i,
-					originVault, originfo,
+					originVault, originfo, fingerprinter,
 					inPathCh,
 					errCh)
 			}
 
-			// create go routine to save sync info to consul
+			// create go routine to save sync info to store
 			// 1 buffer to unblock this main routine in case timeout closes gather go routine
 			// so no one exists to send data in saved channel which blocks the main routine
 			saveCh := make(chan bool, 1)
 			doneCh := make(chan bool, 1)
-			go saveInfoToConsul(syncCtx,
-				originfo, originConsul, originSyncPath,
+			go saveInfoToStore(syncCtx,
+				originfo, originStore, since,
 				saveCh, doneCh, errCh)
 
 			// we need to send path to workers as well as watch for context done
@@ -289,16 +366,57 @@ func originSync(ctx context.Context, name string,
 			// 	which takes at most 1 minute * number of retries per client call
 			wg.Wait()
 
+			// paths in last published sync info which are missing now become tombstones only if they are really gone
+			if syncer.Tombstones {
+				previous, err := syncer.NewInfo(layout.NumBuckets)
+				if err == nil {
+					err = syncer.InfoFromStore(originStore, previous)
+				}
+				if err != nil {
+					log.Warn().Err(err).Str("store", originStore.String()).Msg("cannot get last published origin sync info, removed paths are not tombstoned in this cycle")
+				} else {
+					tombstoned, errs := syncer.CarryTombstones(originfo, previous, syncer.VaultPathExists(originVault), cycleStart, syncer.TombstoneTTL)
+					for _, err := range errs {
+						errCh <- apperr.New(fmt.Sprintf("cannot carry tombstones from last published sync info"), err, op, ErrInvalidInfo)
+					}
+					log.Info().Int("tombstoned", tombstoned).Msg("carried tombstones from last published sync info")
+				}
+			}
+
 			err = originfo.Reindex()
 			if err != nil {
 				errCh <- apperr.New(fmt.Sprintf("cannot reindex origin info"), err, op, ErrInvalidInfo)
 			}
 
-			// trigger save info to consul and wait for done
+			reportUnknown(originVault, originfo, originMounts)
+
+			// an origin vault answering with too few paths must not be published, destinations would delete the rest
+			if syncer.MaxPathDrop > 0 || syncer.MaxPathDropPercent > 0 {
+				err := checkPathDrop(originStore, originfo, layout.NumBuckets)
+				if err != nil && acceptDrop {
+					log.Warn().Err(err).Msg("accepting drop of paths once as asked by operator")
+					acceptDrop = false
+					err = nil
+				}
+				if err != nil {
+					close(saveCh)
+					<-doneCh
+					errCh <- apperr.New(fmt.Sprintf("refusing to publish origin sync info, check origin vault or restart with --origin.acceptDrop"), err, op, apperr.Fatal)
+
+					syncCancel()
+					time.Sleep(500 * time.Microsecond)
+					telemetryClient.Count("vsync.origin.cycle", 1, "status:refused")
+					log.Info().Msg("incomplete sync cycle, number of paths dropped more than allowed\n")
+					return
+				}
+			}
+
+			// trigger save info to store and wait for done
 			saveCh <- true
 			close(saveCh)
 			if ok := <-doneCh; ok {
-				log.Info().Int("buckets", numBuckets).Msg("saved origin sync info in consul")
+				header := originfo.Header()
+				log.Info().Int("buckets", header.NumBuckets).Int("paths", header.NumPaths).Int("formatVersion", header.FormatVersion).Str("store", originStore.String()).Msg("saved origin sync info in store")
 			} else {
 				errCh <- apperr.New(fmt.Sprintf("cannot save origin sync info, mostly due to timeout"), ErrTimout, op, apperr.Fatal)
 			}
@@ -312,6 +430,53 @@ func originSync(ctx context.Context, name string,
 	}
 }
 
+// checkPathDrop compares number of paths in new origin sync info with last published one
+// paths unknown in this cycle are not a drop, so buckets of last published one are read only when there are unknown paths
+// when there is nothing published yet or it cannot be read, there is nothing to compare with
+func checkPathDrop(originStore syncer.Store, originfo *syncer.Info, numBuckets int) error {
+	header := originfo.Header()
+	previous, err := syncer.NewInfo(numBuckets)
+	if err == nil {
+		err = syncer.IndexFromStore(originStore, previous, nil)
+	}
+	if err == nil && len(header.Unknown) > 0 {
+		err = syncer.BucketsFromStore(originStore, previous, nil)
+	}
+	if err != nil {
+		log.Warn().Err(err).Str("store", originStore.String()).Msg("cannot get last published origin sync info, drop of paths is not checked in this cycle")
+		return nil
+	}
+
+	previousHeader := previous.Header()
+	unknown := previous.LiveUnknown(header.Unknown)
+	telemetryClient.Gauge("vsync.origin.paths.dropped", float64(previousHeader.NumPaths-unknown-header.NumPaths))
+	return syncer.CheckPathDrop(previousHeader, header, unknown, syncer.MaxPathDrop, syncer.MaxPathDropPercent)
+}
+
+// reportUnknown warns per mount about subtrees and paths origin could not walk or read in this cycle
+// destinations keep their secrets under them, so a failing mount root means nothing of that mount is synced
+func reportUnknown(originVault *vault.Client, originfo *syncer.Info, originMounts []string) {
+	unknown := originfo.Header().Unknown
+	for _, mount := range originMounts {
+		root := mount
+		if originVault.KVVersion(mount) == 2 {
+			root = mount + "data/"
+		}
+		subtrees, paths := syncer.CountUnknown(unknown, mount)
+		telemetryClient.Gauge("vsync.origin.paths.unknown", float64(subtrees+paths), "mount:"+mount)
+		if subtrees+paths == 0 {
+			continue
+		}
+		rootFailed := false
+		for _, p := range unknown {
+			if p == root {
+				rootFailed = true
+			}
+		}
+		log.Warn().Str("mount", mount).Int("subtrees", subtrees).Int("paths", paths).Bool("rootFailed", rootFailed).Msg("origin could not walk or read some paths, they are unknown to destinations in this cycle")
+	}
+}
+
 func sendPaths(ctx context.Context, pathCh chan string, paths []string) {
 	defer close(pathCh)
 
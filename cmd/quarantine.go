@@ -0,0 +1,167 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ExpediaGroup/vsync/apperr"
+	"github.com/ExpediaGroup/vsync/syncer"
+	"github.com/ExpediaGroup/vsync/vault"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	quarantineRestoreCmd.Flags().Bool("force", false, "replace destination secret even if it has data")
+	quarantinePurgeCmd.Flags().Duration("older-than", 0, "purge every quarantined secret older than this, like 720h")
+
+	quarantineCmd.AddCommand(quarantineListCmd, quarantineRestoreCmd, quarantinePurgeCmd)
+	rootCmd.AddCommand(quarantineCmd)
+}
+
+var quarantineCmd = &cobra.Command{
+	Use:   "quarantine",
+	Short: "Manages destination secrets archived in quarantine before vsync deleted them",
+	Long:  `Lists, restores and purges secrets in destination.quarantine.mount which destination archived with their metadata and reason before deleting them`,
+}
+
+var quarantineListCmd = &cobra.Command{
+	Use:           "list",
+	Short:         "Lists quarantined secrets without their data",
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		v, err := getQuarantineVault(false)
+		if err != nil {
+			return err
+		}
+
+		items, errs := syncer.Quarantined(v)
+		for _, err := range errs {
+			log.Warn().Err(err).Msg("cannot read quarantined secret")
+		}
+		for _, item := range items {
+			log.Info().Str("id", item.ID).Str("path", item.Path).Str("originPath", item.OriginPath).Int64("version", item.Version).Time("time", item.Time).Str("reason", item.Reason).Msg("quarantined secret")
+		}
+		log.Info().Int("quarantined", len(items)).Int("failed", len(errs)).Str("mount", syncer.QuarantineMount).Msg("listed quarantine")
+		return nil
+	},
+}
+
+var quarantineRestoreCmd = &cobra.Command{
+	Use:           "restore <id>...",
+	Short:         "Restores quarantined secrets to their destination paths and removes them from quarantine",
+	Long:          `Writes data and metadata settings of each quarantined secret back to its destination path. Destination sync info still has the delete, so the secret stays until origin changes it`,
+	Args:          cobra.MinimumNArgs(1),
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		const op = apperr.Op("cmd.quarantineRestore")
+
+		force, _ := cmd.Flags().GetBool("force")
+		v, err := getQuarantineVault(true)
+		if err != nil {
+			return err
+		}
+
+		failed := 0
+		for _, id := range args {
+			item, err := syncer.RestoreQuarantined(v, id, force)
+			if err != nil {
+				failed++
+				log.Error().Err(err).Str("id", id).Msg("cannot restore quarantined secret")
+				continue
+			}
+			log.Info().Str("id", id).Str("path", item.Path).Msg("restored quarantined secret")
+		}
+		if failed > 0 {
+			return apperr.New(fmt.Sprintf("cannot restore %d of %d quarantined secrets", failed, len(args)), ErrInvalidVPath, op, apperr.Fatal)
+		}
+		return nil
+	},
+}
+
+var quarantinePurgeCmd = &cobra.Command{
+	Use:           "purge [<id>...]",
+	Short:         "Removes quarantined secrets with all their versions",
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		const op = apperr.Op("cmd.quarantinePurge")
+
+		olderThan, _ := cmd.Flags().GetDuration("older-than")
+		if len(args) == 0 && olderThan <= 0 {
+			return apperr.New(fmt.Sprintf("give ids of quarantined secrets or %q", "--older-than"), ErrInitialize, op, apperr.Fatal)
+		}
+		v, err := getQuarantineVault(false)
+		if err != nil {
+			return err
+		}
+
+		ids := args
+		if olderThan > 0 {
+			items, errs := syncer.Quarantined(v)
+			for _, err := range errs {
+				log.Warn().Err(err).Msg("cannot read quarantined secret, not purging it")
+			}
+			for _, item := range items {
+				if time.Since(item.Time) > olderThan {
+					ids = append(ids, item.ID)
+				}
+			}
+		}
+
+		failed := 0
+		for _, id := range ids {
+			if err := syncer.PurgeQuarantined(v, id); err != nil {
+				failed++
+				log.Error().Err(err).Str("id", id).Msg("cannot purge quarantined secret")
+				continue
+			}
+			log.Info().Str("id", id).Msg("purged quarantined secret")
+		}
+		if failed > 0 {
+			return apperr.New(fmt.Sprintf("cannot purge %d of %d quarantined secrets", failed, len(ids)), ErrInvalidVPath, op, apperr.Fatal)
+		}
+		return nil
+	},
+}
+
+// getQuarantineVault returns destination vault with quarantine set from config
+// restoring writes to destination mounts, so their kv versions are checked too
+func getQuarantineVault(restore bool) (*vault.Client, error) {
+	const op = apperr.Op("cmd.getQuarantineVault")
+
+	_, v, err := getEssentials("destination")
+	if err != nil {
+		log.Debug().Err(err).Str("mode", "destination").Msg("cannot get essentials")
+		return nil, apperr.New(fmt.Sprintf("cannot get clients for mode %q", "destination"), err, op, apperr.Fatal, ErrInitialize)
+	}
+	if restore {
+		if err := checkMounts("destination", v, viper.GetStringSlice("destination.mounts"), vault.CheckDestination); err != nil {
+			return nil, err
+		}
+	}
+	if err := getQuarantine(v); err != nil {
+		return nil, err
+	}
+	if syncer.QuarantineMount == "" {
+		return nil, apperr.New(fmt.Sprintf("no quarantine, specify %q in config", "destination.quarantine.mount"), ErrInitialize, op, apperr.Fatal)
+	}
+	return v, nil
+}
@@ -0,0 +1,194 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/ExpediaGroup/vsync/apperr"
+	"github.com/ExpediaGroup/vsync/syncer"
+	"github.com/ExpediaGroup/vsync/vault"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	rebuildInfoCmd.Flags().Bool("force", false, "replace destination sync info even if it can be read")
+
+	destinationCmd.AddCommand(rebuildInfoCmd)
+}
+
+var rebuildInfoCmd = &cobra.Command{
+	Use:           "rebuild-info",
+	Short:         "Rebuilds destination sync info from secrets already in destination vault",
+	Long:          `Walks destination mounts, finds origin paths transforming onto each secret and saves origin insights of secrets equal to origin as destination sync info, so next cycle syncs only what really differs`,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		const op = apperr.Op("cmd.rebuildInfo")
+
+		ctx, cancel := context.WithTimeout(context.Background(), viper.GetDuration("destination.timeout"))
+		defer cancel()
+
+		// initial configs
+		numWorkers := viper.GetInt("destination.numWorkers")
+		originMounts := viper.GetStringSlice("origin.mounts")
+		destinationMounts := viper.GetStringSlice("destination.mounts")
+		force, _ := cmd.Flags().GetBool("force")
+
+		layout, err := getLayout("destination")
+		if err != nil {
+			return err
+		}
+		fingerprinter, err := getFingerprinter("destination")
+		if err != nil {
+			return err
+		}
+
+		originVault, originStore, err := getCheckedStore("origin")
+		if err != nil {
+			return err
+		}
+		destinationVault, destinationStore, err := getCheckedStore("destination")
+		if err != nil {
+			return err
+		}
+
+		// read and list is enough for comparing, kv version of mounts comes from checks
+		if err := checkMounts("origin", originVault, originMounts, vault.CheckOrigin); err != nil {
+			return err
+		}
+		if err := checkMounts("destination", destinationVault, destinationMounts, vault.CheckOrigin); err != nil {
+			return err
+		}
+
+		pack, err := getTransfomerPack()
+		if err != nil {
+			return apperr.New(fmt.Sprintf("cannot get transformer packs"), err, op, apperr.Fatal, ErrInitialize)
+		}
+
+		// destination cycles running meanwhile make saving the rebuilt sync info fail
+		since, err := syncer.IndexRevision(destinationStore)
+		if err != nil {
+			return apperr.New(fmt.Sprintf("cannot get revision of sync index in store %q", destinationStore), err, op, apperr.Fatal, ErrInvalidInfo)
+		}
+
+		// a readable sync info is replaced only on purpose
+		if !force {
+			existing, err := syncer.NewInfo(layout.NumBuckets)
+			if err == nil {
+				err = syncer.InfoFromStore(destinationStore, existing)
+			}
+			if err == nil {
+				return apperr.New(fmt.Sprintf("destination sync info in store %q can be read, use --force to replace it", destinationStore), ErrInitialize, op, apperr.Fatal)
+			}
+			log.Info().Err(err).Str("store", destinationStore.String()).Msg("destination sync info cannot be read, rebuilding")
+		}
+
+		originfo, err := syncer.NewInfo(layout.NumBuckets)
+		if err == nil {
+			err = syncer.InfoFromStore(originStore, originfo)
+		}
+		if err != nil {
+			log.Debug().Err(err).Str("store", originStore.String()).Msg("cannot get sync info from origin store")
+			return apperr.New(fmt.Sprintf("cannot get sync info in store %q", originStore), err, op, apperr.Fatal, ErrInvalidInfo)
+		}
+		originHeader := originfo.Header()
+		log.Info().Str("origin", originHeader.Origin).Str("cycleStart", originHeader.CycleStart).Msg("retrieved origin sync info")
+
+		info, err := layout.NewInfo()
+		if err != nil {
+			log.Debug().Err(err).Int("numBuckets", layout.NumBuckets).Msg("cannot create new destination sync info")
+			return apperr.New(fmt.Sprintf("cannot create new destination sync info"), err, op, apperr.Fatal, ErrInitialize)
+		}
+		// rebuilt insights carry origin fingerprints
+		info.SetFingerprintKey(originHeader.FingerprintKey)
+		if fingerprinter.KeyID() != originHeader.FingerprintKey {
+			fingerprinter = nil
+		}
+
+		// walk destination mounts
+		metaPaths := []string{}
+		for _, mount := range destinationMounts {
+			if destinationVault.KVVersion(mount) == 1 {
+				metaPaths = append(metaPaths, mount[:len(mount)-1])
+				continue
+			}
+			metaPaths = append(metaPaths, fmt.Sprintf("%smetadata", mount))
+		}
+		listed, _, errs := destinationVault.GetAllPaths(metaPaths)
+		for _, err := range errs {
+			log.Warn().Err(err).Msg("cannot walk destination path, its secrets are synced again in next cycle")
+		}
+
+		counterparts := originfo.Counterparts(destinationVault, pack)
+		paths := []string{}
+		for _, path := range syncer.DataPaths(destinationVault, listed) {
+			if originPath, ok := counterparts[path]; ok {
+				paths = append(paths, originPath)
+			}
+		}
+		log.Info().Int("listed", len(listed)).Int("withOrigin", len(paths)).Msg("walked destination mounts")
+
+		// compare with origin
+		var wg sync.WaitGroup
+		errCh := make(chan error, numWorkers)
+		failed := 0
+		logged := make(chan bool)
+		go func() {
+			for err := range errCh {
+				failed++
+				log.Warn().Err(err).Msg("cannot rebuild insight, path is synced again in next cycle")
+			}
+			logged <- true
+		}()
+
+		inPathCh := make(chan string, numWorkers)
+		for i := 0; i < numWorkers; i++ {
+			wg.Add(1)
+			go syncer.RebuildInsight(ctx,
+				&wg, i,
+				originVault, destinationVault,
+				originfo, info, pack, fingerprinter,
+				inPathCh,
+				errCh)
+		}
+		go sendPaths(ctx, inPathCh, paths)
+		wg.Wait()
+		close(errCh)
+		<-logged
+
+		if ctx.Err() != nil {
+			return apperr.New(fmt.Sprintf("rebuilding destination sync info took more than %q", viper.GetString("destination.timeout")), ctx.Err(), op, apperr.Fatal, ErrTimout)
+		}
+
+		err = info.Reindex()
+		if err != nil {
+			return apperr.New(fmt.Sprintf("cannot reindex rebuilt destination sync info"), err, op, apperr.Fatal, ErrInvalidInfo)
+		}
+		err = syncer.InfoToStore(destinationStore, info, since)
+		if err != nil {
+			log.Debug().Err(err).Str("store", destinationStore.String()).Msg("cannot save rebuilt sync info")
+			return apperr.New(fmt.Sprintf("cannot save rebuilt destination sync info in store %q", destinationStore), err, op, apperr.Fatal, ErrInvalidInfo)
+		}
+
+		rebuilt := len(info.Paths())
+		log.Info().Int("rebuilt", rebuilt).Int("toSync", len(paths)-rebuilt).Int("failed", failed).Str("store", destinationStore.String()).Msg("saved rebuilt destination sync info")
+		return nil
+	},
+}
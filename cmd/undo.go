@@ -0,0 +1,129 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ExpediaGroup/vsync/apperr"
+	"github.com/ExpediaGroup/vsync/syncer"
+	"github.com/ExpediaGroup/vsync/vault"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	undoCmd.Flags().String("cycle", "", "id of destination cycle to undo, as logged when its journal was saved")
+
+	destinationCmd.AddCommand(undoCmd)
+}
+
+var undoCmd = &cobra.Command{
+	Use:           "undo --cycle <id>",
+	Short:         "Puts destination secrets written or deleted by vsync in a cycle back to their versions before it",
+	Long:          `Reads journal of the cycle from destination sync path and rolls back each write to its prior kv v2 version, deletes again what was added or undeleted and undeletes soft deletes. Undone paths are marked, so next cycles do not sync the same change again till origin changes those paths. Without cycle, lists cycles with a journal`,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		const op = apperr.Op("cmd.undo")
+
+		cycle, _ := cmd.Flags().GetString("cycle")
+		destinationVault, destinationStore, err := getCheckedStore("destination")
+		if err != nil {
+			return err
+		}
+
+		if cycle == "" {
+			cycles, err := syncer.Journals(destinationStore)
+			if err != nil {
+				return err
+			}
+			for _, c := range cycles {
+				log.Info().Str("cycle", c).Msg("cycle with journal")
+			}
+			log.Info().Int("cycles", len(cycles)).Str("store", destinationStore.String()).Msg("give one of the cycles with --cycle to undo it")
+			return nil
+		}
+
+		if err := checkMounts("destination", destinationVault, viper.GetStringSlice("destination.mounts"), vault.CheckDestination); err != nil {
+			return err
+		}
+
+		journal, err := syncer.JournalFromStore(destinationStore, cycle)
+		if err != nil {
+			return err
+		}
+		undone, errs := syncer.Undo(destinationVault, journal)
+		for _, err := range errs {
+			log.Error().Err(err).Str("cycle", cycle).Msg("cannot undo change")
+		}
+		for _, e := range undone {
+			log.Info().Str("path", e.Path).Str("action", e.Action).Int64("priorVersion", e.PriorVersion).Msg("undid change")
+		}
+
+		marks, err := syncer.MarksFromStore(destinationStore)
+		if err != nil {
+			return err
+		}
+		syncer.Mark(marks, cycle, undone)
+		if err := syncer.MarksToStore(destinationStore, marks); err != nil {
+			return err
+		}
+
+		// destination sync info still has insights synced by the cycle, put back what it had before
+		// a destination cycle saving meanwhile is a conflict, so read its sync info again and retry
+		numBuckets := viper.GetInt("numBuckets")
+		for attempt := 1; ; attempt++ {
+			err = restoreInsights(destinationStore, numBuckets, undone)
+			if !errors.Is(err, syncer.ErrConflict) || attempt == 3 {
+				break
+			}
+			log.Warn().Err(err).Int("attempt", attempt).Msg("destination sync info changed while restoring it, retrying")
+		}
+		if err != nil {
+			return apperr.New(fmt.Sprintf("cannot restore destination sync info in store %q after undo", destinationStore), err, op, apperr.Fatal, ErrInvalidInfo)
+		}
+		log.Info().Str("cycle", cycle).Int("undone", len(undone)).Int("failed", len(errs)).Msg("undid destination cycle")
+
+		if len(errs) > 0 {
+			return apperr.New(fmt.Sprintf("cannot undo %d of %d changes of cycle %q", len(errs), len(journal.Entries), cycle), ErrInvalidVPath, op, apperr.Fatal)
+		}
+		return nil
+	},
+}
+
+// restoreInsights puts insights of undone paths in destination sync info back, guarded by the index as it was read
+func restoreInsights(destinationStore syncer.Store, numBuckets int, undone []syncer.JournalEntry) error {
+	since, err := syncer.IndexRevision(destinationStore)
+	if err != nil {
+		return err
+	}
+	destinationInfo, err := syncer.NewInfo(numBuckets)
+	if err == nil {
+		err = syncer.InfoFromStore(destinationStore, destinationInfo)
+	}
+	if err == nil {
+		err = syncer.RestoreInsights(destinationInfo, undone)
+	}
+	if err == nil {
+		err = destinationInfo.Reindex()
+	}
+	if err != nil {
+		return err
+	}
+	return syncer.InfoToStore(destinationStore, destinationInfo, since)
+}
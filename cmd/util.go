@@ -16,7 +16,9 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"strings"
 	"time"
 
@@ -29,31 +31,36 @@ import (
 )
 
 // getEssentials will return consul and vault after reading required parameters from config
+// consul is nil if sync info of mode is not stored in consul
 func getEssentials(mode string) (*consul.Client, *vault.Client, error) {
 	const op = apperr.Op("cmd.getEssentials")
 	var vaultApprolePath string
 	var vaultRoleID string
 	var vaultSecretID string
 
-	// consul client
-	consulAddress := viper.GetString(mode + "." + "consul.address")
-	if consulAddress != "" {
-		log.Debug().Str("consulAddress", consulAddress).Str("mode", mode).Msg("got consul address")
-	} else {
-		return nil, nil, apperr.New(fmt.Sprintf("cannot get %s consul address", mode), ErrInitialize, op, apperr.Fatal)
-	}
+	// consul client, only required if sync info is stored in consul
+	var c *consul.Client
+	if viper.GetString(mode+"."+"store.type") == syncer.StoreConsul {
+		consulAddress := viper.GetString(mode + "." + "consul.address")
+		if consulAddress != "" {
+			log.Debug().Str("consulAddress", consulAddress).Str("mode", mode).Msg("got consul address")
+		} else {
+			return nil, nil, apperr.New(fmt.Sprintf("cannot get %s consul address", mode), ErrInitialize, op, apperr.Fatal)
+		}
 
-	dc := viper.GetString(mode + "." + "consul.dc")
-	if dc != "" {
-		log.Debug().Str("dc", dc).Str("mode", mode).Msg("datacenter from config")
-	} else {
-		return nil, nil, apperr.New(fmt.Sprintf("cannot get %s datacenter from config", mode), ErrInitialize, op, apperr.Fatal)
-	}
+		dc := viper.GetString(mode + "." + "consul.dc")
+		if dc != "" {
+			log.Debug().Str("dc", dc).Str("mode", mode).Msg("datacenter from config")
+		} else {
+			return nil, nil, apperr.New(fmt.Sprintf("cannot get %s datacenter from config", mode), ErrInitialize, op, apperr.Fatal)
+		}
 
-	c, err := consul.NewClient(consulAddress, dc)
-	if err != nil {
-		log.Debug().Err(err).Str("mode", mode).Msg("cannot get consul client")
-		return nil, nil, apperr.New(fmt.Sprintf("cannot get %s consul client", mode), err, op, apperr.Fatal, ErrInitialize)
+		var err error
+		c, err = consul.NewClient(consulAddress, dc)
+		if err != nil {
+			log.Debug().Err(err).Str("mode", mode).Msg("cannot get consul client")
+			return nil, nil, apperr.New(fmt.Sprintf("cannot get %s consul client", mode), err, op, apperr.Fatal, ErrInitialize)
+		}
 	}
 
 	// vault client
@@ -105,29 +112,263 @@ func getEssentials(mode string) (*consul.Client, *vault.Client, error) {
 	return c, v, nil
 }
 
-func saveInfoToConsul(ctx context.Context,
-	info *syncer.Info, c *consul.Client, syncPath string,
+// getStore will return the sync info store of mode after reading required parameters from config
+func getStore(mode string, c *consul.Client, v *vault.Client, syncPath string) (syncer.Store, error) {
+	const op = apperr.Op("cmd.getStore")
+
+	storeType := viper.GetString(mode + "." + "store.type")
+	switch storeType {
+	case syncer.StoreConsul:
+		return syncer.NewConsulStore(c, syncPath)
+	case syncer.StoreFile:
+		dir := viper.GetString(mode + "." + "store.dir")
+		if dir == "" {
+			return nil, apperr.New(fmt.Sprintf("cannot get %s store directory from config", mode), ErrInitialize, op, apperr.Fatal)
+		}
+		log.Debug().Str("dir", dir).Str("mode", mode).Msg("got store directory")
+		return syncer.NewFileStore(dir, syncPath)
+	case syncer.StoreVault:
+		mount := viper.GetString(mode + "." + "store.mount")
+		if mount == "" {
+			return nil, apperr.New(fmt.Sprintf("cannot get %s store vault mount from config", mode), ErrInitialize, op, apperr.Fatal)
+		}
+		log.Debug().Str("mount", mount).Str("mode", mode).Msg("got store vault mount")
+		return syncer.NewVaultStore(v, mount, syncPath)
+	default:
+		return nil, apperr.New(fmt.Sprintf("unknown %s store type %q, use %q, %q or %q", mode, storeType, syncer.StoreConsul, syncer.StoreVault, syncer.StoreFile), ErrInitialize, op, apperr.Fatal)
+	}
+}
+
+// getCheckedStore returns vault client and sync info store of mode after sync path checks
+// sync path gets mode added like origin and destination commands save sync info
+func getCheckedStore(mode string) (*vault.Client, syncer.Store, error) {
+	const op = apperr.Op("cmd.getCheckedStore")
+
+	c, v, err := getEssentials(mode)
+	if err != nil {
+		log.Debug().Err(err).Str("mode", mode).Msg("cannot get essentials")
+		return nil, nil, apperr.New(fmt.Sprintf("cannot get clients for mode %q", mode), err, op, apperr.Fatal, ErrInitialize)
+	}
+
+	syncPath := viper.GetString(mode + "." + "syncPath")
+	if !strings.HasSuffix(syncPath, "/") {
+		syncPath = syncPath + "/"
+	}
+	syncPath = syncPath + mode + "/"
+
+	s, err := getStore(mode, c, v, syncPath)
+	if err != nil {
+		log.Debug().Err(err).Str("path", syncPath).Str("mode", mode).Msg("cannot get sync info store")
+		return nil, nil, apperr.New(fmt.Sprintf("cannot get sync info store for %q", syncPath), err, op, apperr.Fatal, ErrInitialize)
+	}
+	err = s.Checks()
+	if err != nil {
+		log.Debug().Err(err).Str("mode", mode).Msg("failures on sync path checks")
+		return nil, nil, apperr.New(fmt.Sprintf("sync path checks failed for %q", syncPath), err, op, apperr.Fatal, ErrInitialize)
+	}
+	return v, s, nil
+}
+
+// checkMounts runs mount checks of mode, so kv version of each mount is known
+func checkMounts(mode string, v *vault.Client, mounts []string, checks int) error {
+	const op = apperr.Op("cmd.checkMounts")
+
+	if len(mounts) == 0 {
+		return apperr.New(fmt.Sprintf("no %q mounts found, specify mounts in config", mode), ErrInitialize, op, apperr.Fatal)
+	}
+	for _, mount := range mounts {
+		if !strings.HasSuffix(mount, "/") {
+			return apperr.New(fmt.Sprintf("mount %q of %s is missing a / at last", mount, mode), ErrInitialize, op, apperr.Fatal)
+		}
+		err := v.MountChecks(mount, checks, viper.GetString("name"))
+		if err != nil {
+			log.Debug().Err(err).Str("mode", mode).Msg("failures on mount checks")
+			return apperr.New(fmt.Sprintf("failures on mount checks on %s", mode), err, op, apperr.Fatal, ErrInitialize)
+		}
+	}
+	return nil
+}
+
+// getQuarantine sets quarantine mount and prefix of destination vault from config, quarantine mount must be kv v2
+func getQuarantine(v *vault.Client) error {
+	const op = apperr.Op("cmd.getQuarantine")
+
+	mount := viper.GetString("destination.quarantine.mount")
+	prefix := viper.GetString("destination.quarantine.prefix")
+	if mount == "" {
+		return nil
+	}
+	if !strings.HasSuffix(mount, "/") || !strings.HasSuffix(prefix, "/") {
+		return apperr.New(fmt.Sprintf("quarantine mount %q and prefix %q must end with /", mount, prefix), ErrInitialize, op, apperr.Fatal)
+	}
+	err := v.MountChecks(mount, vault.CheckDestination, viper.GetString("name"))
+	if err != nil {
+		log.Debug().Err(err).Str("mount", mount).Msg("failures on quarantine mount checks")
+		return apperr.New(fmt.Sprintf("failures on quarantine mount checks on destination"), err, op, apperr.Fatal, ErrInitialize)
+	}
+	if v.KVVersion(mount) != 2 {
+		return apperr.New(fmt.Sprintf("quarantine mount %q must be kv v2", mount), ErrInitialize, op, apperr.Fatal)
+	}
+
+	syncer.QuarantineMount = mount
+	syncer.QuarantinePrefix = prefix
+	return nil
+}
+
+// checkIndex validates bucketing and merkle index options from config
+func checkIndex(mode string, bucketing string, fanout int, prefixDepth int) error {
+	const op = apperr.Op("cmd.checkIndex")
+
+	switch bucketing {
+	case syncer.BucketingModulo, syncer.BucketingJump, syncer.BucketingPrefix:
+	default:
+		log.Error().Str("mode", mode).Str("bucketing", bucketing).Msg("unknown bucketing")
+		return apperr.New(fmt.Sprintf("unknown bucketing %q, use %q, %q or %q", bucketing, syncer.BucketingModulo, syncer.BucketingJump, syncer.BucketingPrefix), ErrInitialize, op, apperr.Fatal)
+	}
+	if fanout < 0 || fanout == 1 {
+		log.Error().Str("mode", mode).Int("indexFanout", fanout).Msg("invalid index fanout")
+		return apperr.New(fmt.Sprintf("index fanout %d must be 0 for flat index or at least 2", fanout), ErrInitialize, op, apperr.Fatal)
+	}
+	if bucketing == syncer.BucketingPrefix && (fanout == 0 || prefixDepth < 1) {
+		log.Error().Str("mode", mode).Int("indexFanout", fanout).Int("prefixDepth", prefixDepth).Msg("prefix bucketing needs index fanout and prefix depth")
+		return apperr.New(fmt.Sprintf("bucketing %q needs %q and %q of at least 1", bucketing, "indexFanout", "prefixDepth"), ErrInitialize, op, apperr.Fatal)
+	}
+	return nil
+}
+
+// checkEncoding validates bucket compression and max bucket size from config
+func checkEncoding(mode string, compression string, maxBucketSize int) error {
+	const op = apperr.Op("cmd.checkEncoding")
+
+	switch compression {
+	case syncer.CompressionNone, syncer.CompressionGzip, syncer.CompressionZstd:
+	default:
+		log.Error().Str("mode", mode).Str("bucketCompression", compression).Msg("unknown bucket compression")
+		return apperr.New(fmt.Sprintf("unknown bucket compression %q, use %q, %q or %q", compression, syncer.CompressionNone, syncer.CompressionGzip, syncer.CompressionZstd), ErrInitialize, op, apperr.Fatal)
+	}
+	if maxBucketSize <= 0 {
+		log.Error().Str("mode", mode).Int("maxBucketSize", maxBucketSize).Msg("max bucket size must be positive")
+		return apperr.New(fmt.Sprintf("max bucket size %d must be positive", maxBucketSize), ErrInitialize, op, apperr.Fatal)
+	}
+	return nil
+}
+
+// checkHashing validates hash algorithm and serialization of buckets for index from config
+func checkHashing(mode string, hashAlgorithm string, serialization string) error {
+	const op = apperr.Op("cmd.checkHashing")
+
+	switch hashAlgorithm {
+	case syncer.HashSHA256, syncer.HashBlake2b, syncer.HashXXHash:
+	default:
+		log.Error().Str("mode", mode).Str("hashAlgorithm", hashAlgorithm).Msg("unknown hash algorithm")
+		return apperr.New(fmt.Sprintf("unknown hash algorithm %q, use %q, %q or %q", hashAlgorithm, syncer.HashSHA256, syncer.HashBlake2b, syncer.HashXXHash), ErrInitialize, op, apperr.Fatal)
+	}
+	switch serialization {
+	case syncer.SerializationFmt, syncer.SerializationJSON:
+	default:
+		log.Error().Str("mode", mode).Str("indexSerialization", serialization).Msg("unknown index serialization")
+		return apperr.New(fmt.Sprintf("unknown index serialization %q, use %q or %q", serialization, syncer.SerializationFmt, syncer.SerializationJSON), ErrInitialize, op, apperr.Fatal)
+	}
+	return nil
+}
+
+// getLayout returns layout of sync info from config after validating it
+func getLayout(mode string) (syncer.Layout, error) {
+	bucketing := viper.GetString("bucketing")
+	fanout := viper.GetInt("indexFanout")
+	prefixDepth := viper.GetInt("prefixDepth")
+	compression := viper.GetString("bucketCompression")
+	maxBucketSize := viper.GetInt("maxBucketSize")
+	hashAlgorithm := viper.GetString("hashAlgorithm")
+	serialization := viper.GetString("indexSerialization")
+
+	if err := checkIndex(mode, bucketing, fanout, prefixDepth); err != nil {
+		return syncer.Layout{}, err
+	}
+	if err := checkEncoding(mode, compression, maxBucketSize); err != nil {
+		return syncer.Layout{}, err
+	}
+	if err := checkHashing(mode, hashAlgorithm, serialization); err != nil {
+		return syncer.Layout{}, err
+	}
+
+	// header does not record serialization when buckets are hashed as go's fmt output, nor compression when buckets are not compressed
+	if serialization == syncer.SerializationFmt {
+		serialization = ""
+	}
+	if compression == syncer.CompressionNone {
+		compression = ""
+	}
+
+	return syncer.Layout{
+		Header: syncer.Header{
+			NumBuckets:    viper.GetInt("numBuckets"),
+			Bucketing:     bucketing,
+			Fanout:        fanout,
+			PrefixDepth:   prefixDepth,
+			HashAlgorithm: hashAlgorithm,
+			Serialization: serialization,
+			Compression:   compression,
+		},
+		MaxBucketSize: maxBucketSize,
+	}, nil
+}
+
+// getFingerprinter returns fingerprinter with key from config or key file, nil when fingerprints are not enabled
+// key should come from environment or a file outside of config, it is never saved in sync info
+func getFingerprinter(mode string) (*syncer.Fingerprinter, error) {
+	const op = apperr.Op("cmd.getFingerprinter")
+
+	key := viper.GetString("fingerprint.key")
+	keyFile := viper.GetString("fingerprint.keyFile")
+	if key == "" && keyFile != "" {
+		value, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			log.Error().Err(err).Str("mode", mode).Str("fingerprint.keyFile", keyFile).Msg("cannot read fingerprint key file")
+			return nil, apperr.New(fmt.Sprintf("cannot read fingerprint key file %q", keyFile), err, op, apperr.Fatal, ErrInitialize)
+		}
+		key = strings.TrimSpace(string(value))
+	}
+	if key == "" {
+		return nil, nil
+	}
+
+	f, err := syncer.NewFingerprinter([]byte(key))
+	if err != nil {
+		log.Error().Err(err).Str("mode", mode).Msg("invalid fingerprint key")
+		return nil, apperr.New(fmt.Sprintf("invalid fingerprint key"), err, op, apperr.Fatal, ErrInitialize)
+	}
+	log.Info().Str("mode", mode).Str("fingerprintKey", f.KeyID()).Msg("fingerprints enabled")
+	return f, nil
+}
+
+// saveInfoToStore publishes info guarded by since, the revision of sync index when the cycle started
+func saveInfoToStore(ctx context.Context,
+	info *syncer.Info, s syncer.Store, since syncer.Revision,
 	saveCh chan bool, doneCh chan bool, errCh chan error) {
-	const op = apperr.Op("cmd.saveInfoToConsul")
+	const op = apperr.Op("cmd.saveInfoToStore")
 	select {
 	case <-ctx.Done():
 		doneCh <- false
 		time.Sleep(50 * time.Microsecond)
-		log.Debug().Str("trigger", "context done").Msg("closed save info to consul")
+		log.Debug().Str("trigger", "context done").Msg("closed save info to store")
 		return
 	case _, ok := <-saveCh:
 		if !ok {
 			doneCh <- false
 			time.Sleep(50 * time.Microsecond)
-			log.Debug().Str("trigger", "nil channel").Msg("closed save info to consul")
+			log.Debug().Str("trigger", "nil channel").Msg("closed save info to store")
 			return
 		}
-		log.Debug().Str("path", syncPath).Msg("info to be saved in consul")
+		log.Debug().Str("store", s.String()).Msg("info to be saved in store")
 
-		err := syncer.InfoToConsul(c, info, syncPath)
+		err := syncer.InfoToStore(s, info, since)
+		if errors.Is(err, syncer.ErrConflict) {
+			log.Error().Err(err).Str("store", s.String()).Msg("sync info was changed by another writer since this cycle read it, check if more than one vsync writes to the same sync path")
+		}
 		if err != nil {
-			log.Debug().Err(err).Msg("cannot save info to consul")
-			errCh <- apperr.New(fmt.Sprintf("cannot save info to consul in path %q", syncPath), ErrInitialize, op, apperr.Fatal)
+			log.Debug().Err(err).Msg("cannot save info to store")
+			errCh <- apperr.New(fmt.Sprintf("cannot save info to store %q", s), err, op, apperr.Fatal, ErrInitialize)
 			doneCh <- false
 			return
 		}
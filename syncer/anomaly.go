@@ -0,0 +1,53 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"fmt"
+
+	"github.com/ExpediaGroup/vsync/apperr"
+)
+
+var ErrPathDrop = fmt.Errorf("number of paths dropped more than allowed since last published sync info")
+
+// MaxPathDrop is how many live paths origin may lose since last published sync info before it refuses to publish, 0 turns it off
+// set once before origin sync starts, like MaxPathDropPercent and AcceptDrop
+var MaxPathDrop = 0
+
+// MaxPathDropPercent is how much of live paths in percent origin may lose since last published sync info, 0 turns it off
+var MaxPathDropPercent = 0.0
+
+// AcceptDrop makes origin publish its first sync info even if it dropped more paths than allowed
+var AcceptDrop = false
+
+// CheckPathDrop returns ErrPathDrop when current has fewer live paths than previous by more than maxDrop paths or more than maxDropPercent of previous
+// unknown is number of live paths of previous which are unknown in current, destinations keep them so they are not a drop
+// zero turns a limit off, previous without number of paths (format version 0) is never a drop
+func CheckPathDrop(previous Header, current Header, unknown int, maxDrop int, maxDropPercent float64) error {
+	const op = apperr.Op("syncer.CheckPathDrop")
+
+	known := previous.NumPaths - unknown
+	drop := known - current.NumPaths
+	if drop <= 0 {
+		return nil
+	}
+	if maxDrop > 0 && drop > maxDrop {
+		return apperr.New(fmt.Sprintf("paths dropped from %d to %d leaving out %d unknown, more than %d paths", previous.NumPaths, current.NumPaths, unknown, maxDrop), ErrPathDrop, op)
+	}
+	if maxDropPercent > 0 && float64(drop)*100 > maxDropPercent*float64(known) {
+		return apperr.New(fmt.Sprintf("paths dropped from %d to %d leaving out %d unknown, more than %g%%", previous.NumPaths, current.NumPaths, unknown, maxDropPercent), ErrPathDrop, op)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckPathDrop(t *testing.T) {
+	previous := Header{FormatVersion: FormatVersion, NumPaths: 200}
+
+	assert.NoError(t, CheckPathDrop(previous, Header{NumPaths: 250}, 0, 10, 5), "growth is never a drop")
+	assert.NoError(t, CheckPathDrop(previous, Header{NumPaths: 190}, 0, 10, 5))
+	assert.NoError(t, CheckPathDrop(previous, Header{NumPaths: 0}, 0, 0, 0), "limits off")
+	assert.NoError(t, CheckPathDrop(Header{}, Header{NumPaths: 0}, 0, 10, 5), "nothing to compare with")
+
+	err := CheckPathDrop(previous, Header{NumPaths: 189}, 0, 10, 0)
+	assert.True(t, errors.Is(err, ErrPathDrop))
+	err = CheckPathDrop(previous, Header{NumPaths: 189}, 0, 0, 5)
+	assert.True(t, errors.Is(err, ErrPathDrop))
+	err = CheckPathDrop(previous, Header{NumPaths: 0}, 0, 1000, 50)
+	assert.True(t, errors.Is(err, ErrPathDrop), "empty sync info after vault misbehaved")
+}
+
+func TestCheckPathDropUnknown(t *testing.T) {
+	previous, err := NewInfo(2)
+	require.NoError(t, err)
+	current, err := NewInfo(2)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		_, err = previous.Put(fmt.Sprintf("secret/data/app%d", i), Insight{Version: 1, Type: "kvV2"})
+		require.NoError(t, err)
+		_, err = previous.Put(fmt.Sprintf("team/data/app%d", i), Insight{Version: 1, Type: "kvV2"})
+		require.NoError(t, err)
+	}
+	_, err = previous.Put("team/data/gone", Insight{Version: 1, Type: "kvV2", Deleted: DeletedSoft})
+	require.NoError(t, err)
+	for i := 0; i < 9; i++ {
+		_, err = current.Put(fmt.Sprintf("team/data/app%d", i), Insight{Version: 1, Type: "kvV2"})
+		require.NoError(t, err)
+	}
+
+	// listing secret/ root failed, its paths are kept by destinations
+	current.MarkUnknown("secret/data/")
+	current.MarkUnknown("team/data/app9")
+	unknown := previous.LiveUnknown(current.Header().Unknown)
+	assert.Equal(t, 11, unknown)
+	assert.NoError(t, CheckPathDrop(previous.Header(), current.Header(), unknown, 1, 5))
+
+	err = CheckPathDrop(previous.Header(), current.Header(), 0, 1, 5)
+	assert.True(t, errors.Is(err, ErrPathDrop), "without unknown paths it is a drop")
+
+	// a real drop outside unknown subtrees is still found
+	_, err = current.Delete("team/data/app0")
+	require.NoError(t, err)
+	_, err = current.Delete("team/data/app1")
+	require.NoError(t, err)
+	err = CheckPathDrop(previous.Header(), current.Header(), unknown, 1, 0)
+	assert.True(t, errors.Is(err, ErrPathDrop))
+}
@@ -0,0 +1,220 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ExpediaGroup/vsync/apperr"
+)
+
+var ErrNoPlan = fmt.Errorf("no such pending plan")
+
+// keys in destination store next to sync info, a pending plan and the id of plan approved by an operator
+const (
+	planKey     = "plan"
+	approvalKey = "approval"
+)
+
+// ApproveDeletes holds deletes and strict mirror prunes of destination secrets till an operator approves them, set once before destination sync starts
+var ApproveDeletes = false
+
+// ApproveUpdatesOver holds all updates of a cycle with more updates than this till an operator approves them, 0 never holds updates
+var ApproveUpdatesOver = 0
+
+// PlanTTL is how long a plan stays pending, an expired plan is made again with a new id
+var PlanTTL = 24 * time.Hour
+
+// Plan is destructive tasks of a destination cycle held till an operator approves them
+// a plan with the same tasks stays pending across cycles, a changed or expired plan gets a new id and needs a new approval
+type Plan struct {
+	ID      string    `json:"id"`
+	Digest  string    `json:"digest"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+	Deletes int       `json:"deletes"`
+	Updates int       `json:"updates"`
+	Tasks   []Task    `json:"tasks"`
+}
+
+// Gate splits out tasks which need approval, returning updates and deletes which can go on and held tasks
+// metadata, tombstone and forget tasks do not change destination data and are never held
+func Gate(updates []Task, deletes []Task) ([]Task, []Task, []Task) {
+	held := []Task{}
+
+	n := 0
+	for _, task := range updates {
+		if task.Op == "update" {
+			n++
+		}
+	}
+	passed := updates
+	if ApproveUpdatesOver > 0 && n > ApproveUpdatesOver {
+		passed = []Task{}
+		for _, task := range updates {
+			if task.Op == "update" {
+				held = append(held, task)
+				continue
+			}
+			passed = append(passed, task)
+		}
+	}
+
+	kept := []Task{}
+	for _, task := range deletes {
+		if ApproveDeletes && (task.Op == "delete" || task.Op == "prune") {
+			held = append(held, task)
+			continue
+		}
+		kept = append(kept, task)
+	}
+	return passed, kept, held
+}
+
+// NewPlan of held tasks, pending till now + ttl
+func NewPlan(held []Task, now time.Time, ttl time.Duration) (Plan, error) {
+	digest, err := planDigest(held)
+	if err != nil {
+		return Plan{}, err
+	}
+	sum := sha256.Sum256([]byte(digest + now.UTC().Format(time.RFC3339Nano)))
+
+	p := Plan{
+		ID:      hex.EncodeToString(sum[:])[:12],
+		Digest:  digest,
+		Created: now.UTC(),
+		Expires: now.UTC().Add(ttl),
+		Tasks:   held,
+	}
+	for _, task := range held {
+		if task.Op == "delete" || task.Op == "prune" {
+			p.Deletes++
+		} else {
+			p.Updates++
+		}
+	}
+	return p, nil
+}
+
+// Holds is true when plan has exactly held tasks
+func (p Plan) Holds(held []Task) bool {
+	digest, err := planDigest(held)
+	return err == nil && digest == p.Digest
+}
+
+// Expired is true once ttl of plan is over
+func (p Plan) Expired(now time.Time) bool {
+	return !now.Before(p.Expires)
+}
+
+// planDigest is sha256 of held tasks sorted by path, so the same tasks in any order have the same digest
+func planDigest(held []Task) (string, error) {
+	const op = apperr.Op("syncer.planDigest")
+
+	sorted := append([]Task{}, held...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Path < sorted[j].Path || (sorted[i].Path == sorted[j].Path && sorted[i].Op < sorted[j].Op)
+	})
+	content, err := json.Marshal(sorted)
+	if err != nil {
+		return "", apperr.New(fmt.Sprintf("cannot marshal tasks of plan"), err, op, ErrInvalidInsight)
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// PlanFromStore reads pending plan, false when there is none
+func PlanFromStore(s Store) (Plan, bool, error) {
+	const op = apperr.Op("syncer.PlanFromStore")
+
+	p := Plan{}
+	value, err := s.Get(planKey)
+	if err != nil {
+		return p, false, apperr.New(fmt.Sprintf("cannot get pending plan from store %q", s), err, op, ErrInvalidStore)
+	}
+	if value == nil {
+		return p, false, nil
+	}
+	if err := json.Unmarshal(value, &p); err != nil {
+		return p, false, apperr.New(fmt.Sprintf("cannot unmarshal pending plan from store %q", s), err, op, ErrInvalidStore)
+	}
+	return p, true, nil
+}
+
+// PlanToStore saves plan as the pending plan, replacing an older one and its approval
+func PlanToStore(s Store, p Plan) error {
+	const op = apperr.Op("syncer.PlanToStore")
+
+	value, err := json.Marshal(p)
+	if err != nil {
+		return apperr.New(fmt.Sprintf("cannot marshal plan %q", p.ID), err, op, ErrInvalidStore)
+	}
+	if err := s.Delete(approvalKey); err != nil {
+		return apperr.New(fmt.Sprintf("cannot delete approval in store %q", s), err, op, ErrInvalidStore)
+	}
+	if err := s.Put(planKey, value); err != nil {
+		return apperr.New(fmt.Sprintf("cannot save plan %q in store %q", p.ID, s), err, op, ErrInvalidStore)
+	}
+	return nil
+}
+
+// ClearPlan removes pending plan and its approval, once plan is done or nothing needs approval
+func ClearPlan(s Store) error {
+	const op = apperr.Op("syncer.ClearPlan")
+
+	for _, key := range []string{approvalKey, planKey} {
+		if err := s.Delete(key); err != nil {
+			return apperr.New(fmt.Sprintf("cannot delete %q in store %q", key, s), err, op, ErrInvalidStore)
+		}
+	}
+	return nil
+}
+
+// Approved is true when an operator approved pending plan p
+func Approved(s Store, p Plan) (bool, error) {
+	const op = apperr.Op("syncer.Approved")
+
+	value, err := s.Get(approvalKey)
+	if err != nil {
+		return false, apperr.New(fmt.Sprintf("cannot get approval from store %q", s), err, op, ErrInvalidStore)
+	}
+	return strings.TrimSpace(string(value)) == p.ID, nil
+}
+
+// Approve sets approval of pending plan with id, ErrNoPlan when that plan is not pending or has expired
+func Approve(s Store, id string, now time.Time) (Plan, error) {
+	const op = apperr.Op("syncer.Approve")
+
+	p, ok, err := PlanFromStore(s)
+	if err != nil {
+		return p, err
+	}
+	if !ok || p.ID != id {
+		return p, apperr.New(fmt.Sprintf("plan %q is not pending in store %q", id, s), ErrNoPlan, op)
+	}
+	if p.Expired(now) {
+		return p, apperr.New(fmt.Sprintf("plan %q expired at %s", id, p.Expires.Format(time.RFC3339)), ErrNoPlan, op)
+	}
+	if err := s.Put(approvalKey, []byte(id)); err != nil {
+		return p, apperr.New(fmt.Sprintf("cannot save approval of plan %q in store %q", id, s), err, op, ErrInvalidStore)
+	}
+	return p, nil
+}
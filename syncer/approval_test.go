@@ -0,0 +1,99 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGate(t *testing.T) {
+	defer func() { ApproveDeletes, ApproveUpdatesOver = false, 0 }()
+
+	updates := []Task{{Path: "secret/data/a", Op: "update"}, {Path: "secret/data/b", Op: "update"}, {Path: "secret/data/c", Op: "metadata"}}
+	deletes := []Task{{Path: "secret/data/d", Op: "delete"}, {Path: "secret/data/e", Op: "tombstone"}, {Path: "secret/data/f", Op: "prune", Insight: Insight{Deleted: DeletedSoft}}}
+
+	passed, kept, held := Gate(updates, deletes)
+	assert.Equal(t, updates, passed)
+	assert.Equal(t, deletes, kept)
+	assert.Empty(t, held, "nothing waits for approval by default")
+
+	ApproveDeletes, ApproveUpdatesOver = true, 2
+	passed, kept, held = Gate(updates, deletes)
+	assert.Equal(t, updates, passed, "updates up to the limit keep flowing")
+	assert.Equal(t, []Task{{Path: "secret/data/e", Op: "tombstone"}}, kept)
+	assert.Equal(t, []Task{{Path: "secret/data/d", Op: "delete"}, deletes[2]}, held, "strict mirror prunes wait like deletes")
+
+	ApproveUpdatesOver = 1
+	passed, _, held = Gate(updates, deletes)
+	assert.Equal(t, []Task{{Path: "secret/data/c", Op: "metadata"}}, passed)
+	assert.Len(t, held, 4)
+}
+
+func TestApprovePlan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vsync")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	s, err := NewFileStore(dir, "vsync/destination/")
+	require.NoError(t, err)
+
+	now := time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC)
+	held := []Task{{Path: "secret/data/d", Op: "delete"}, {Path: "secret/data/a", Op: "update"}}
+	plan, err := NewPlan(held, now, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 1, plan.Deletes)
+	assert.Equal(t, 1, plan.Updates)
+	assert.True(t, plan.Holds([]Task{held[1], held[0]}), "order of tasks does not matter")
+	assert.False(t, plan.Holds(held[:1]))
+	require.NoError(t, PlanToStore(s, plan))
+
+	pending, ok, err := PlanFromStore(s)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, plan.ID, pending.ID)
+	approved, err := Approved(s, pending)
+	require.NoError(t, err)
+	assert.False(t, approved)
+
+	_, err = Approve(s, "other", now)
+	assert.True(t, errors.Is(err, ErrNoPlan))
+	_, err = Approve(s, plan.ID, now.Add(2*time.Hour))
+	assert.True(t, errors.Is(err, ErrNoPlan), "expired plan cannot be approved")
+	_, err = Approve(s, plan.ID, now)
+	require.NoError(t, err)
+	approved, err = Approved(s, pending)
+	require.NoError(t, err)
+	assert.True(t, approved)
+
+	// same tasks made again after expiry need a new approval
+	again, err := NewPlan(held, now.Add(2*time.Hour), time.Hour)
+	require.NoError(t, err)
+	assert.NotEqual(t, plan.ID, again.ID)
+	require.NoError(t, PlanToStore(s, again))
+	approved, err = Approved(s, again)
+	require.NoError(t, err)
+	assert.False(t, approved)
+
+	require.NoError(t, ClearPlan(s))
+	_, ok, err = PlanFromStore(s)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
@@ -0,0 +1,155 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/ExpediaGroup/vsync/apperr"
+	"github.com/rs/zerolog/log"
+)
+
+// Bucketing decides how a path is assigned to a bucket
+const (
+	// BucketingModulo uses first 2 bytes of path hash modulo number of buckets
+	// changing number of buckets moves almost every path to another bucket
+	BucketingModulo = "modulo"
+	// BucketingJump uses jump consistent hash on first 8 bytes of path hash
+	// growing from n to m buckets moves only (m-n)/m of paths, all into the new buckets
+	BucketingJump = "jump"
+	// BucketingPrefix keeps paths with same prefix under one node of merkle index, needs a fanout
+	BucketingPrefix = "prefix"
+)
+
+// checkBucketing validates bucketing for an index with fanout
+func checkBucketing(bucketing string, fanout int) error {
+	const op = apperr.Op("syncer.checkBucketing")
+
+	switch bucketing {
+	case BucketingModulo, BucketingJump, "":
+		return nil
+	case BucketingPrefix:
+		if fanout < 2 {
+			return apperr.New(fmt.Sprintf("bucketing %q needs a merkle index with fanout", bucketing), ErrUnsupportedFormat, op)
+		}
+		return nil
+	default:
+		return apperr.New(fmt.Sprintf("unknown bucketing %q", bucketing), ErrUnsupportedFormat, op)
+	}
+}
+
+func bucketOf(bucketing string, pathHash []byte, numBuckets int) (int, error) {
+	const op = apperr.Op("syncer.bucketOf")
+
+	switch bucketing {
+	case BucketingModulo, "":
+		pathI := binary.BigEndian.Uint16(pathHash)
+		return int(pathI % uint16(numBuckets)), nil
+	case BucketingJump:
+		return jumpHash(binary.BigEndian.Uint64(pathHash), numBuckets), nil
+	default:
+		return 0, apperr.New(fmt.Sprintf("unknown bucketing %q", bucketing), ErrUnsupportedFormat, op)
+	}
+}
+
+// jumpHash is "A Fast, Minimal Memory, Consistent Hash Algorithm" by Lamping and Veach
+func jumpHash(key uint64, numBuckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(numBuckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+// SetBucketing changes how paths are assigned to buckets, only allowed before any path is saved in info
+// prefix bucketing needs SetMerkle first
+func (i *Info) SetBucketing(bucketing string) error {
+	const op = apperr.Op("syncer.Info.SetBucketing")
+
+	i.rw.Lock()
+	defer i.rw.Unlock()
+
+	if err := checkBucketing(bucketing, i.header.Fanout); err != nil {
+		return apperr.New(fmt.Sprintf("cannot set bucketing %q", bucketing), err, op, apperr.Fatal, ErrInitialize)
+	}
+
+	for _, bucket := range i.buckets {
+		if len(bucket) > 0 {
+			return apperr.New(fmt.Sprintf("cannot change bucketing to %q after paths are saved in info", bucketing), ErrInitialize, op)
+		}
+	}
+	i.header.Bucketing = bucketing
+
+	return nil
+}
+
+// Rebucket returns a new info with same paths assigned to buckets like layout
+// number of buckets, bucketing, fanout, prefix depth and hashing are taken from layout
+// useful to compare with sync info which has different layout or to migrate to a new layout
+func (i *Info) Rebucket(layout Header) (*Info, error) {
+	const op = apperr.Op("syncer.Info.Rebucket")
+	numBuckets, bucketing := layout.NumBuckets, layout.Bucketing
+
+	header := i.Header()
+	n, err := NewInfo(numBuckets)
+	if err != nil {
+		return nil, apperr.New(fmt.Sprintf("cannot create info with %d buckets for rebucketing", numBuckets), err, op, ErrInitialize)
+	}
+	err = n.SetHashing(layout.HashAlgorithm, layout.Serialization)
+	if err != nil {
+		return nil, apperr.New(fmt.Sprintf("cannot set hashing for rebucketing"), err, op, ErrInitialize)
+	}
+	n.header.Origin = header.Origin
+	n.header.CycleStart = header.CycleStart
+	n.header.Compression = header.Compression
+	n.header.FingerprintKey = header.FingerprintKey
+	n.header.Tombstones = header.Tombstones
+	n.header.SecretMetadata = header.SecretMetadata
+	n.header.Unknown = header.Unknown
+	i.rw.RLock()
+	n.maxBucketSize = i.maxBucketSize
+	i.rw.RUnlock()
+	err = n.SetMerkle(layout.Fanout, layout.PrefixDepth)
+	if err != nil {
+		return nil, apperr.New(fmt.Sprintf("cannot set index fanout for rebucketing"), err, op, ErrInitialize)
+	}
+	err = n.SetBucketing(bucketing)
+	if err != nil {
+		return nil, apperr.New(fmt.Sprintf("cannot set bucketing for rebucketing"), err, op, ErrInitialize)
+	}
+
+	i.rw.RLock()
+	for _, bucket := range i.buckets {
+		for path, insight := range bucket {
+			_, err := n.Put(path, insight)
+			if err != nil {
+				i.rw.RUnlock()
+				return nil, apperr.New(fmt.Sprintf("cannot put path %q while rebucketing", path), err, op, ErrInvalidBucket)
+			}
+		}
+	}
+	i.rw.RUnlock()
+
+	err = n.Reindex()
+	if err != nil {
+		return nil, apperr.New(fmt.Sprintf("cannot reindex after rebucketing"), err, op, ErrInvalidIndex)
+	}
+	log.Debug().Int("from", header.NumBuckets).Int("to", numBuckets).Str("bucketing", bucketing).Int("fanout", layout.Fanout).Str("hashAlgorithm", layout.HashAlgorithm).Msg("rebucketed sync info")
+
+	return n, nil
+}
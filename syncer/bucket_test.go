@@ -0,0 +1,72 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJumpBucketingGrow(t *testing.T) {
+	numPaths := 10000
+	small, err := NewInfo(10)
+	require.NoError(t, err)
+	require.NoError(t, small.SetBucketing(BucketingJump))
+	large, err := NewInfo(20)
+	require.NoError(t, err)
+	require.NoError(t, large.SetBucketing(BucketingJump))
+
+	moved := 0
+	for i := 0; i < numPaths; i++ {
+		from, err := small.generateBucketId(fmt.Sprint(i))
+		require.NoError(t, err)
+		to, err := large.generateBucketId(fmt.Sprint(i))
+		require.NoError(t, err)
+		if from != to {
+			assert.True(t, to >= 10, "paths must move only into new buckets")
+			moved++
+		}
+	}
+	assert.InDelta(t, numPaths/2, moved, float64(numPaths)/20)
+}
+
+func TestCompareDifferentBuckets(t *testing.T) {
+	origin, err := NewInfo(7)
+	require.NoError(t, err)
+	require.NoError(t, origin.SetBucketing(BucketingJump))
+	destination, err := NewInfo(3)
+	require.NoError(t, err)
+
+	for i := 0; i < 100; i++ {
+		insight := Insight{Version: 1, UpdateTime: "2019-09-15T00:58:20.680948367Z", Type: "kvV2"}
+		_, err = origin.Put(fmt.Sprintf("secret/data/%d", i), insight)
+		require.NoError(t, err)
+		_, err = destination.Put(fmt.Sprintf("secret/data/%d", i), insight)
+		require.NoError(t, err)
+	}
+	_, err = origin.Put("secret/data/new", Insight{Version: 1, UpdateTime: "2019-09-15T00:58:20.680948367Z", Type: "kvV2"})
+	require.NoError(t, err)
+	require.NoError(t, origin.Reindex())
+	require.NoError(t, destination.Reindex())
+
+	add, update, del, errs := origin.Compare(destination)
+	assert.Empty(t, errs)
+	assert.Len(t, add, 1)
+	assert.Empty(t, update)
+	assert.Empty(t, del)
+}
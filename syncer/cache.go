@@ -0,0 +1,118 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"sync"
+)
+
+// Cache keeps buckets and merkle index nodes in memory by their hash, so those unchanged between cycles are not read again
+// hash of contents is used instead of store versions like consul modify index, because it changes only when contents change
+// and a bucket in origin with same hash as in destination does not need to be read at all
+type Cache struct {
+	rw      sync.Mutex
+	buckets map[string]Bucket
+	nodes   map[string][]string
+	used    map[string]bool
+}
+
+func NewCache() *Cache {
+	return &Cache{
+		buckets: map[string]Bucket{},
+		nodes:   map[string][]string{},
+		used:    map[string]bool{},
+	}
+}
+
+// get returns a copy because info changes its buckets in place, nil cache never has a bucket
+func (c *Cache) get(hash string) (Bucket, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.rw.Lock()
+	defer c.rw.Unlock()
+
+	bucket, ok := c.buckets[hash]
+	if !ok {
+		return nil, false
+	}
+	c.used[hash] = true
+	return copyBucket(bucket), true
+}
+
+func (c *Cache) put(hash string, bucket Bucket) {
+	if c == nil {
+		return
+	}
+	c.rw.Lock()
+	defer c.rw.Unlock()
+
+	c.buckets[hash] = copyBucket(bucket)
+	c.used[hash] = true
+}
+
+// getNode returns child hashes of a merkle index node, nil cache never has a node
+func (c *Cache) getNode(hash string) ([]string, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.rw.Lock()
+	defer c.rw.Unlock()
+
+	children, ok := c.nodes[hash]
+	if ok {
+		c.used[hash] = true
+	}
+	return children, ok
+}
+
+// putNode keeps child hashes, they are never changed in place so no copy is needed
+func (c *Cache) putNode(hash string, children []string) {
+	if c == nil {
+		return
+	}
+	c.rw.Lock()
+	defer c.rw.Unlock()
+
+	c.nodes[hash] = children
+	c.used[hash] = true
+}
+
+// Sweep removes buckets and nodes not used since last sweep and returns number of buckets kept
+func (c *Cache) Sweep() int {
+	c.rw.Lock()
+	defer c.rw.Unlock()
+
+	for hash := range c.buckets {
+		if !c.used[hash] {
+			delete(c.buckets, hash)
+		}
+	}
+	for hash := range c.nodes {
+		if !c.used[hash] {
+			delete(c.nodes, hash)
+		}
+	}
+	c.used = map[string]bool{}
+	return len(c.buckets)
+}
+
+func copyBucket(bucket Bucket) Bucket {
+	b := make(Bucket, len(bucket))
+	for path, insight := range bucket {
+		b[path] = insight
+	}
+	return b
+}
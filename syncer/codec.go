@@ -0,0 +1,167 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/ExpediaGroup/vsync/apperr"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression of bucket values in store, recorded in header
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// DefaultMaxBucketSize keeps each stored value, base64 encoded in a consul transaction, under consul's 512KB limit
+const DefaultMaxBucketSize = 256 * 1024
+
+// SetEncoding decides how buckets are saved in store, it can be changed any time as readers follow the header
+// a bucket bigger than maxBucketSize bytes after compression is split into continuation keys
+func (i *Info) SetEncoding(compression string, maxBucketSize int) error {
+	const op = apperr.Op("syncer.Info.SetEncoding")
+
+	if compression == CompressionNone {
+		compression = ""
+	}
+	if err := checkCompression(compression); err != nil {
+		return apperr.New(fmt.Sprintf("cannot set bucket compression %q", compression), err, op, apperr.Fatal, ErrInitialize)
+	}
+	if maxBucketSize <= 0 {
+		return apperr.New(fmt.Sprintf("max bucket size %d must be positive", maxBucketSize), ErrInitialize, op, apperr.Fatal)
+	}
+
+	i.rw.Lock()
+	defer i.rw.Unlock()
+	i.header.Compression = compression
+	i.maxBucketSize = maxBucketSize
+
+	return nil
+}
+
+func checkCompression(compression string) error {
+	const op = apperr.Op("syncer.checkCompression")
+
+	switch compression {
+	case "", CompressionGzip, CompressionZstd:
+		return nil
+	default:
+		return apperr.New(fmt.Sprintf("unknown compression %q", compression), ErrUnsupportedFormat, op)
+	}
+}
+
+func compress(compression string, data []byte) ([]byte, error) {
+	const op = apperr.Op("syncer.compress")
+
+	switch compression {
+	case "":
+		return data, nil
+	case CompressionGzip:
+		var b bytes.Buffer
+		w := gzip.NewWriter(&b)
+		if _, err := w.Write(data); err != nil {
+			return nil, apperr.New(fmt.Sprintf("cannot gzip bucket"), err, op, ErrInvalidBucket)
+		}
+		if err := w.Close(); err != nil {
+			return nil, apperr.New(fmt.Sprintf("cannot gzip bucket"), err, op, ErrInvalidBucket)
+		}
+		return b.Bytes(), nil
+	case CompressionZstd:
+		w, err := zstd.NewWriter(nil)
+		if err != nil {
+			return nil, apperr.New(fmt.Sprintf("cannot create zstd writer"), err, op, ErrInvalidBucket)
+		}
+		defer w.Close()
+		return w.EncodeAll(data, nil), nil
+	default:
+		return nil, apperr.New(fmt.Sprintf("unknown compression %q", compression), ErrUnsupportedFormat, op)
+	}
+}
+
+func decompress(compression string, data []byte) ([]byte, error) {
+	const op = apperr.Op("syncer.decompress")
+
+	switch compression {
+	case "":
+		return data, nil
+	case CompressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, apperr.New(fmt.Sprintf("cannot gunzip bucket"), err, op, ErrInvalidBucket)
+		}
+		defer r.Close()
+		out, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, apperr.New(fmt.Sprintf("cannot gunzip bucket"), err, op, ErrInvalidBucket)
+		}
+		return out, nil
+	case CompressionZstd:
+		r, err := zstd.NewReader(nil)
+		if err != nil {
+			return nil, apperr.New(fmt.Sprintf("cannot create zstd reader"), err, op, ErrInvalidBucket)
+		}
+		defer r.Close()
+		out, err := r.DecodeAll(data, nil)
+		if err != nil {
+			return nil, apperr.New(fmt.Sprintf("cannot decode zstd bucket"), err, op, ErrInvalidBucket)
+		}
+		return out, nil
+	default:
+		return nil, apperr.New(fmt.Sprintf("unknown compression %q", compression), ErrUnsupportedFormat, op)
+	}
+}
+
+// shardKey is the key of part of a bucket, first part is the bucket key itself so unsharded buckets look like before
+func shardKey(id int, shard int) string {
+	if shard == 0 {
+		return bucketKey(id)
+	}
+	return fmt.Sprintf("%d.%d", id, shard)
+}
+
+// parseShardKey is the reverse of shardKey, false for keys which are not buckets like index or header
+func parseShardKey(key string) (int, int, bool) {
+	parts := strings.SplitN(key, ".", 2)
+	id, err := strconv.Atoi(parts[0])
+	if err != nil || id < 0 {
+		return 0, 0, false
+	}
+	if len(parts) == 1 {
+		return id, 0, true
+	}
+	shard, err := strconv.Atoi(parts[1])
+	if err != nil || shard < 1 {
+		return 0, 0, false
+	}
+	return id, shard, true
+}
+
+// split cuts value into parts of at most size bytes, there is always at least one part
+func split(value []byte, size int) [][]byte {
+	parts := [][]byte{}
+	for len(value) > size {
+		parts = append(parts, value[:size])
+		value = value[size:]
+	}
+	return append(parts, value)
+}
@@ -0,0 +1,126 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ExpediaGroup/vsync/apperr"
+	"github.com/ExpediaGroup/vsync/vault"
+	"github.com/rs/zerolog/log"
+)
+
+var ErrSecretConflict = fmt.Errorf("destination secret changed outside of vsync")
+
+// policies for a destination secret changed outside of vsync
+const (
+	ConflictOverwrite = "overwrite"
+	ConflictSkip      = "skip"
+	ConflictCopy      = "copy"
+)
+
+// VersionKey in custom metadata of a destination secret is the version vsync wrote last
+const VersionKey = "vsync-version"
+
+// ConflictPolicy for destination secrets changed outside of vsync, set once before destination sync starts
+var ConflictPolicy = ConflictOverwrite
+
+// ConflictPrefix is added after mount to path of a secret changed outside of vsync when policy is copy
+var ConflictPrefix = "vsync-conflicts/"
+
+// CheckConflictPolicy returns error for unknown policies
+func CheckConflictPolicy(policy string) error {
+	const op = apperr.Op("syncer.CheckConflictPolicy")
+
+	switch policy {
+	case ConflictOverwrite, ConflictSkip, ConflictCopy:
+		return nil
+	default:
+		return apperr.New(fmt.Sprintf("unknown conflict policy %q, options: %s | %s | %s", policy, ConflictOverwrite, ConflictSkip, ConflictCopy), ErrInitialize, op)
+	}
+}
+
+// guardWrite returns cas for writing a kv v2 destination path, -1 for kv v1 paths as they have no versions
+// a current version other than the one vsync wrote last is a conflict, then conflict policy decides
+// secrets without version key were never written by vsync with cas, they are overwritten like before unless ownership is on
+func guardWrite(v *vault.Client, path string) (int64, error) {
+	const op = apperr.Op("syncer.guardWrite")
+
+	if v.KVVersion(path) == 1 {
+		return -1, nil
+	}
+
+	meta, ok, err := readKVV2Meta(v, path)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, nil
+	}
+
+	if Ownership && !owned(meta) {
+		return 0, apperr.New(fmt.Sprintf("path %q exists without provenance of vsync", path), ErrSecretConflict, op)
+	}
+
+	current := strconv.FormatInt(meta.CurrentVersion, 10)
+	written, stamped := meta.Custom[VersionKey]
+	if !stamped || written == current {
+		return meta.CurrentVersion, nil
+	}
+
+	log.Warn().Str("path", path).Str("writtenVersion", written).Str("currentVersion", current).Str("policy", ConflictPolicy).Msg("destination secret changed outside of vsync")
+	switch ConflictPolicy {
+	case ConflictSkip:
+		return 0, apperr.New(fmt.Sprintf("version %s of path %q is not the version %s written by vsync", current, path, written), ErrSecretConflict, op)
+	case ConflictCopy:
+		if err := copyConflict(v, path); err != nil {
+			return 0, err
+		}
+	}
+	return meta.CurrentVersion, nil
+}
+
+// conflictPath is path of kv v2 data path under conflict prefix of its mount
+func conflictPath(v *vault.Client, path string) string {
+	mount, _ := v.KVMount(path)
+	return mount + "data/" + ConflictPrefix + strings.TrimPrefix(path, mount+"data/")
+}
+
+// copyConflict keeps latest data of destination path in conflict path, every conflict is a new version there
+func copyConflict(v *vault.Client, path string) error {
+	const op = apperr.Op("syncer.copyConflict")
+
+	data, err := readKVData(v, path)
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		// latest version is deleted, nothing to keep
+		return nil
+	}
+
+	newPath := conflictPath(v, path)
+	meta, _, err := readKVV2Meta(v, newPath)
+	if err != nil {
+		return err
+	}
+	if err := writeKVData(v, newPath, data, meta.CurrentVersion); err != nil {
+		return apperr.New(fmt.Sprintf("cannot copy path %q to conflict path %q", path, newPath), err, op, ErrInvalidPath)
+	}
+	log.Info().Str("path", path).Str("conflictPath", newPath).Msg("copied destination secret changed outside of vsync")
+	return nil
+}
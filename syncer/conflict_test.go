@@ -0,0 +1,59 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGuardWrite(t *testing.T) {
+	defer func() { ConflictPolicy = ConflictOverwrite }()
+	written := map[string]map[string]interface{}{}
+	v := conflictVault(t, written)
+
+	cas, err := guardWrite(v, "secret/data/synced")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), cas)
+	cas, err = guardWrite(v, "secret/data/legacy")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), cas, "never stamped by vsync")
+	cas, err = guardWrite(v, "secret/data/new")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), cas, "new path")
+
+	ConflictPolicy = ConflictOverwrite
+	cas, err = guardWrite(v, "secret/data/edited")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), cas)
+
+	ConflictPolicy = ConflictSkip
+	_, err = guardWrite(v, "secret/data/edited")
+	assert.True(t, errors.Is(err, ErrSecretConflict))
+
+	ConflictPolicy = ConflictCopy
+	cas, err = guardWrite(v, "secret/data/edited")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), cas)
+	assert.Equal(t, map[string]interface{}{
+		"data":    map[string]interface{}{"password": "local"},
+		"options": map[string]interface{}{"cas": float64(0)},
+	}, written["/v1/secret/data/vsync-conflicts/edited"])
+
+	assert.Error(t, CheckConflictPolicy("merge"))
+}
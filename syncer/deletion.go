@@ -0,0 +1,179 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ExpediaGroup/vsync/apperr"
+	"github.com/ExpediaGroup/vsync/vault"
+)
+
+// Undelete is a version deleted and then undeleted in origin, mirrored like kinds of delete
+const Undelete = "undelete"
+
+// DeletePolicy is kinds of delete a destination mount mirrors from origin
+type DeletePolicy map[string]bool
+
+// DefaultDeletePolicy is for destination mounts without a policy, only soft deletes latest version like before
+var DefaultDeletePolicy = DeletePolicy{DeletedSoft: true, Undelete: true}
+
+// DeletePolicies by destination mount, set once before destination sync starts
+var DeletePolicies = map[string]DeletePolicy{}
+
+// NewDeletePolicy allows only the given kinds of delete
+func NewDeletePolicy(allow []string) (DeletePolicy, error) {
+	const op = apperr.Op("syncer.NewDeletePolicy")
+
+	p := DeletePolicy{}
+	for _, kind := range allow {
+		switch kind {
+		case DeletedSoft, DeletedDestroy, DeletedMetadata, Undelete:
+			p[kind] = true
+		default:
+			return nil, apperr.New(fmt.Sprintf("unknown kind of delete %q, options: %s | %s | %s | %s", kind, DeletedSoft, DeletedDestroy, DeletedMetadata, Undelete), ErrInitialize, op)
+		}
+	}
+	return p, nil
+}
+
+// deletePolicyOf destination path is the policy of its longest mount
+func deletePolicyOf(path string) DeletePolicy {
+	mount := ""
+	for m := range DeletePolicies {
+		if strings.HasPrefix(path, m) && len(m) > len(mount) {
+			mount = m
+		}
+	}
+	if mount == "" {
+		return DefaultDeletePolicy
+	}
+	return DeletePolicies[mount]
+}
+
+// mirror returns the kind of delete performed in destination for a kind of delete in origin
+// destroy and metadata delete cannot be undone, so when blocked they fall back to soft delete if allowed
+func (p DeletePolicy) mirror(kind string) (string, bool) {
+	if p[kind] {
+		return kind, true
+	}
+	if (kind == DeletedDestroy || kind == DeletedMetadata) && p[DeletedSoft] {
+		return DeletedSoft, true
+	}
+	return "", false
+}
+
+// mirrorDelete performs kind of delete on a kv v2 data path in vault
+// destroy and undelete act on current version of path, nothing is done if path has no metadata
+// returns false when there was no version to act on or a destroyed version to undelete
+func mirrorDelete(v *vault.Client, path string, kind string) (bool, error) {
+	return mirrorDeleteVersion(v, path, kind, 0)
+}
+
+// mirrorDeleteVersion is mirrorDelete with destroy and undelete acting on version, 0 is current version
+func mirrorDeleteVersion(v *vault.Client, path string, kind string, version int64) (bool, error) {
+	const op = apperr.Op("syncer.mirrorDeleteVersion")
+
+	// kv v1 has no versions, every kind of delete removes the path and nothing can be undeleted
+	if v.KVVersion(path) == 1 {
+		if kind == Undelete {
+			return false, nil
+		}
+		_, err := v.Logical().Delete(path)
+		return true, err
+	}
+
+	switch kind {
+	case DeletedSoft:
+		_, err := v.Logical().Delete(path)
+		return true, err
+	case DeletedMetadata:
+		_, err := v.Logical().Delete(strings.Replace(path, "/data/", "/metadata/", 1))
+		return true, err
+	case DeletedDestroy, Undelete:
+		secret, err := v.Logical().Read(strings.Replace(path, "/data/", "/metadata/", 1))
+		if err != nil {
+			return false, apperr.New(fmt.Sprintf("cannot read metadata for path %q", path), err, op, ErrInvalidPath)
+		}
+		if secret == nil {
+			return false, nil
+		}
+		meta, err := getKVV2Meta(secret)
+		if err != nil {
+			return false, apperr.New(fmt.Sprintf("cannot gather meta info for path %q", path), err, op, ErrInvalidMeta)
+		}
+		if version == 0 {
+			version = meta.CurrentVersion
+		}
+		kept, ok := meta.Versions[version]
+		if !ok || (kind == Undelete && kept.Destroyed) {
+			// destroyed data or a version removed after max_versions cannot come back
+			return false, nil
+		}
+
+		_, err = v.Logical().Write(strings.Replace(path, "/data/", "/"+kind+"/", 1), map[string]interface{}{
+			"versions": []int64{version},
+		})
+		return true, err
+	default:
+		return false, apperr.New(fmt.Sprintf("unknown kind of delete %q for path %q", kind, path), ErrUnknownOp, op)
+	}
+}
+
+// destinationVersion finds version of kv v2 destination path synced from originVersion
+// provenance of vsync names the origin version of the version it wrote, with history replay version numbers line up with origin
+// false when destination has no version of originVersion, like when origin wrote newer versions not synced yet
+func destinationVersion(v *vault.Client, path string, originVersion int64) (int64, bool, error) {
+	meta, ok, err := readKVV2Meta(v, path)
+	if err != nil || !ok {
+		return 0, false, err
+	}
+	if meta.Custom[OriginVersionKey] == strconv.FormatInt(originVersion, 10) {
+		if written, err := strconv.ParseInt(meta.Custom[VersionKey], 10, 64); err == nil && written > 0 {
+			return written, true, nil
+		}
+	}
+	if _, kept := meta.Versions[originVersion]; ReplayHistory && kept {
+		return originVersion, true, nil
+	}
+	return 0, false, nil
+}
+
+// mirrorOriginDelete performs kind of delete done on originVersion in origin on a destination path
+// destroy and undelete act on destination version synced from originVersion, so a destination version of other data is never destroyed
+// without such version a destroy soft deletes current version, as origin path has no live data anymore, and nothing is undeleted
+// returns kind of delete performed too
+func mirrorOriginDelete(v *vault.Client, path string, kind string, originVersion int64) (bool, string, error) {
+	if v.KVVersion(path) == 1 || (kind != DeletedDestroy && kind != Undelete) {
+		done, err := mirrorDelete(v, path, kind)
+		return done, kind, err
+	}
+
+	version, mapped, err := destinationVersion(v, path, originVersion)
+	if err != nil {
+		return false, kind, err
+	}
+	if !mapped {
+		if kind == Undelete {
+			return false, kind, nil
+		}
+		done, err := mirrorDelete(v, path, DeletedSoft)
+		return done, DeletedSoft, err
+	}
+	done, err := mirrorDeleteVersion(v, path, kind, version)
+	return done, kind, err
+}
@@ -0,0 +1,117 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeletePolicy(t *testing.T) {
+	_, err := NewDeletePolicy([]string{"purge"})
+	assert.Error(t, err)
+
+	strict, err := NewDeletePolicy([]string{DeletedDestroy, DeletedMetadata, Undelete})
+	require.NoError(t, err)
+	none, err := NewDeletePolicy(nil)
+	require.NoError(t, err)
+
+	DeletePolicies = map[string]DeletePolicy{"secret/": strict, "secret/team/": none}
+	defer func() { DeletePolicies = map[string]DeletePolicy{} }()
+
+	assert.Equal(t, strict, deletePolicyOf("secret/data/app1"))
+	assert.Equal(t, none, deletePolicyOf("secret/team/data/app1"))
+	assert.Equal(t, DefaultDeletePolicy, deletePolicyOf("other/data/app1"))
+
+	kind, ok := strict.mirror(DeletedDestroy)
+	assert.True(t, ok)
+	assert.Equal(t, DeletedDestroy, kind)
+	_, ok = strict.mirror(DeletedSoft)
+	assert.False(t, ok)
+
+	// irreversible deletes fall back to soft delete
+	kind, ok = DefaultDeletePolicy.mirror(DeletedMetadata)
+	assert.True(t, ok)
+	assert.Equal(t, DeletedSoft, kind)
+	_, ok = none.mirror(DeletedDestroy)
+	assert.False(t, ok)
+}
+
+func TestCompareDeletionKinds(t *testing.T) {
+	live := Insight{Version: 2, UpdateTime: "2019-09-15T00:58:20.680948367Z", Type: "kvV2"}
+	soft := live
+	soft.Deleted = DeletedSoft
+	soft.DeletionTime = "2019-09-16T00:00:00Z"
+	destroyed := soft
+	destroyed.Deleted = DeletedDestroy
+	older := soft
+	older.Version = 1
+
+	origin := Bucket{
+		"secret/data/undeleted": live,
+		"secret/data/rewritten": live,
+		"secret/data/destroyed": destroyed,
+	}
+	destination := Bucket{
+		"secret/data/undeleted": soft,
+		"secret/data/rewritten": older,
+		"secret/data/destroyed": soft,
+	}
+
+	add, update, del, errs := compareBuckets(origin, destination, compareOptions{tombstones: true})
+	assert.Empty(t, errs)
+	assert.Empty(t, update)
+
+	ops := map[string]string{}
+	for _, task := range append(add, del...) {
+		ops[task.Path] = task.Op
+	}
+	assert.Equal(t, map[string]string{
+		"secret/data/undeleted": "undelete",
+		"secret/data/rewritten": "add",
+		"secret/data/destroyed": "delete",
+	}, ops)
+}
+
+func TestMirrorOriginDelete(t *testing.T) {
+	v, secrets := memVault(t)
+	require.NoError(t, writeKVData(v, "secret/data/app", map[string]interface{}{"password": "five"}, 0))
+	require.NoError(t, stampProvenance(v, "secret/data/app", Provenance{Origin: "origin", OriginPath: "secret/data/app", OriginVersion: 5, Version: 1}))
+
+	// origin wrote and destroyed version 6 before destination synced it, version 1 holds other data
+	done, kind, err := mirrorOriginDelete(v, "secret/data/app", DeletedDestroy, 6)
+	require.NoError(t, err)
+	assert.True(t, done)
+	assert.Equal(t, DeletedSoft, kind, "destroy without destination version falls back to soft delete")
+	assert.False(t, secrets["secret/app"].destroyed[1])
+	assert.True(t, secrets["secret/app"].deleted[1])
+
+	done, _, err = mirrorOriginDelete(v, "secret/data/app", Undelete, 6)
+	require.NoError(t, err)
+	assert.False(t, done, "nothing to undelete for an origin version never synced")
+
+	done, _, err = mirrorOriginDelete(v, "secret/data/app", Undelete, 5)
+	require.NoError(t, err)
+	assert.True(t, done)
+	assert.False(t, secrets["secret/app"].deleted[1])
+
+	done, kind, err = mirrorOriginDelete(v, "secret/data/app", DeletedDestroy, 5)
+	require.NoError(t, err)
+	assert.True(t, done)
+	assert.Equal(t, DeletedDestroy, kind)
+	assert.True(t, secrets["secret/app"].destroyed[1])
+}
@@ -16,8 +16,10 @@ package syncer
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/ExpediaGroup/vsync/apperr"
 	"github.com/ExpediaGroup/vsync/transformer"
@@ -28,7 +30,7 @@ import (
 func FetchAndSave(ctx context.Context,
 	wg *sync.WaitGroup, workerId int,
 	originVault *vault.Client, destinationVault *vault.Client,
-	info *Info, pack transformer.Pack,
+	info *Info, pack transformer.Pack, originName string, journal *Journal,
 	inTaskCh chan Task, errCh chan error) {
 	const op = apperr.Op("syncer.FetchAndSave")
 	for {
@@ -46,29 +48,130 @@ func FetchAndSave(ctx context.Context,
 			log.Debug().Str("path", task.Path).Str("operation", task.Op).Int("workerId", workerId).Msg("task received by fetch and save worker")
 
 			switch task.Op {
-			case "add", "update":
-				// fetch from origin
-				originSecret, err := originVault.Logical().Read(task.Path)
+			case "undelete":
+				newPath, ok := pack.Transform(task.Path)
+				if !ok {
+					log.Debug().Str("path", task.Path).Str("operation", task.Op).Int("workerId", workerId).Msg("cannot transforming the path")
+					errCh <- apperr.New(fmt.Sprintf("worker %q performed %q operation, cannot transform path %q", workerId, task.Op, task.Path), ErrTransform, op)
+					continue
+				}
+				newPath = kvPath(destinationVault, newPath, task.Insight.Type)
+
+				if _, ok := deletePolicyOf(newPath).mirror(Undelete); !ok {
+					log.Info().Str("path", newPath).Msg("delete policy of mount blocks undelete, so not undeleting this path. Not saving this in destination sync info too")
+					continue
+				}
+
+				if err := checkOwner(destinationVault, newPath); err != nil {
+					reportConflict(err, newPath, task, workerId, errCh)
+					continue
+				}
+
+				entry, recorded := journal.prior(destinationVault, newPath)
+				undeleted, _, err := mirrorOriginDelete(destinationVault, newPath, Undelete, task.Insight.Version)
 				if err != nil {
-					log.Debug().Err(err).Str("path", task.Path).Str("operation", task.Op).Int("workerId", workerId).Msg("error while fetching a path from origin vault")
-					errCh <- apperr.New(fmt.Sprintf("worker %q performed %q operation, cannot fetch path %q from origin vault", workerId, task.Op, task.Path), err, op, ErrInvalidPath)
+					log.Debug().Err(err).Str("path", task.Path).Str("operation", task.Op).Int("workerId", workerId).Msg("error while undeleting a path in destination vault")
+					errCh <- apperr.New(fmt.Sprintf("worker %q performed %q operation, cannot undelete path %q in destination vault", workerId, task.Op, task.Path), err, op, ErrInvalidPath)
+					continue
+				}
+				if undeleted {
+					if recorded {
+						journal.change(entry, info, task, JournalUndelete, entry.PriorVersion)
+					}
+					id, err := info.Put(task.Path, task.Insight)
+					if err != nil {
+						log.Debug().Err(err).Str("path", task.Path).Str("operation", task.Op).Int("bucketId", id).Int("workerId", workerId).Msg("cannot save insight in bucket")
+						errCh <- apperr.New(fmt.Sprintf("worker %q performed %q operation, cannot save path %q insight to bucket %q", workerId, task.Op, task.Path, id), err, op, ErrInvalidBucket)
+					}
+					continue
 				}
 
+				// destination never had the version of origin or destroyed it, so copy it from origin
+				log.Debug().Str("path", newPath).Int("workerId", workerId).Msg("nothing to undelete in destination vault, adding path")
+				fallthrough
+			case "add", "update":
 				// transform
 				newPath, ok := pack.Transform(task.Path)
 				if ok {
 					log.Info().Str("oldPath", task.Path).Str("newPath", newPath).Msg("transformed secret path to be added or updated")
 				} else {
 					log.Error().Str("path", task.Path).Str("operation", task.Op).Int("workerId", workerId).Msg("cannot transforming the path")
-					errCh <- apperr.New(fmt.Sprintf("worker %q performed %q operation, cannot transform path %q", workerId, task.Op, task.Path), err, op, ErrTransform)
+					errCh <- apperr.New(fmt.Sprintf("worker %q performed %q operation, cannot transform path %q", workerId, task.Op, task.Path), ErrTransform, op)
 				}
+				newPath = kvPath(destinationVault, newPath, task.Insight.Type)
 
-				// save to destination
-				_, err = destinationVault.Logical().Write(newPath, originSecret.Data)
+				// history replay reads every version itself, only kv v2 has versions on both sides
+				replay := ReplayHistory && task.Insight.Type == "kvV2" && destinationVault.KVVersion(newPath) == 2
+
+				// fetch version of insight from origin, so data saved in destination is what insight says
+				var data map[string]interface{}
+				var err error
+				if !replay {
+					data, err = readInsightData(originVault, task.Path, task.Insight)
+					if errors.Is(err, ErrVersionGone) {
+						log.Info().Str("path", task.Path).Int64("version", task.Insight.Version).Str("operation", task.Op).Int("workerId", workerId).Msg("origin version is gone since sync info was made, retrying in next cycle")
+						errCh <- apperr.New(fmt.Sprintf("worker %q performed %q operation, version %d of path %q is gone in origin vault", workerId, task.Op, task.Insight.Version, task.Path), err, op)
+						continue
+					}
+					if err != nil {
+						log.Debug().Err(err).Str("path", task.Path).Str("operation", task.Op).Int("workerId", workerId).Msg("error while fetching a path from origin vault")
+						errCh <- apperr.New(fmt.Sprintf("worker %q performed %q operation, cannot fetch path %q from origin vault", workerId, task.Op, task.Path), err, op, ErrInvalidPath)
+						continue
+					}
+				}
+
+				// check-and-set on version written last by vsync, conflict policy decides about changes made in destination
+				cas, err := guardWrite(destinationVault, newPath)
 				if err != nil {
+					reportConflict(err, newPath, task, workerId, errCh)
+					continue
+				}
+
+				// save to destination, journal keeps version before the write
+				entry, recorded := journal.prior(destinationVault, newPath)
+				written := 1
+				if replay {
+					written, err = replayHistory(originVault, destinationVault, task.Path, newPath, task.Insight.Version, cas)
+					log.Debug().Str("path", newPath).Int("versions", written).Int("workerId", workerId).Msg("replayed origin history in destination")
+				} else {
+					err = writeKVData(destinationVault, newPath, data, cas)
+				}
+				if errors.Is(err, ErrVersionGone) {
+					log.Info().Str("path", task.Path).Int64("version", task.Insight.Version).Str("operation", task.Op).Int("workerId", workerId).Msg("origin version is gone since sync info was made, retrying in next cycle")
+					errCh <- apperr.New(fmt.Sprintf("worker %q performed %q operation, version %d of path %q is gone in origin vault", workerId, task.Op, task.Insight.Version, task.Path), err, op)
+				} else if err != nil {
 					log.Debug().Err(err).Str("path", task.Path).Str("operation", task.Op).Int("workerId", workerId).Msg("error while saving a path to destination vault")
 					errCh <- apperr.New(fmt.Sprintf("worker %q performed %q operation, cannot save path %q to destination vault", workerId, task.Op, task.Path), err, op, ErrInvalidPath)
 				} else {
+					if recorded && written > 0 {
+						journal.change(entry, info, task, JournalWrite, entry.PriorVersion+int64(written))
+					}
+
+					// metadata after data, so that cas_required of origin does not block writing data
+					if task.Insight.Metadata != "" && destinationVault.KVVersion(newPath) == 2 {
+						err = copyMetadata(originVault, destinationVault, task.Path, newPath)
+						if err != nil {
+							log.Debug().Err(err).Str("path", task.Path).Str("operation", task.Op).Int("workerId", workerId).Msg("error while saving metadata of a path to destination vault")
+							errCh <- apperr.New(fmt.Sprintf("worker %q performed %q operation, cannot save metadata of path %q to destination vault", workerId, task.Op, task.Path), err, op, ErrInvalidPath)
+							continue
+						}
+					}
+
+					// after metadata, so its copy does not replace the provenance
+					if cas >= 0 {
+						err = stampProvenance(destinationVault, newPath, Provenance{
+							Origin:        originName,
+							OriginPath:    task.Path,
+							OriginVersion: task.Insight.Version,
+							Version:       cas + int64(written),
+							SyncTime:      time.Now(),
+						})
+						if err != nil {
+							log.Warn().Err(err).Str("path", newPath).Int("workerId", workerId).Msg("cannot save provenance in destination, next write may see a conflict")
+							errCh <- apperr.New(fmt.Sprintf("worker %q performed %q operation, cannot save provenance of path %q in destination vault", workerId, task.Op, newPath), err, op)
+						}
+					}
+
 					// save info with origin path and not transformed path
 					id, err := info.Put(task.Path, task.Insight)
 					if err != nil {
@@ -77,6 +180,36 @@ func FetchAndSave(ctx context.Context,
 					}
 				}
 
+			case "metadata":
+				newPath, ok := pack.Transform(task.Path)
+				if !ok {
+					log.Debug().Str("path", task.Path).Str("operation", task.Op).Int("workerId", workerId).Msg("cannot transforming the path")
+					errCh <- apperr.New(fmt.Sprintf("worker %q performed %q operation, cannot transform path %q", workerId, task.Op, task.Path), ErrTransform, op)
+					continue
+				}
+				newPath = kvPath(destinationVault, newPath, task.Insight.Type)
+
+				if err := checkOwner(destinationVault, newPath); err != nil {
+					reportConflict(err, newPath, task, workerId, errCh)
+					continue
+				}
+
+				// kv v1 has no metadata settings, only sync info remembers them
+				var err error
+				if destinationVault.KVVersion(newPath) == 2 {
+					err = copyMetadata(originVault, destinationVault, task.Path, newPath)
+				}
+				if err != nil {
+					log.Debug().Err(err).Str("path", task.Path).Str("operation", task.Op).Int("workerId", workerId).Msg("error while saving metadata of a path to destination vault")
+					errCh <- apperr.New(fmt.Sprintf("worker %q performed %q operation, cannot save metadata of path %q to destination vault", workerId, task.Op, task.Path), err, op, ErrInvalidPath)
+					continue
+				}
+				id, err := info.Put(task.Path, task.Insight)
+				if err != nil {
+					log.Debug().Err(err).Str("path", task.Path).Str("operation", task.Op).Int("bucketId", id).Int("workerId", workerId).Msg("cannot save insight in bucket")
+					errCh <- apperr.New(fmt.Sprintf("worker %q performed %q operation, cannot save path %q insight to bucket %q", workerId, task.Op, task.Path, id), err, op, ErrInvalidBucket)
+				}
+
 			case "delete":
 				newPath, ok := pack.Transform(task.Path)
 				if ok {
@@ -85,16 +218,62 @@ func FetchAndSave(ctx context.Context,
 					log.Debug().Str("path", task.Path).Str("operation", task.Op).Int("workerId", workerId).Msg("cannot transforming the path")
 					errCh <- apperr.New(fmt.Sprintf("worker %q performed %q operation, cannot transform path %q", workerId, task.Op, task.Path), ErrTransform, op)
 				}
+				newPath = kvPath(destinationVault, newPath, task.Insight.Type)
 
 				if IgnoreDeletes {
 					log.Info().Str("path", newPath).Msg("ignore deletes is true, so not deleting this path. Not saving this in destination sync info too")
 					continue
 				}
 
-				_, err := destinationVault.Logical().Delete(newPath)
+				// path missing in origin without tombstones is soft deleted like before
+				kind := task.Insight.Deleted
+				if kind == "" {
+					kind = DeletedSoft
+				}
+				mirrored, ok := deletePolicyOf(newPath).mirror(kind)
+				if !ok {
+					log.Info().Str("path", newPath).Str("deleted", kind).Msg("delete policy of mount blocks this kind of delete, so not deleting this path. Not saving this in destination sync info too")
+					continue
+				}
+				if mirrored != kind {
+					log.Info().Str("path", newPath).Str("deleted", kind).Str("mirrored", mirrored).Msg("delete policy of mount blocks this kind of delete, falling back")
+				}
+
+				if err := checkOwner(destinationVault, newPath); err != nil {
+					reportConflict(err, newPath, task, workerId, errCh)
+					continue
+				}
+
+				// archive before deleting, a delete which cannot be archived is tried again in next cycle
+				reason := "path missing in origin sync info"
+				if task.Insight.Deleted != "" {
+					reason = fmt.Sprintf("origin %s mirrored as %s", task.Insight.Deleted, mirrored)
+				}
+				if err := quarantine(destinationVault, newPath, task.Path, reason); err != nil {
+					log.Debug().Err(err).Str("path", newPath).Str("operation", task.Op).Int("workerId", workerId).Msg("cannot quarantine a path in destination vault, not deleting it")
+					errCh <- apperr.New(fmt.Sprintf("worker %q performed %q operation, cannot quarantine path %q in destination vault", workerId, task.Op, newPath), err, op, ErrInvalidPath)
+					continue
+				}
+
+				entry, recorded := journal.prior(destinationVault, newPath)
+				_, done, err := mirrorOriginDelete(destinationVault, newPath, mirrored, task.Insight.Version)
+				if err == nil && done != mirrored {
+					log.Info().Str("path", newPath).Str("deleted", mirrored).Str("mirrored", done).Int64("originVersion", task.Insight.Version).Msg("destination has no version synced from deleted origin version, falling back")
+					mirrored = done
+				}
+				if err == nil && recorded {
+					journal.change(entry, info, task, mirrored, entry.PriorVersion)
+				}
 				if err != nil {
 					log.Debug().Err(err).Str("path", task.Path).Str("operation", task.Op).Int("workerId", workerId).Msg("error while saving a path to destination vault")
 					errCh <- apperr.New(fmt.Sprintf("worker %q performed %q operation, cannot save path %q to destination vault", workerId, task.Op, task.Path), err, op, ErrInvalidPath)
+				} else if task.Insight.Deleted != "" {
+					// keep tombstone of origin, so destination sync info looks like origin
+					id, err := info.Put(task.Path, task.Insight)
+					if err != nil {
+						log.Debug().Err(err).Str("path", task.Path).Str("operation", task.Op).Int("bucketId", id).Int("workerId", workerId).Msg("cannot save tombstone in bucket")
+						errCh <- apperr.New(fmt.Sprintf("worker %q performed %q operation, cannot save path %q tombstone to bucket %q", workerId, task.Op, task.Path, id), err, op, ErrInvalidBucket)
+					}
 				} else {
 					id, err := info.Delete(task.Path)
 					if err != nil {
@@ -102,6 +281,42 @@ func FetchAndSave(ctx context.Context,
 						errCh <- apperr.New(fmt.Sprintf("worker %q performed %q operation, cannot delete path %q insight in bucket %q", workerId, task.Op, task.Path, id), err, op, ErrInvalidBucket)
 					}
 				}
+
+			case "prune":
+				// destination path without counterpart in origin, it was never in destination sync info
+				entry, recorded := journal.prior(destinationVault, task.Path)
+				kind, err := Prune(destinationVault, task.Path)
+				if errors.Is(err, ErrSecretConflict) {
+					reportConflict(err, task.Path, task, workerId, errCh)
+					continue
+				}
+				if err != nil {
+					log.Debug().Err(err).Str("path", task.Path).Str("operation", task.Op).Int("workerId", workerId).Msg("cannot prune destination secret without counterpart in origin")
+					errCh <- apperr.New(fmt.Sprintf("worker %q performed %q operation, cannot prune path %q in destination vault", workerId, task.Op, task.Path), err, op, ErrInvalidPath)
+					continue
+				}
+				if kind != "" {
+					if recorded {
+						journal.change(entry, nil, task, kind, entry.PriorVersion)
+					}
+					log.Info().Str("path", task.Path).Str("deleted", kind).Msg("pruned destination secret without counterpart in origin")
+				}
+
+			case "tombstone":
+				// path is not in destination vault, only sync info remembers the delete
+				id, err := info.Put(task.Path, task.Insight)
+				if err != nil {
+					log.Debug().Err(err).Str("path", task.Path).Str("operation", task.Op).Int("bucketId", id).Int("workerId", workerId).Msg("cannot save tombstone in bucket")
+					errCh <- apperr.New(fmt.Sprintf("worker %q performed %q operation, cannot save path %q tombstone to bucket %q", workerId, task.Op, task.Path, id), err, op, ErrInvalidBucket)
+				}
+
+			case "forget":
+				// tombstone expired in origin, nothing to do in destination vault
+				id, err := info.Delete(task.Path)
+				if err != nil {
+					log.Debug().Err(err).Str("path", task.Path).Str("operation", task.Op).Int("bucketId", id).Int("workerId", workerId).Msg("cannot forget tombstone in bucket")
+					errCh <- apperr.New(fmt.Sprintf("worker %q performed %q operation, cannot forget path %q tombstone in bucket %q", workerId, task.Op, task.Path, id), err, op, ErrInvalidBucket)
+				}
 			default:
 				log.Debug().Str("path", task.Path).Str("operation", task.Op).Int("workerId", workerId).Msg("unknown operation for fetch and save worker on path")
 				errCh <- apperr.New(fmt.Sprintf("worker %q performed %q operation, unknown op for fetch and save on path %q", workerId, task.Op, task.Path), ErrUnknownOp, op, apperr.Fatal)
@@ -109,3 +324,17 @@ func FetchAndSave(ctx context.Context,
 		}
 	}
 }
+
+// reportConflict reports a destination path skipped for a conflict, other errors of checking it as invalid path
+// skipped paths are not saved in destination sync info, so they are tried again next cycle
+func reportConflict(err error, path string, task Task, workerId int, errCh chan error) {
+	const op = apperr.Op("syncer.FetchAndSave")
+
+	if errors.Is(err, ErrSecretConflict) {
+		log.Warn().Err(err).Str("path", path).Str("operation", task.Op).Int("workerId", workerId).Msg("skipping destination secret in conflict")
+		errCh <- apperr.New(fmt.Sprintf("worker %q performed %q operation, path %q in destination vault is in conflict, skipped", workerId, task.Op, path), err, op)
+		return
+	}
+	log.Debug().Err(err).Str("path", path).Str("operation", task.Op).Int("workerId", workerId).Msg("error while checking a path in destination vault")
+	errCh <- apperr.New(fmt.Sprintf("worker %q performed %q operation, cannot check path %q in destination vault", workerId, task.Op, path), err, op, ErrInvalidPath)
+}
@@ -0,0 +1,226 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"github.com/ExpediaGroup/vsync/apperr"
+	"github.com/ExpediaGroup/vsync/transformer"
+	"github.com/ExpediaGroup/vsync/vault"
+	"github.com/hashicorp/vault/api"
+	"github.com/rs/zerolog/log"
+)
+
+var ErrFingerprint = fmt.Errorf("invalid fingerprint")
+
+// VerifyFingerprints makes destinations read secrets they already have and compare their fingerprints with origin, set once before destination sync starts
+var VerifyFingerprints = false
+
+// Fingerprinter computes hmac-sha256 of secret data with a key which is never saved in sync info
+// so same data has same fingerprint in origin and destination without any plaintext in store
+type Fingerprinter struct {
+	key []byte
+}
+
+func NewFingerprinter(key []byte) (*Fingerprinter, error) {
+	const op = apperr.Op("syncer.NewFingerprinter")
+
+	if len(key) < 16 {
+		return nil, apperr.New(fmt.Sprintf("fingerprint key must be at least 16 bytes, got %d", len(key)), ErrInitialize, op, apperr.Fatal)
+	}
+	return &Fingerprinter{key: key}, nil
+}
+
+// KeyID identifies the key in header without revealing it, readers compare fingerprints only with same key id
+func (f *Fingerprinter) KeyID() string {
+	if f == nil {
+		return ""
+	}
+	m := hmac.New(sha256.New, f.key)
+	m.Write([]byte("vsync fingerprint key id"))
+	return hex.EncodeToString(m.Sum(nil))[:16]
+}
+
+// Fingerprint is hmac of canonical json of data
+func (f *Fingerprinter) Fingerprint(data map[string]interface{}) (string, error) {
+	const op = apperr.Op("syncer.Fingerprinter.Fingerprint")
+
+	value, err := canonicalJSON(data)
+	if err != nil {
+		return "", apperr.New(fmt.Sprintf("cannot serialize secret data for fingerprint"), err, op, ErrFingerprint)
+	}
+	m := hmac.New(sha256.New, f.key)
+	m.Write(value)
+	return hex.EncodeToString(m.Sum(nil)), nil
+}
+
+// secretData is the key value pairs of a kv v2 secret read from data path
+func secretData(secret *api.Secret) (map[string]interface{}, error) {
+	const op = apperr.Op("syncer.secretData")
+
+	if secret == nil || secret.Data == nil {
+		return nil, apperr.New(fmt.Sprintf("no secret to fingerprint"), ErrFingerprint, op)
+	}
+	if secret.Data["data"] == nil {
+		return map[string]interface{}{}, nil
+	}
+	data, ok := secret.Data["data"].(map[string]interface{})
+	if !ok {
+		return nil, apperr.New(fmt.Sprintf("cannot type cast %q to %q", "data", "map[string]interface{}"), ErrFingerprint, op)
+	}
+	return data, nil
+}
+
+// SetFingerprintKey records id of the key used for fingerprints of insights in info
+func (i *Info) SetFingerprintKey(keyID string) {
+	i.rw.Lock()
+	defer i.rw.Unlock()
+
+	i.header.FingerprintKey = keyID
+}
+
+// AdoptFingerprints takes fingerprints and their key from origin for paths with same version and type, except kv v1
+// used after fingerprints are enabled or their key is changed, so secrets are not rewritten only for new fingerprints
+func (destination *Info) AdoptFingerprints(origin *Info) int {
+	origin.rw.RLock()
+	defer origin.rw.RUnlock()
+	destination.rw.Lock()
+	defer destination.rw.Unlock()
+
+	originInsights := map[string]Insight{}
+	for _, bucket := range origin.buckets {
+		for path, insight := range bucket {
+			originInsights[path] = insight
+		}
+	}
+
+	adopted := 0
+	for _, bucket := range destination.buckets {
+		for path, insight := range bucket {
+			originInsight, ok := originInsights[path]
+			// kv v1 has no version, its fingerprint is the only sign of a change so it is never adopted
+			if !ok || originInsight.Version != insight.Version || originInsight.Type != insight.Type || insight.Type == "kvV1" {
+				continue
+			}
+			if insight.Fingerprint != originInsight.Fingerprint {
+				insight.Fingerprint = originInsight.Fingerprint
+				bucket[path] = insight
+				adopted++
+			}
+		}
+	}
+	destination.header.FingerprintKey = origin.header.FingerprintKey
+
+	return adopted
+}
+
+// Paths returns all paths in info
+func (i *Info) Paths() []string {
+	i.rw.RLock()
+	defer i.rw.RUnlock()
+
+	paths := []string{}
+	for _, bucket := range i.buckets {
+		for path := range bucket {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// Get returns insight of path, false if path is not in info
+func (i *Info) Get(path string) (Insight, bool, error) {
+	id, err := i.generateBucketId(path)
+	if err != nil {
+		return Insight{}, false, err
+	}
+
+	i.rw.RLock()
+	defer i.rw.RUnlock()
+
+	insight, ok := i.buckets[id][path]
+	return insight, ok, nil
+}
+
+// VerifyFingerprint reads destination secrets of paths and replaces fingerprints in info with fingerprints of what is really saved
+// a secret changed outside of vsync then has a fingerprint different from origin and compare schedules an update
+func VerifyFingerprint(ctx context.Context,
+	wg *sync.WaitGroup, workerId int,
+	destinationVault *vault.Client,
+	info *Info, pack transformer.Pack, f *Fingerprinter,
+	inPathCh chan string, errCh chan error) {
+	const op = apperr.Op("syncer.VerifyFingerprint")
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Debug().Str("trigger", "context done").Int("workerId", workerId).Msg("closed verify fingerprint worker")
+			wg.Done()
+			return
+		case path, ok := <-inPathCh:
+			if !ok {
+				log.Debug().Str("trigger", "nil channel").Int("workerId", workerId).Msg("closed verify fingerprint worker")
+				wg.Done()
+				return
+			}
+
+			insight, ok, err := info.Get(path)
+			if err != nil || !ok || insight.Fingerprint == "" {
+				continue
+			}
+
+			newPath, ok := pack.Transform(path)
+			if !ok {
+				log.Debug().Str("path", path).Int("workerId", workerId).Msg("cannot transform path for verifying fingerprint")
+				errCh <- apperr.New(fmt.Sprintf("worker %q cannot transform path %q for verifying fingerprint", workerId, path), ErrTransform, op)
+				continue
+			}
+
+			newPath = kvPath(destinationVault, newPath, insight.Type)
+
+			data, err := readKVData(destinationVault, newPath)
+			if err != nil {
+				log.Debug().Err(err).Str("path", newPath).Int("workerId", workerId).Msg("cannot read destination secret for verifying fingerprint")
+				errCh <- apperr.New(fmt.Sprintf("worker %q cannot read path %q for verifying fingerprint", workerId, newPath), err, op, ErrInvalidPath)
+				continue
+			}
+			if data == nil {
+				// deleted outside of vsync, fingerprint of nothing never matches origin
+				insight.Fingerprint = "missing"
+			} else {
+				fingerprint, err := f.Fingerprint(data)
+				if err != nil {
+					errCh <- apperr.New(fmt.Sprintf("worker %q cannot fingerprint path %q", workerId, newPath), err, op, ErrFingerprint)
+					continue
+				}
+				if fingerprint == insight.Fingerprint {
+					continue
+				}
+				insight.Fingerprint = fingerprint
+			}
+
+			log.Warn().Str("path", newPath).Int("workerId", workerId).Msg("destination secret changed outside of vsync")
+			if _, err := info.Put(path, insight); err != nil {
+				errCh <- apperr.New(fmt.Sprintf("worker %q cannot save fingerprint of path %q", workerId, path), err, op, ErrInvalidBucket)
+			}
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFingerprint(t *testing.T) {
+	f, err := NewFingerprinter([]byte("0123456789abcdef"))
+	require.NoError(t, err)
+	other, err := NewFingerprinter([]byte("fedcba9876543210"))
+	require.NoError(t, err)
+	_, err = NewFingerprinter([]byte("short"))
+	assert.Error(t, err)
+
+	a, err := f.Fingerprint(map[string]interface{}{"user": "admin", "password": "secret"})
+	require.NoError(t, err)
+	b, err := f.Fingerprint(map[string]interface{}{"password": "secret", "user": "admin"})
+	require.NoError(t, err)
+	c, err := other.Fingerprint(map[string]interface{}{"user": "admin", "password": "secret"})
+	require.NoError(t, err)
+	assert.Equal(t, a, b)
+	assert.NotEqual(t, a, c)
+	assert.NotContains(t, a, "secret")
+	assert.NotEqual(t, f.KeyID(), other.KeyID())
+	assert.Equal(t, "", (*Fingerprinter)(nil).KeyID())
+}
+
+func TestCompareFingerprints(t *testing.T) {
+	insight := Insight{Version: 3, UpdateTime: "2019-09-15T00:58:20.680948367Z", Type: "kvV2", Fingerprint: "aa"}
+	changed := insight
+	changed.Fingerprint = "bb"
+
+	_, update, _, errs := CompareBuckets(Bucket{"secret/data/app1": insight}, Bucket{"secret/data/app1": changed})
+	assert.Empty(t, errs)
+	assert.Len(t, update, 1, "same version with different data must be updated")
+
+	// destination without fingerprint is not updated only for a fingerprint
+	legacy := insight
+	legacy.Fingerprint = ""
+	_, update, _, errs = CompareBuckets(Bucket{"secret/data/app1": insight}, Bucket{"secret/data/app1": legacy})
+	assert.Empty(t, errs)
+	assert.Empty(t, update)
+
+	// fingerprints made with other key are adopted instead of updated
+	origin, err := NewInfo(3)
+	require.NoError(t, err)
+	origin.SetFingerprintKey("new")
+	destination, err := NewInfo(3)
+	require.NoError(t, err)
+	destination.SetFingerprintKey("old")
+	_, err = origin.Put("secret/data/app1", insight)
+	require.NoError(t, err)
+	_, err = destination.Put("secret/data/app1", changed)
+	require.NoError(t, err)
+	require.NoError(t, origin.Reindex())
+	require.NoError(t, destination.Reindex())
+
+	_, update, _, errs = origin.Compare(destination)
+	assert.Empty(t, errs)
+	assert.Empty(t, update)
+	assert.Equal(t, 1, destination.AdoptFingerprints(origin))
+	require.NoError(t, destination.Reindex())
+	assert.True(t, origin.InSync(destination))
+}
+
+func TestFmtSerializationCompatible(t *testing.T) {
+	// index of buckets without fingerprints must match what older vsync published
+	bucket := Bucket{"secret/data/app1": {Version: 1, UpdateTime: "2019-09-15T00:58:20.680948367Z", Type: "kvV2"}}
+	sum := sha256.Sum256([]byte("map[secret/data/app1:{1 2019-09-15T00:58:20.680948367Z kvV2}]"))
+
+	contentHash, err := hashBucket(HashSHA256, "", bucket)
+	require.NoError(t, err)
+	assert.Equal(t, hex.EncodeToString(sum[:]), contentHash)
+}
@@ -0,0 +1,198 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"reflect"
+
+	"github.com/ExpediaGroup/vsync/apperr"
+	"github.com/cespare/xxhash/v2"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Hash algorithms used for bucket ids, index and merkle nodes, recorded in header
+const (
+	HashSHA256  = "sha256"
+	HashBlake2b = "blake2b"
+	HashXXHash  = "xxhash"
+)
+
+// Serialization of a bucket before hashing it for index, recorded in header
+const (
+	// SerializationFmt is go's fmt output of bucket, used by sync info before format version 5
+	SerializationFmt = "fmt"
+	// SerializationJSON is json with sorted object keys and no spaces, so any language can verify index
+	SerializationJSON = "json"
+)
+
+// NewHasher returns a new hasher for the hash algorithm recorded in header
+// hashers are not safe for concurrent use, so every caller gets its own
+func NewHasher(algorithm string) (hash.Hash, error) {
+	const op = apperr.Op("syncer.NewHasher")
+
+	switch algorithm {
+	case HashSHA256, "":
+		return sha256.New(), nil
+	case HashBlake2b:
+		return blake2b.New256(nil)
+	case HashXXHash:
+		return xxhash.New(), nil
+	default:
+		return nil, apperr.New(fmt.Sprintf("unknown hash algorithm %q", algorithm), ErrUnsupportedFormat, op)
+	}
+}
+
+func checkSerialization(serialization string) error {
+	const op = apperr.Op("syncer.checkSerialization")
+
+	switch serialization {
+	case "", SerializationJSON:
+		return nil
+	default:
+		return apperr.New(fmt.Sprintf("unknown serialization %q", serialization), ErrUnsupportedFormat, op)
+	}
+}
+
+// SetHashing decides hash algorithm and serialization of buckets for index, only allowed before any path is saved in info
+func (i *Info) SetHashing(algorithm string, serialization string) error {
+	const op = apperr.Op("syncer.Info.SetHashing")
+
+	if algorithm == "" {
+		algorithm = HashSHA256
+	}
+	if serialization == SerializationFmt {
+		serialization = ""
+	}
+	if _, err := NewHasher(algorithm); err != nil {
+		return apperr.New(fmt.Sprintf("cannot set hash algorithm %q", algorithm), err, op, apperr.Fatal, ErrInitialize)
+	}
+	if err := checkSerialization(serialization); err != nil {
+		return apperr.New(fmt.Sprintf("cannot set serialization %q", serialization), err, op, apperr.Fatal, ErrInitialize)
+	}
+
+	emptyHash, err := hashBucket(algorithm, serialization, Bucket{})
+	if err != nil {
+		return apperr.New(fmt.Sprintf("cannot hash empty bucket"), err, op, apperr.Fatal, ErrInitialize)
+	}
+
+	i.rw.Lock()
+	defer i.rw.Unlock()
+
+	for _, bucket := range i.buckets {
+		if len(bucket) > 0 {
+			return apperr.New(fmt.Sprintf("cannot change hash algorithm to %q after paths are saved in info", algorithm), ErrInitialize, op)
+		}
+	}
+	i.header.HashAlgorithm = algorithm
+	i.header.Serialization = serialization
+	for id := range i.index {
+		i.index[id] = emptyHash
+	}
+
+	return nil
+}
+
+// hashBucket is the hash of bucket contents as saved in index
+func hashBucket(algorithm string, serialization string, bucket Bucket) (string, error) {
+	content, err := serialize(serialization, bucket)
+	if err != nil {
+		return "", err
+	}
+	h, err := NewHasher(algorithm)
+	if err != nil {
+		return "", err
+	}
+	if _, err := h.Write(content); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashPath is the hash of path used for finding its bucket
+func hashPath(algorithm string, path string) ([]byte, error) {
+	h, err := NewHasher(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := h.Write([]byte(path)); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+func serialize(serialization string, bucket Bucket) ([]byte, error) {
+	const op = apperr.Op("syncer.serialize")
+
+	switch serialization {
+	case "":
+		return []byte(fmt.Sprint(fmtBucket(bucket))), nil
+	case SerializationJSON:
+		return canonicalJSON(bucket)
+	default:
+		return nil, apperr.New(fmt.Sprintf("unknown serialization %q", serialization), ErrUnsupportedFormat, op)
+	}
+}
+
+// legacyInsight is insight as it was before optional fields, its fmt output is what older vsync hashed
+type legacyInsight struct {
+	Version    int64
+	UpdateTime string
+	Type       string
+}
+
+// fmtBucket keeps fmt output of insights without optional fields same as older vsync, so their indexes still match
+func fmtBucket(bucket Bucket) map[string]interface{} {
+	b := make(map[string]interface{}, len(bucket))
+	for path, insight := range bucket {
+		legacy := Insight{Version: insight.Version, UpdateTime: insight.UpdateTime, Type: insight.Type}
+		if reflect.DeepEqual(insight, legacy) {
+			b[path] = legacyInsight{Version: insight.Version, UpdateTime: insight.UpdateTime, Type: insight.Type}
+			continue
+		}
+		b[path] = insight
+	}
+	return b
+}
+
+// canonicalJSON is json of v with keys of every object sorted and no spaces
+// numbers are kept as written, so big versions do not lose precision
+func canonicalJSON(v interface{}) ([]byte, error) {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	// maps are marshalled with sorted keys but structs in field order, so go through a generic value
+	d := json.NewDecoder(bytes.NewReader(value))
+	d.UseNumber()
+	var generic interface{}
+	if err := d.Decode(&generic); err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	e := json.NewEncoder(&b)
+	e.SetEscapeHTML(false)
+	if err := e.Encode(generic); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(b.Bytes(), []byte("\n")), nil
+}
@@ -0,0 +1,81 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanonicalJSON(t *testing.T) {
+	bucket := Bucket{
+		"secret/data/b&c": {Version: 9007199254740993, UpdateTime: "2019-09-15T00:58:20.680948367Z", Type: "kvV2"},
+		"secret/data/a":   {Version: 1, UpdateTime: "2019-09-15T00:58:20.680948367Z", Type: "kvV2"},
+	}
+	value, err := serialize(SerializationJSON, bucket)
+	require.NoError(t, err)
+	assert.Equal(t, `{"secret/data/a":{"type":"kvV2","updateTime":"2019-09-15T00:58:20.680948367Z","version":1},`+
+		`"secret/data/b&c":{"type":"kvV2","updateTime":"2019-09-15T00:58:20.680948367Z","version":9007199254740993}}`, string(value))
+
+	// sha256 of "{}", so index of empty sync info can be checked by any tool
+	i, err := NewInfo(2)
+	require.NoError(t, err)
+	require.NoError(t, i.SetHashing(HashSHA256, SerializationJSON))
+	index, err := i.GetIndex()
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a",
+		"44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a",
+	}, index)
+	assert.Equal(t, 5, i.Header().minFormatVersion())
+}
+
+func TestCompareHashAlgorithms(t *testing.T) {
+	insight := Insight{Version: 1, UpdateTime: "2019-09-15T00:58:20.680948367Z", Type: "kvV2"}
+
+	for _, algorithm := range []string{HashSHA256, HashBlake2b, HashXXHash} {
+		origin, err := NewInfo(7)
+		require.NoError(t, err)
+		require.NoError(t, origin.SetHashing(algorithm, SerializationJSON))
+		destination, err := NewInfo(7)
+		require.NoError(t, err)
+
+		for i := 0; i < 100; i++ {
+			_, err = origin.Put(fmt.Sprintf("secret/data/%d", i), insight)
+			require.NoError(t, err)
+			if i%2 == 0 {
+				_, err = destination.Put(fmt.Sprintf("secret/data/%d", i), insight)
+				require.NoError(t, err)
+			}
+		}
+		require.NoError(t, origin.Reindex())
+		require.NoError(t, destination.Reindex())
+
+		// destination hashed with sha256 of fmt output is rehashed like origin for comparing
+		assert.False(t, origin.InSync(destination), algorithm)
+		add, update, del, errs := origin.Compare(destination)
+		assert.Empty(t, errs, algorithm)
+		assert.Len(t, add, 50, algorithm)
+		assert.Empty(t, update, algorithm)
+		assert.Empty(t, del, algorithm)
+
+		rehashed, err := destination.Rebucket(origin.Header())
+		require.NoError(t, err)
+		assert.Equal(t, algorithm, rehashed.Header().HashAlgorithm)
+	}
+}
@@ -0,0 +1,225 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"fmt"
+
+	"github.com/ExpediaGroup/vsync/apperr"
+)
+
+var (
+	ErrInvalidHeader     = fmt.Errorf("invalid sync info header")
+	ErrUnsupportedFormat = fmt.Errorf("unsupported sync info format version")
+)
+
+// FormatVersion is the latest version of sync info format understood by this vsync
+// readers accept any format version less than or equal to theirs
+// writers save the lowest format version which can describe the sync info, so older readers keep working until new features are used
+//
+// 0: sync info published before headers existed
+// 1: header with modulo bucketing
+// 2: jump bucketing
+// 3: compressed or sharded buckets
+// 4: merkle index or prefix bucketing
+// 5: canonical json serialization or hash algorithms other than sha256
+// 6: fingerprints in insights
+// 7: tombstones for deleted paths
+// 8: hashes of secret metadata in insights
+// 9: unknown paths and subtrees origin could not walk or read
+const FormatVersion = 9
+
+const headerKey = "header"
+
+// Header describes the sync info it is saved with, so that readers can check if they understand it
+type Header struct {
+	FormatVersion int    `json:"formatVersion"`
+	NumBuckets    int    `json:"numBuckets"`
+	HashAlgorithm string `json:"hashAlgorithm"`
+	Origin        string `json:"origin"`
+	CycleStart    string `json:"cycleStart"`
+	NumPaths      int    `json:"numPaths"`
+	Bucketing     string `json:"bucketing,omitempty"`
+	Compression   string `json:"compression,omitempty"`
+	// Shards is number of keys each bucket is split into, empty when no bucket is split
+	Shards []int `json:"shards,omitempty"`
+	// Fanout is number of children of each node in merkle index, 0 for a flat index
+	Fanout      int `json:"fanout,omitempty"`
+	PrefixDepth int `json:"prefixDepth,omitempty"`
+	// Serialization of buckets before hashing for index, empty for go's fmt output
+	Serialization string `json:"serialization,omitempty"`
+	// FingerprintKey is id of the key used for fingerprints in insights, empty when fingerprints are not enabled
+	FingerprintKey string `json:"fingerprintKey,omitempty"`
+	// Tombstones is true when origin records deletes as tombstones, then readers delete only on a tombstone
+	Tombstones    bool `json:"tombstones,omitempty"`
+	NumTombstones int  `json:"numTombstones,omitempty"`
+	// SecretMetadata is true when insights have hash of kv v2 metadata settings, then readers replicate them
+	SecretMetadata bool `json:"secretMetadata,omitempty"`
+	// Unknown are paths origin could not read and subtrees, ending with /, it could not list
+	// they may still exist in origin, so readers never delete them
+	Unknown []string `json:"unknown,omitempty"`
+}
+
+// minFormatVersion is the lowest format version readers need for understanding sync info described by header
+func (h Header) minFormatVersion() int {
+	if len(h.Unknown) > 0 {
+		return 9
+	}
+	if h.SecretMetadata {
+		return 8
+	}
+	if h.Tombstones || h.NumTombstones > 0 {
+		return 7
+	}
+	if h.FingerprintKey != "" {
+		return 6
+	}
+	if h.Serialization != "" || h.hashAlgorithm() != HashSHA256 {
+		return 5
+	}
+	if h.Fanout > 0 || h.Bucketing == BucketingPrefix {
+		return 4
+	}
+	if h.Compression != "" || len(h.Shards) > 0 {
+		return 3
+	}
+	if h.Bucketing == BucketingJump {
+		return 2
+	}
+	return 1
+}
+
+// Validate checks if header can be read by this vsync and matches the index it was published with
+func (h Header) Validate(numIndex int) error {
+	const op = apperr.Op("syncer.Header.Validate")
+
+	if h.FormatVersion > FormatVersion {
+		return apperr.New(fmt.Sprintf("sync info format version %d is newer than supported version %d, upgrade vsync", h.FormatVersion, FormatVersion), ErrUnsupportedFormat, op)
+	}
+
+	// nothing more to check for sync info published without header
+	if h.FormatVersion == 0 {
+		return nil
+	}
+
+	if h.NumBuckets != numIndex {
+		return apperr.New(fmt.Sprintf("header says %d buckets but index has %d", h.NumBuckets, numIndex), ErrInvalidHeader, op, ErrCorrupted)
+	}
+
+	if h.HashAlgorithm == "" {
+		return apperr.New(fmt.Sprintf("header does not have hash algorithm"), ErrInvalidHeader, op)
+	}
+
+	if _, err := NewHasher(h.HashAlgorithm); err != nil {
+		return apperr.New(fmt.Sprintf("header has unknown hash algorithm"), err, op, ErrInvalidHeader)
+	}
+
+	if err := checkSerialization(h.Serialization); err != nil {
+		return apperr.New(fmt.Sprintf("header has unknown serialization"), err, op, ErrInvalidHeader)
+	}
+
+	if err := checkBucketing(h.Bucketing, h.Fanout); err != nil {
+		return apperr.New(fmt.Sprintf("header has invalid bucketing"), err, op, ErrInvalidHeader)
+	}
+
+	if err := checkCompression(h.Compression); err != nil {
+		return apperr.New(fmt.Sprintf("header has unknown compression"), err, op, ErrInvalidHeader)
+	}
+
+	if len(h.Shards) > 0 && len(h.Shards) != numIndex {
+		return apperr.New(fmt.Sprintf("header has shards for %d buckets but index has %d", len(h.Shards), numIndex), ErrInvalidHeader, op, ErrCorrupted)
+	}
+	for id, n := range h.Shards {
+		if n < 1 {
+			return apperr.New(fmt.Sprintf("header says bucket %d has %d shards", id, n), ErrInvalidHeader, op, ErrCorrupted)
+		}
+	}
+
+	return nil
+}
+
+// Layout is how a writer buckets, indexes, hashes and encodes sync info, as validated from config
+// Header has the fields recorded in sync info, with empty serialization for go's fmt output and empty compression for none
+// MaxBucketSize is not recorded, it only decides when buckets are split
+type Layout struct {
+	Header
+	MaxBucketSize int
+}
+
+// NewInfo returns empty info with buckets, index, hashing and encoding of layout l
+func (l Layout) NewInfo() (*Info, error) {
+	const op = apperr.Op("syncer.Layout.NewInfo")
+
+	i, err := NewInfo(l.NumBuckets)
+	if err != nil {
+		return nil, err
+	}
+	if err := i.SetHashing(l.HashAlgorithm, l.Serialization); err != nil {
+		return nil, apperr.New(fmt.Sprintf("cannot set hash algorithm %q for new sync info", l.HashAlgorithm), err, op, apperr.Fatal, ErrInitialize)
+	}
+	if err := i.SetMerkle(l.Fanout, l.PrefixDepth); err != nil {
+		return nil, apperr.New(fmt.Sprintf("cannot set index fanout %d for new sync info", l.Fanout), err, op, apperr.Fatal, ErrInitialize)
+	}
+	if err := i.SetBucketing(l.Bucketing); err != nil {
+		return nil, apperr.New(fmt.Sprintf("cannot set bucketing %q for new sync info", l.Bucketing), err, op, apperr.Fatal, ErrInitialize)
+	}
+	if err := i.SetEncoding(l.Compression, l.MaxBucketSize); err != nil {
+		return nil, apperr.New(fmt.Sprintf("cannot set bucket compression %q for new sync info", l.Compression), err, op, apperr.Fatal, ErrInitialize)
+	}
+	return i, nil
+}
+
+// SameLayout checks if paths are assigned to same buckets and index is built same way in sync infos with headers h and l
+func (h Header) SameLayout(l Header) bool {
+	if h.NumBuckets != l.NumBuckets || h.Bucketing != l.Bucketing || h.Fanout != l.Fanout {
+		return false
+	}
+	if h.hashAlgorithm() != l.hashAlgorithm() || h.Serialization != l.Serialization {
+		return false
+	}
+	return h.Bucketing != BucketingPrefix || h.PrefixDepth == l.PrefixDepth
+}
+
+// hashAlgorithm is sha256 for sync info published before hash algorithm was recorded
+func (h Header) hashAlgorithm() string {
+	if h.HashAlgorithm == "" {
+		return HashSHA256
+	}
+	return h.HashAlgorithm
+}
+
+// shards is number of keys bucket id is saved in
+func (h Header) shards(id int) int {
+	if id < len(h.Shards) {
+		return h.Shards[id]
+	}
+	return 1
+}
+
+// Comparable checks if sync info with origin header h can be compared with sync info having destination header d
+// different number of buckets, bucketing or hashing is fine, destination is rebucketed and rehashed while comparing
+// but both hash algorithms must be known to this vsync
+func (h Header) Comparable(d Header) error {
+	const op = apperr.Op("syncer.Header.Comparable")
+
+	if _, err := NewHasher(h.HashAlgorithm); err != nil {
+		return apperr.New(fmt.Sprintf("origin uses unknown hash algorithm %q", h.HashAlgorithm), err, op, ErrInvalidHeader)
+	}
+	if _, err := NewHasher(d.HashAlgorithm); err != nil {
+		return apperr.New(fmt.Sprintf("destination uses unknown hash algorithm %q", d.HashAlgorithm), err, op, ErrInvalidHeader)
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeaderValidate(t *testing.T) {
+	assert.NoError(t, Header{}.Validate(19), "legacy sync info without header")
+	assert.NoError(t, Header{FormatVersion: FormatVersion, NumBuckets: 19, HashAlgorithm: HashSHA256}.Validate(19))
+
+	err := Header{FormatVersion: FormatVersion + 1}.Validate(19)
+	assert.True(t, errors.Is(err, ErrUnsupportedFormat))
+
+	err = Header{FormatVersion: FormatVersion, NumBuckets: 7, HashAlgorithm: HashSHA256}.Validate(19)
+	assert.True(t, errors.Is(err, ErrInvalidHeader))
+}
+
+func TestCompareHeaders(t *testing.T) {
+	origin, err := NewInfo(3)
+	require.NoError(t, err)
+	origin.SetOrigin("origin", time.Now())
+
+	destination, err := NewInfo(3)
+	require.NoError(t, err)
+	_, _, _, errs := origin.Compare(destination)
+	assert.Empty(t, errs)
+
+	destination.header.HashAlgorithm = "md5"
+	_, _, _, errs = origin.Compare(destination)
+	require.Len(t, errs, 1)
+	assert.True(t, errors.Is(errs[0], ErrInvalidHeader))
+}
+
+func TestLayoutNewInfo(t *testing.T) {
+	layout := Layout{
+		Header:        Header{NumBuckets: 4, Bucketing: BucketingPrefix, Fanout: 2, PrefixDepth: 1, HashAlgorithm: HashBlake2b, Serialization: SerializationJSON, Compression: CompressionGzip},
+		MaxBucketSize: 1024,
+	}
+	info, err := layout.NewInfo()
+	require.NoError(t, err)
+
+	header := info.Header()
+	assert.True(t, header.SameLayout(layout.Header))
+	assert.Equal(t, CompressionGzip, header.Compression)
+
+	layout.Bucketing = "random"
+	_, err = layout.NewInfo()
+	assert.True(t, errors.Is(err, ErrInitialize))
+}
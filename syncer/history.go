@@ -0,0 +1,154 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ExpediaGroup/vsync/apperr"
+	"github.com/ExpediaGroup/vsync/vault"
+	"github.com/rs/zerolog/log"
+)
+
+// ReplayHistory makes destination write every origin version it has not seen yet, so version numbers line up with origin
+var ReplayHistory = false
+
+// readKVV2Meta reads metadata of a kv v2 data path, false when path has no metadata
+func readKVV2Meta(v *vault.Client, path string) (KVV2Meta, bool, error) {
+	const op = apperr.Op("syncer.readKVV2Meta")
+
+	metaPath := strings.Replace(path, "/data/", "/metadata/", 1)
+	secret, err := v.Logical().Read(metaPath)
+	if err != nil {
+		return KVV2Meta{}, false, apperr.New(fmt.Sprintf("cannot read metadata for path %q", metaPath), err, op, ErrInvalidPath)
+	}
+	if secret == nil {
+		return KVV2Meta{}, false, nil
+	}
+	meta, err := getKVV2Meta(secret)
+	if err != nil {
+		return meta, false, apperr.New(fmt.Sprintf("cannot gather meta info for path %q", metaPath), err, op, ErrInvalidMeta)
+	}
+	return meta, true, nil
+}
+
+// readable is false for versions destroyed, removed after max_versions or deleted already
+// deletion time in future comes from delete_version_after, data is still readable till then
+func (v KVV2Version) readable(now time.Time) bool {
+	if v.Destroyed {
+		return false
+	}
+	if v.DeletionTime == "" {
+		return true
+	}
+	deletionTime, err := time.Parse(time.RFC3339Nano, v.DeletionTime)
+	return err == nil && deletionTime.After(now)
+}
+
+// replayHistory writes origin versions newer than destination current version one by one with check-and-set, up to target version of insight
+// versions which cannot be read in origin are written empty and deleted or destroyed like in origin, so numbers still line up
+// a destination already at target has nothing to replay, a destination ahead of target cannot line up, then only target is written with cas
+// target gone in origin is ErrVersionGone and nothing is written
+// returns number of versions written
+func replayHistory(originVault *vault.Client, destinationVault *vault.Client, originPath string, destinationPath string, target int64, cas int64) (int, error) {
+	const op = apperr.Op("syncer.replayHistory")
+
+	originMeta, ok, err := readKVV2Meta(originVault, originPath)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, apperr.New(fmt.Sprintf("no metadata for path %q in origin", originPath), ErrInvalidPath, op)
+	}
+	if version, kept := originMeta.Versions[target]; !kept || !version.readable(time.Now()) {
+		return 0, apperr.New(fmt.Sprintf("version %d of path %q is deleted or destroyed in origin", target, originPath), ErrVersionGone, op)
+	}
+	destinationMeta, _, err := readKVV2Meta(destinationVault, destinationPath)
+	if err != nil {
+		return 0, err
+	}
+
+	current := destinationMeta.CurrentVersion
+	live := current > 0 && destinationMeta.CurrentDeletionTime == "" && !destinationMeta.Destroyed
+	if current == target && live {
+		log.Debug().Str("path", destinationPath).Int64("version", target).Msg("destination already has version of insight, nothing to replay")
+		return 0, nil
+	}
+
+	if current >= target {
+		log.Warn().Str("path", destinationPath).Int64("originVersion", target).Int64("destinationVersion", current).Msg("destination version is not behind origin, history cannot line up, writing version of insight only")
+		secret, err := readVersion(originVault, originPath, target)
+		if err != nil {
+			return 0, err
+		}
+		data, err := secretData(secret)
+		if err != nil {
+			return 0, apperr.New(fmt.Sprintf("cannot get data of version %d of path %q", target, originPath), err, op, ErrInvalidPath)
+		}
+		if err := writeKVData(destinationVault, destinationPath, data, cas); err != nil {
+			return 0, err
+		}
+		return 1, nil
+	}
+
+	now := time.Now()
+	written := 0
+	for n := current + 1; n <= target; n++ {
+		version, kept := originMeta.Versions[n]
+
+		data := map[string]interface{}{}
+		if kept && version.readable(now) {
+			secret, err := originVault.Logical().ReadWithData(originPath, map[string][]string{"version": {strconv.FormatInt(n, 10)}})
+			if err != nil {
+				return written, apperr.New(fmt.Sprintf("cannot read version %d of path %q from origin", n, originPath), err, op, ErrInvalidPath)
+			}
+			data, err = secretData(secret)
+			if err != nil {
+				return written, apperr.New(fmt.Sprintf("cannot get data of version %d of path %q", n, originPath), err, op, ErrInvalidPath)
+			}
+		}
+
+		// cas fails if destination got another version meanwhile, then numbers would not line up
+		_, err := destinationVault.Logical().Write(destinationPath, map[string]interface{}{
+			"options": map[string]interface{}{"cas": n - 1},
+			"data":    data,
+		})
+		if err != nil {
+			return written, apperr.New(fmt.Sprintf("cannot write version %d of path %q to destination", n, destinationPath), err, op, ErrInvalidPath)
+		}
+		written++
+
+		action := ""
+		switch {
+		case !kept || version.Destroyed:
+			action = "destroy"
+		case !version.readable(now):
+			action = "delete"
+		}
+		if action != "" {
+			_, err = destinationVault.Logical().Write(strings.Replace(destinationPath, "/data/", "/"+action+"/", 1), map[string]interface{}{
+				"versions": []int64{n},
+			})
+			if err != nil {
+				return written, apperr.New(fmt.Sprintf("cannot %s version %d of path %q in destination", action, n, destinationPath), err, op, ErrInvalidPath)
+			}
+		}
+		log.Debug().Str("path", destinationPath).Int64("version", n).Str("action", action).Msg("replayed origin version in destination")
+	}
+	return written, nil
+}
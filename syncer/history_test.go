@@ -0,0 +1,99 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKVV2MetaVersions(t *testing.T) {
+	secret := &api.Secret{Data: map[string]interface{}{
+		"current_version": json.Number("4"),
+		"updated_time":    "2019-09-15T01:10:28.275769286Z",
+		"versions": map[string]interface{}{
+			"2": map[string]interface{}{"created_time": "2019-09-15T00:58:42.568394811Z", "deletion_time": "2019-09-15T00:58:42.582605115Z", "destroyed": false},
+			"3": map[string]interface{}{"created_time": "2019-09-15T01:00:00Z", "deletion_time": "", "destroyed": true},
+			"4": map[string]interface{}{"created_time": "2019-09-15T01:10:28.275769286Z", "deletion_time": "2100-01-01T00:00:00Z", "destroyed": false},
+		},
+	}}
+
+	meta, err := getKVV2Meta(secret)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), meta.CurrentVersion)
+	require.Len(t, meta.Versions, 3)
+
+	now := time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC)
+	_, kept := meta.Versions[1]
+	assert.False(t, kept, "versions removed after max_versions are not in metadata")
+	assert.False(t, meta.Versions[2].readable(now), "deleted version")
+	assert.False(t, meta.Versions[3].readable(now), "destroyed version")
+	assert.True(t, meta.Versions[4].readable(now), "version deleted in future by delete_version_after")
+}
+
+func TestReplayHistory(t *testing.T) {
+	origin, _ := memVault(t)
+	destination, secrets := memVault(t)
+	path := "secret/data/app"
+	for n := int64(1); n <= 6; n++ {
+		require.NoError(t, writeKVData(origin, path, map[string]interface{}{"n": fmt.Sprint(n)}, n-1))
+	}
+	version := func(n int64) map[string]interface{} {
+		return map[string]interface{}{"n": fmt.Sprint(n)}
+	}
+
+	// insight of this cycle has version 5 while origin is already at 6
+	written, err := replayHistory(origin, destination, path, path, 5, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 5, written)
+	assert.Equal(t, int64(5), secrets["secret/app"].current)
+	assert.Equal(t, version(5), secrets["secret/app"].versions[5])
+
+	// next cycle syncs version 6 and numbers still line up
+	written, err = replayHistory(origin, destination, path, path, 6, 5)
+	require.NoError(t, err)
+	assert.Equal(t, 1, written)
+	assert.Equal(t, int64(6), secrets["secret/app"].current)
+	assert.Equal(t, version(6), secrets["secret/app"].versions[6])
+
+	// destination already at version of insight
+	written, err = replayHistory(origin, destination, path, path, 6, 6)
+	require.NoError(t, err)
+	assert.Equal(t, 0, written)
+	assert.Equal(t, int64(6), secrets["secret/app"].current)
+
+	// destination ahead gets only the pinned version, with cas
+	require.NoError(t, writeKVData(destination, path, map[string]interface{}{"n": "local"}, 6))
+	_, err = replayHistory(origin, destination, path, path, 6, 6)
+	assert.True(t, errors.Is(err, ErrSecretConflict), "cas of guard write is kept")
+	written, err = replayHistory(origin, destination, path, path, 6, 7)
+	require.NoError(t, err)
+	assert.Equal(t, 1, written)
+	assert.Equal(t, version(6), secrets["secret/app"].versions[8])
+
+	// version of insight deleted in origin meanwhile
+	_, err = mirrorDelete(origin, path, DeletedSoft)
+	require.NoError(t, err)
+	_, err = replayHistory(origin, destination, "secret/data/app", "secret/data/other", 6, 0)
+	assert.True(t, errors.Is(err, ErrVersionGone))
+	assert.Nil(t, secrets["secret/other"])
+}
@@ -15,11 +15,9 @@
 package syncer
 
 import (
-	"encoding/binary"
-	"encoding/hex"
 	"fmt"
-	"hash"
 	"sync"
+	"time"
 
 	"github.com/ExpediaGroup/vsync/apperr"
 	"github.com/rs/zerolog/log"
@@ -29,7 +27,10 @@ type Info struct {
 	index   []string
 	buckets map[int]Bucket
 	rw      sync.RWMutex
-	hasher  hash.Hash
+	header  Header
+
+	// bytes of a stored bucket value after which it is split into continuation keys
+	maxBucketSize int
 }
 
 type Bucket map[string]Insight
@@ -38,9 +39,17 @@ type Insight struct {
 	Version    int64  `json:"version"`
 	UpdateTime string `json:"updateTime"`
 	Type       string `json:"type"`
+	// Fingerprint is keyed hmac of secret data, empty when fingerprints are not enabled
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// Deleted is kind of delete for a tombstone, empty for a live path
+	Deleted      string `json:"deleted,omitempty"`
+	DeletionTime string `json:"deletionTime,omitempty"`
+	// Metadata is hash of kv v2 metadata settings, empty when secret metadata is not replicated
+	Metadata string `json:"metadata,omitempty"`
 }
 
-func NewInfo(size int, h hash.Hash) (*Info, error) {
+// NewInfo returns info with size empty buckets hashed with sha256 of go's fmt output, SetHashing changes it
+func NewInfo(size int) (*Info, error) {
 	const op = apperr.Op("syncer.NewInfo")
 	if size < 0 {
 		return nil, apperr.New(fmt.Sprintf("cannot initialize info with negative number of buckets %q", size), ErrInitialize, op, apperr.Fatal)
@@ -50,15 +59,19 @@ func NewInfo(size int, h hash.Hash) (*Info, error) {
 		index:   make([]string, 0, size),
 		buckets: map[int]Bucket{},
 		rw:      sync.RWMutex{},
-		hasher:  h,
+		header: Header{
+			FormatVersion: FormatVersion,
+			NumBuckets:    size,
+			HashAlgorithm: HashSHA256,
+			Bucketing:     BucketingModulo,
+		},
+		maxBucketSize: DefaultMaxBucketSize,
 	}
 
-	i.hasher.Reset()
-	_, err := i.hasher.Write([]byte(fmt.Sprintf("%v", Bucket{})))
+	hash, err := hashBucket(HashSHA256, "", Bucket{})
 	if err != nil {
 		return i, apperr.New(fmt.Sprintf("cannot hash dummy bucket"), err, op, apperr.Fatal, ErrInitialize)
 	}
-	hash := hex.EncodeToString((i.hasher.Sum(nil)))
 
 	for j := 0; j < size; j++ {
 		i.index = append(i.index, hash)
@@ -69,18 +82,19 @@ func NewInfo(size int, h hash.Hash) (*Info, error) {
 }
 
 func (i *Info) generateBucketId(path string) (int, error) {
-	i.rw.Lock()
-	defer i.rw.Unlock()
+	i.rw.RLock()
+	defer i.rw.RUnlock()
 
-	i.hasher.Reset()
-	_, err := i.hasher.Write([]byte(path))
+	if i.header.Bucketing == BucketingPrefix {
+		return i.prefixBucketOf(path)
+	}
+
+	pathHash, err := hashPath(i.header.HashAlgorithm, path)
 	if err != nil {
 		return 0, err
 	}
 
-	pathB := i.hasher.Sum(nil)
-	pathI := binary.BigEndian.Uint16(pathB[:])
-	return int(pathI % uint16(len(i.index))), nil
+	return bucketOf(i.header.Bucketing, pathHash, len(i.index))
 }
 
 func (i *Info) Put(path string, insight Insight) (int, error) {
@@ -139,14 +153,11 @@ func (i *Info) Reindex() error {
 	defer i.rw.Unlock()
 
 	for id := 0; id < len(i.index); id++ {
-		i.hasher.Reset()
-		content := fmt.Sprint(i.buckets[id])
-		_, err := i.hasher.Write([]byte(content))
+		contentHash, err := hashBucket(i.header.HashAlgorithm, i.header.Serialization, i.buckets[id])
 		if err != nil {
-			log.Debug().Int("bucketId", id).Interface("content", content).Msg("cannot hash contents")
+			log.Debug().Int("bucketId", id).Interface("content", i.buckets[id]).Msg("cannot hash contents")
 			return apperr.New(fmt.Sprintf("cannot hash contents for bucket %q", id), err, op, ErrInvalidInsight)
 		}
-		contentHash := hex.EncodeToString((i.hasher.Sum(nil)))
 		i.index[id] = contentHash
 		log.Debug().Str("contentHash", fmt.Sprint(contentHash)).Int("bucketId", id).Msg("index updated")
 	}
@@ -180,3 +191,38 @@ func (i *Info) GetBucket(id int) (Bucket, error) {
 
 	return i.buckets[id], nil
 }
+
+// SetOrigin records which origin and cycle generated the sync info, saved in header
+func (i *Info) SetOrigin(name string, cycleStart time.Time) {
+	i.rw.Lock()
+	defer i.rw.Unlock()
+
+	i.header.Origin = name
+	i.header.CycleStart = cycleStart.UTC().Format(time.RFC3339Nano)
+}
+
+// Header returns the header describing current state of sync info
+func (i *Info) Header() Header {
+	i.rw.RLock()
+	defer i.rw.RUnlock()
+
+	h := i.header
+	h.Shards = nil
+	h.NumBuckets = len(i.index)
+
+	// number of paths read from store is kept until buckets are read
+	if len(i.buckets) == len(i.index) {
+		h.NumPaths = 0
+		h.NumTombstones = 0
+		for _, bucket := range i.buckets {
+			for _, insight := range bucket {
+				if insight.Deleted != "" {
+					h.NumTombstones++
+					continue
+				}
+				h.NumPaths++
+			}
+		}
+	}
+	return h
+}
@@ -15,7 +15,6 @@
 package syncer
 
 import (
-	"crypto/sha256"
 	"fmt"
 	"math"
 	"math/rand"
@@ -30,8 +29,7 @@ func TestGenerateBucketID(t *testing.T) {
 	buckets := map[int]float64{}
 	numBuckets := 19
 	numPaths := 100000
-	hasher := sha256.New()
-	info, err := NewInfo(numBuckets, hasher)
+	info, err := NewInfo(numBuckets)
 	require.NoError(t, err)
 
 	rand.Seed(time.Now().UnixNano())
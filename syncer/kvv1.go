@@ -0,0 +1,127 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ExpediaGroup/vsync/apperr"
+	"github.com/ExpediaGroup/vsync/vault"
+)
+
+// kvV1Insight has no version or update time in kv v1, so fingerprint of its content is the only way to see a change
+func kvV1Insight(v *vault.Client, f *Fingerprinter, path string) (Insight, error) {
+	const op = apperr.Op("syncer.kvV1Insight")
+
+	if f == nil {
+		return Insight{}, apperr.New(fmt.Sprintf("kv v1 path %q needs a fingerprint key", path), ErrFingerprint, op)
+	}
+	data, err := readKVData(v, path)
+	if err != nil {
+		return Insight{}, err
+	}
+	if data == nil {
+		return Insight{}, apperr.New(fmt.Sprintf("kv v1 path %q deleted while generating sync info", path), ErrVersionGone, op)
+	}
+	fingerprint, err := f.Fingerprint(data)
+	if err != nil {
+		return Insight{}, apperr.New(fmt.Sprintf("cannot fingerprint kv v1 path %q", path), err, op, ErrFingerprint)
+	}
+	return Insight{Type: "kvV1", Fingerprint: fingerprint}, nil
+}
+
+// readInsightData reads data of origin path as described by its insight, exact version for kv v2 and latest for kv v1
+// a version or kv v1 path gone since insight was made is ErrVersionGone
+func readInsightData(v *vault.Client, path string, insight Insight) (map[string]interface{}, error) {
+	const op = apperr.Op("syncer.readInsightData")
+
+	if insight.Type == "kvV1" {
+		data, err := readKVData(v, path)
+		if err != nil {
+			return nil, err
+		}
+		if data == nil {
+			return nil, apperr.New(fmt.Sprintf("kv v1 path %q is deleted", path), ErrVersionGone, op)
+		}
+		return data, nil
+	}
+
+	secret, err := readVersion(v, path, insight.Version)
+	if err != nil {
+		return nil, err
+	}
+	return secretData(secret)
+}
+
+// kvPath converts a transformed path to kv version of its mount
+// kv v2 paths have data/ right after mount, kv v1 paths do not, so v1 to v2 and v2 to v1 replication keep the rest of path
+func kvPath(v *vault.Client, path string, insightType string) string {
+	mount, version := v.KVMount(path)
+	if mount == "" {
+		return path
+	}
+
+	rest := strings.TrimPrefix(path, mount)
+	if insightType == "kvV2" {
+		rest = strings.TrimPrefix(rest, "data/")
+	}
+	if version == 1 {
+		return mount + rest
+	}
+	return mount + "data/" + rest
+}
+
+// readKVData reads latest data of path in kv version of its mount, nil when path has no data
+func readKVData(v *vault.Client, path string) (map[string]interface{}, error) {
+	const op = apperr.Op("syncer.readKVData")
+
+	secret, err := v.Logical().Read(path)
+	if err != nil {
+		return nil, apperr.New(fmt.Sprintf("cannot read path %q", path), err, op, ErrInvalidPath)
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, nil
+	}
+	if v.KVVersion(path) == 1 {
+		return secret.Data, nil
+	}
+	if secret.Data["data"] == nil {
+		return nil, nil
+	}
+	return secretData(secret)
+}
+
+// writeKVData writes data to path in kv version of its mount
+// kv v2 writes check-and-set with cas unless it is negative, kv v1 has no versions to check
+func writeKVData(v *vault.Client, path string, data map[string]interface{}, cas int64) error {
+	const op = apperr.Op("syncer.writeKVData")
+
+	body := map[string]interface{}{"data": data}
+	if cas >= 0 {
+		body["options"] = map[string]interface{}{"cas": cas}
+	}
+	if v.KVVersion(path) == 1 {
+		body = data
+	}
+	if _, err := v.Logical().Write(path, body); err != nil {
+		// another version was written since cas was read
+		if strings.Contains(err.Error(), "check-and-set") {
+			return apperr.New(fmt.Sprintf("path %q changed while writing", path), err, op, ErrSecretConflict)
+		}
+		return apperr.New(fmt.Sprintf("cannot write path %q", path), err, op, ErrInvalidPath)
+	}
+	return nil
+}
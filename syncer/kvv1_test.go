@@ -0,0 +1,71 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKVPath(t *testing.T) {
+	v := kvVault(t, map[string]map[string]interface{}{})
+
+	assert.Equal(t, "secret/data/app1", kvPath(v, "secret/data/app1", "kvV2"))
+	assert.Equal(t, "legacy/app1", kvPath(v, "legacy/data/app1", "kvV2"), "v2 to v1")
+	assert.Equal(t, "secret/data/app1", kvPath(v, "secret/app1", "kvV1"), "v1 to v2")
+	assert.Equal(t, "legacy/data/app1", kvPath(v, "legacy/data/app1", "kvV1"), "v1 secret named data")
+	assert.Equal(t, "other/data/app1", kvPath(v, "other/data/app1", "kvV2"), "unknown mount is kept")
+}
+
+func TestKVData(t *testing.T) {
+	written := map[string]map[string]interface{}{}
+	v := kvVault(t, written)
+
+	data, err := readKVData(v, "legacy/app1")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"password": "one"}, data)
+	data, err = readKVData(v, "secret/data/app1")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"password": "one"}, data)
+	data, err = readKVData(v, "legacy/gone")
+	require.NoError(t, err)
+	assert.Nil(t, data)
+
+	require.NoError(t, writeKVData(v, "legacy/app2", map[string]interface{}{"password": "two"}, -1))
+	require.NoError(t, writeKVData(v, "secret/data/app2", map[string]interface{}{"password": "two"}, -1))
+	assert.Equal(t, map[string]interface{}{"password": "two"}, written["/v1/legacy/app2"])
+	assert.Equal(t, map[string]interface{}{"data": map[string]interface{}{"password": "two"}}, written["/v1/secret/data/app2"])
+
+	f, err := NewFingerprinter([]byte("0123456789abcdef"))
+	require.NoError(t, err)
+	insight, err := kvV1Insight(v, f, "legacy/app1")
+	require.NoError(t, err)
+	assert.Equal(t, "kvV1", insight.Type)
+	assert.NotEmpty(t, insight.Fingerprint)
+	_, err = kvV1Insight(v, nil, "legacy/app1")
+	assert.Error(t, err, "kv v1 needs a fingerprint key")
+
+	// kv v1 changes are seen only in fingerprints
+	changed := insight
+	changed.Fingerprint = "other"
+	_, update, _, errs := CompareBuckets(Bucket{"legacy/app1": changed}, Bucket{"legacy/app1": insight})
+	assert.Empty(t, errs)
+	assert.Len(t, update, 1)
+	_, update, _, errs = CompareBuckets(Bucket{"legacy/app1": insight}, Bucket{"legacy/app1": insight})
+	assert.Empty(t, errs)
+	assert.Empty(t, update)
+}
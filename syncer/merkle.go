@@ -0,0 +1,316 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	"github.com/ExpediaGroup/vsync/apperr"
+	"github.com/rs/zerolog/log"
+)
+
+// merkle index
+//
+// with fanout F the bucket hashes are leaves of a tree, every F consecutive hashes of a level are hashed together for the level above
+// until a level has at most F hashes, that level is saved as index and every other node is saved in its own key
+// so readers descend only into nodes whose hash changed and comparisons look only at buckets under changed nodes
+//
+// level 0       : bucket hashes
+// level L >= 1  : node k covers hashes k*F .. (k+1)*F-1 of level L-1, saved in key "tree.L.k" as list of those child hashes
+
+const treeKeyPrefix = "tree."
+
+// SetMerkle makes index a merkle tree with fanout children per node, 0 keeps a flat index
+// prefixDepth is the number of path segments after "data/" which make the prefix for prefix bucketing
+// only allowed before any path is saved in info
+func (i *Info) SetMerkle(fanout int, prefixDepth int) error {
+	const op = apperr.Op("syncer.Info.SetMerkle")
+
+	if fanout < 0 || fanout == 1 {
+		return apperr.New(fmt.Sprintf("index fanout %d must be 0 for flat index or at least 2", fanout), ErrInitialize, op, apperr.Fatal)
+	}
+	if prefixDepth < 0 {
+		return apperr.New(fmt.Sprintf("prefix depth %d cannot be negative", prefixDepth), ErrInitialize, op, apperr.Fatal)
+	}
+
+	i.rw.Lock()
+	defer i.rw.Unlock()
+
+	for _, bucket := range i.buckets {
+		if len(bucket) > 0 {
+			return apperr.New(fmt.Sprintf("cannot change index fanout to %d after paths are saved in info", fanout), ErrInitialize, op)
+		}
+	}
+	i.header.Fanout = fanout
+	i.header.PrefixDepth = prefixDepth
+
+	return nil
+}
+
+func treeKey(level int, n int) string {
+	return fmt.Sprintf("%s%d.%d", treeKeyPrefix, level, n)
+}
+
+// parseTreeKey is the reverse of treeKey, false for keys which are not tree nodes
+func parseTreeKey(key string) (int, int, bool) {
+	if !strings.HasPrefix(key, treeKeyPrefix) {
+		return 0, 0, false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(key, treeKeyPrefix), ".", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	level, err := strconv.Atoi(parts[0])
+	if err != nil || level < 1 {
+		return 0, 0, false
+	}
+	n, err := strconv.Atoi(parts[1])
+	if err != nil || n < 0 {
+		return 0, 0, false
+	}
+	return level, n, true
+}
+
+// levelSizes is number of hashes in each level of a tree with numBuckets leaves, last level is saved as index
+func levelSizes(numBuckets int, fanout int) []int {
+	sizes := []int{numBuckets}
+	if fanout < 2 {
+		return sizes
+	}
+	for n := numBuckets; n > fanout; {
+		n = (n + fanout - 1) / fanout
+		sizes = append(sizes, n)
+	}
+	return sizes
+}
+
+// children returns hashes under node n of the level above level
+func children(level []string, n int, fanout int) []string {
+	end := (n + 1) * fanout
+	if end > len(level) {
+		end = len(level)
+	}
+	return level[n*fanout : end]
+}
+
+func hashNode(h hash.Hash, children []string) (string, error) {
+	h.Reset()
+	_, err := h.Write([]byte(strings.Join(children, "")))
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// merkleLevels builds all levels of tree from bucket hashes, a flat index has only level 0
+func merkleLevels(h hash.Hash, leaves []string, fanout int) ([][]string, error) {
+	const op = apperr.Op("syncer.merkleLevels")
+
+	levels := [][]string{leaves}
+	for _, size := range levelSizes(len(leaves), fanout)[1:] {
+		below := levels[len(levels)-1]
+		level := make([]string, 0, size)
+		for n := 0; n < size; n++ {
+			nodeHash, err := hashNode(h, children(below, n, fanout))
+			if err != nil {
+				return nil, apperr.New(fmt.Sprintf("cannot hash node %d of level %d", n, len(levels)), err, op, ErrInvalidIndex)
+			}
+			level = append(level, nodeHash)
+		}
+		levels = append(levels, level)
+	}
+	return levels, nil
+}
+
+// changedLeaves descends both trees from the top and returns ids of buckets under changed nodes which differ
+// both trees must have same number of buckets and fanout
+func changedLeaves(origin [][]string, destination [][]string, fanout int) []int {
+	top := len(origin) - 1
+	changed := []int{}
+	for n := range origin[top] {
+		if origin[top][n] != destination[top][n] {
+			changed = append(changed, n)
+		}
+	}
+
+	for level := top; level > 0; level-- {
+		below := []int{}
+		for _, n := range changed {
+			start := n * fanout
+			for c := range children(origin[level-1], n, fanout) {
+				if origin[level-1][start+c] != destination[level-1][start+c] {
+					below = append(below, start+c)
+				}
+			}
+		}
+		changed = below
+	}
+	return changed
+}
+
+// pathPrefix is first depth segments after "data/" in path like "secret/data/app1/db" with depth 1 is "secret/data/app1"
+// paths without "data/" use their first depth segments, false if path has less than depth segments
+func pathPrefix(path string, depth int) (string, bool) {
+	start := 0
+	if i := strings.Index(path, "/data/"); i >= 0 {
+		start = i + len("/data/")
+	}
+	segments := strings.SplitAfter(strings.TrimSuffix(path[start:], "/"), "/")
+	full := len(segments) >= depth
+	if full {
+		segments = segments[:depth]
+	}
+	return strings.TrimSuffix(path[:start]+strings.Join(segments, ""), "/"), full
+}
+
+// prefixBucketOf places all paths with same prefix under one node of level 1, so one application is one subtree
+// the node is chosen by jump hash of prefix and bucket under the node by jump hash of path
+// caller must hold lock
+func (i *Info) prefixBucketOf(path string) (int, error) {
+	const op = apperr.Op("syncer.Info.prefixBucketOf")
+
+	fanout := i.header.Fanout
+	numBuckets := len(i.index)
+	if fanout < 2 {
+		return 0, apperr.New(fmt.Sprintf("prefix bucketing needs a merkle index fanout"), ErrUnsupportedFormat, op)
+	}
+
+	prefix, _ := pathPrefix(path, i.header.PrefixDepth)
+	group, err := i.prefixGroup(prefix)
+	if err != nil {
+		return 0, apperr.New(fmt.Sprintf("cannot find subtree for path %q", path), err, op, ErrInvalidPath)
+	}
+	size := fanout
+	if numBuckets-group*fanout < fanout {
+		size = numBuckets - group*fanout
+	}
+
+	pathHash, err := hashPath(i.header.HashAlgorithm, path)
+	if err != nil {
+		return 0, apperr.New(fmt.Sprintf("cannot hash path %q", path), err, op, ErrInvalidPath)
+	}
+	return group*fanout + jumpHash(binary.BigEndian.Uint64(pathHash), size), nil
+}
+
+// prefixGroup is the node of level 1 holding all paths with prefix, caller must hold lock
+func (i *Info) prefixGroup(prefix string) (int, error) {
+	fanout := i.header.Fanout
+	groups := (len(i.index) + fanout - 1) / fanout
+
+	prefixHash, err := hashPath(i.header.HashAlgorithm, prefix)
+	if err != nil {
+		return 0, err
+	}
+	return jumpHash(binary.BigEndian.Uint64(prefixHash), groups), nil
+}
+
+// ComparePrefix compares only paths with prefix, like all secrets of one application
+// destination with another layout is rebucketed like origin first, with prefix bucketing only the subtree of prefix is looked at, otherwise all buckets are
+func (origin *Info) ComparePrefix(destination *Info, prefix string) ([]Task, []Task, []Task, []error) {
+	const op = apperr.Op("syncer.ComparePrefix")
+
+	add, update, delete := []Task{}, []Task{}, []Task{}
+	errs := []error{}
+
+	if err := origin.Header().Comparable(destination.Header()); err != nil {
+		errs = append(errs, apperr.New(fmt.Sprintf("non comparable headers origin & destination"), err, op, apperr.Fatal, ErrInitialize))
+		return add, update, delete, errs
+	}
+
+	// paths of destination are in other buckets with another layout, so assign them to buckets like origin
+	originHeader := origin.Header()
+	if !originHeader.SameLayout(destination.Header()) {
+		rebucketed, err := destination.Rebucket(originHeader)
+		if err != nil {
+			errs = append(errs, apperr.New(fmt.Sprintf("cannot rebucket destination like origin"), err, op, apperr.Fatal, ErrInitialize))
+			return add, update, delete, errs
+		}
+		destination = rebucketed
+	}
+
+	originBuckets := origin.numBuckets()
+	ids := make([]int, 0, originBuckets)
+	for id := 0; id < originBuckets; id++ {
+		ids = append(ids, id)
+	}
+
+	// all paths under prefix are in one subtree only if prefix is at least as deep as bucketing prefix
+	prefix = strings.TrimSuffix(prefix, "/")
+	bucketingPrefix, full := pathPrefix(prefix, originHeader.PrefixDepth)
+	if originHeader.Bucketing == BucketingPrefix && full {
+		origin.rw.RLock()
+		group, err := origin.prefixGroup(bucketingPrefix)
+		origin.rw.RUnlock()
+		if err != nil {
+			errs = append(errs, apperr.New(fmt.Sprintf("cannot find subtree of prefix %q", prefix), err, op, ErrInvalidPath))
+			return add, update, delete, errs
+		}
+		start := group * originHeader.Fanout
+		end := start + originHeader.Fanout
+		if end > originBuckets {
+			end = originBuckets
+		}
+		ids = ids[start:end]
+		log.Debug().Str("prefix", prefix).Int("subtree", group).Ints("bucketIds", ids).Msg("comparing only subtree of prefix")
+	}
+
+	options := compareOptions{
+		fingerprints: originHeader.FingerprintKey != "" && originHeader.FingerprintKey == destination.Header().FingerprintKey,
+		tombstones:   originHeader.Tombstones,
+		unknown:      originHeader.Unknown,
+	}
+
+	for _, id := range ids {
+		originBucket, err := origin.GetBucket(id)
+		if err != nil {
+			errs = append(errs, apperr.New(fmt.Sprintf("cannot find origin bucket %q", id), err, op, ErrInvalidBucket))
+			continue
+		}
+		destinationBucket, err := destination.GetBucket(id)
+		if err != nil {
+			errs = append(errs, apperr.New(fmt.Sprintf("cannot find destination bucket %q", id), err, op, ErrInvalidBucket))
+			continue
+		}
+		newAdd, newUpdate, newDelete, newErrs := compareBuckets(filterPrefix(originBucket, prefix), filterPrefix(destinationBucket, prefix), options)
+		add = append(add, newAdd...)
+		update = append(update, newUpdate...)
+		delete = append(delete, newDelete...)
+		errs = append(errs, newErrs...)
+	}
+
+	return add, update, delete, errs
+}
+
+func (i *Info) numBuckets() int {
+	i.rw.RLock()
+	defer i.rw.RUnlock()
+	return len(i.index)
+}
+
+// filterPrefix returns paths of bucket which are prefix itself or under prefix
+func filterPrefix(bucket Bucket, prefix string) Bucket {
+	b := Bucket{}
+	for path, insight := range bucket {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			b[path] = insight
+		}
+	}
+	return b
+}
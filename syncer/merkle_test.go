@@ -0,0 +1,159 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPathPrefix(t *testing.T) {
+	cases := []struct {
+		path   string
+		depth  int
+		prefix string
+		full   bool
+	}{
+		{"secret/data/app1/db", 1, "secret/data/app1", true},
+		{"secret/data/app1/db", 2, "secret/data/app1/db", true},
+		{"secret/data/app1", 2, "secret/data/app1", false},
+		{"team/kv/data/app1/db/password", 2, "team/kv/data/app1/db", true},
+		{"app1/db", 1, "app1", true},
+	}
+	for _, c := range cases {
+		prefix, full := pathPrefix(c.path, c.depth)
+		assert.Equal(t, c.prefix, prefix, c.path)
+		assert.Equal(t, c.full, full, c.path)
+	}
+}
+
+func TestMerkleIndexStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vsync")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := NewFileStore(dir, "vsync/origin/")
+	require.NoError(t, err)
+
+	insight := Insight{Version: 1, UpdateTime: "2019-09-15T00:58:20.680948367Z", Type: "kvV2"}
+	origin, err := NewInfo(100)
+	require.NoError(t, err)
+	require.NoError(t, origin.SetMerkle(4, 1))
+	for i := 0; i < 1000; i++ {
+		_, err = origin.Put(fmt.Sprintf("secret/data/%d", i), insight)
+		require.NoError(t, err)
+	}
+	require.NoError(t, origin.Reindex())
+	publish(t, s, origin)
+
+	// 100 buckets with fanout 4 is 25, 7 and 2 nodes, top level of 2 is the index
+	keys, err := s.Keys()
+	require.NoError(t, err)
+	nodes := 0
+	for _, key := range keys {
+		if strings.HasPrefix(key, treeKeyPrefix) {
+			nodes++
+		}
+	}
+	assert.Equal(t, 25+7+2, nodes)
+
+	cache := NewCache()
+	destination, err := NewInfo(100)
+	require.NoError(t, err)
+	require.NoError(t, IndexFromStore(s, destination, cache))
+	require.NoError(t, BucketsFromStore(s, destination, cache))
+	assert.True(t, origin.InSync(destination))
+
+	// one changed path is read through one node in each level
+	_, err = origin.Put("secret/data/new", insight)
+	require.NoError(t, err)
+	require.NoError(t, origin.Reindex())
+	publish(t, s, origin)
+
+	counter := &countingStore{Store: s, gets: map[string]int{}}
+	changed, err := NewInfo(100)
+	require.NoError(t, err)
+	require.NoError(t, IndexFromStore(counter, changed, cache))
+	reads := 0
+	for key := range counter.gets {
+		if strings.HasPrefix(key, treeKeyPrefix) {
+			reads++
+		}
+	}
+	assert.Equal(t, 3, reads, "only nodes above the changed bucket must be read")
+	require.NoError(t, BucketsFromStore(counter, changed, cache))
+
+	add, update, del, errs := changed.Compare(destination)
+	assert.Empty(t, errs)
+	assert.Len(t, add, 1)
+	assert.Empty(t, update)
+	assert.Empty(t, del)
+}
+
+func TestPrefixBucketing(t *testing.T) {
+	insight := Insight{Version: 1, UpdateTime: "2019-09-15T00:58:20.680948367Z", Type: "kvV2"}
+	origin, err := NewInfo(32)
+	require.NoError(t, err)
+	require.NoError(t, origin.SetMerkle(4, 1))
+	require.NoError(t, origin.SetBucketing(BucketingPrefix))
+	destination, err := origin.Rebucket(origin.Header())
+	require.NoError(t, err)
+
+	subtrees := map[int]bool{}
+	for i := 0; i < 100; i++ {
+		id, err := origin.Put(fmt.Sprintf("secret/data/app1/%d", i), insight)
+		require.NoError(t, err)
+		subtrees[id/4] = true
+		_, err = origin.Put(fmt.Sprintf("secret/data/app2/%d", i), insight)
+		require.NoError(t, err)
+		if i%2 == 0 {
+			_, err = destination.Put(fmt.Sprintf("secret/data/app1/%d", i), insight)
+			require.NoError(t, err)
+		}
+	}
+	assert.Len(t, subtrees, 1, "all paths of a prefix must be under one node")
+	require.NoError(t, origin.Reindex())
+	require.NoError(t, destination.Reindex())
+
+	add, update, del, errs := origin.ComparePrefix(destination, "secret/data/app1")
+	assert.Empty(t, errs)
+	assert.Len(t, add, 50)
+	assert.Empty(t, update)
+	assert.Empty(t, del)
+
+	add, _, _, errs = origin.Compare(destination)
+	assert.Empty(t, errs)
+	assert.Len(t, add, 150)
+
+	// destination with another layout is rebucketed, paths are not matched by bucket id
+	other, err := NewInfo(8)
+	require.NoError(t, err)
+	for i := 0; i < 100; i += 2 {
+		_, err = other.Put(fmt.Sprintf("secret/data/app1/%d", i), insight)
+		require.NoError(t, err)
+	}
+	require.NoError(t, other.Reindex())
+	add, update, del, errs = origin.ComparePrefix(other, "secret/data/app1")
+	assert.Empty(t, errs)
+	assert.Len(t, add, 50)
+	assert.Empty(t, update)
+	assert.Empty(t, del)
+}
@@ -0,0 +1,114 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/ExpediaGroup/vsync/apperr"
+	"github.com/ExpediaGroup/vsync/vault"
+	"github.com/hashicorp/vault/api"
+)
+
+// SecretMetadata makes origin record a hash of kv v2 metadata settings in insights, set once before origin sync starts
+var SecretMetadata = false
+
+// metadataKeys are per secret settings of kv v2 metadata which are replicated, rest of metadata belongs to vault
+var metadataKeys = []string{"max_versions", "cas_required", "delete_version_after", "custom_metadata"}
+
+// SetSecretMetadata makes origin record a hash of secret metadata settings in insights, then destinations replicate them
+func (i *Info) SetSecretMetadata(enabled bool) {
+	i.rw.Lock()
+	defer i.rw.Unlock()
+
+	i.header.SecretMetadata = enabled
+}
+
+func (i *Info) secretMetadata() bool {
+	i.rw.RLock()
+	defer i.rw.RUnlock()
+
+	return i.header.SecretMetadata
+}
+
+// secretMetadata is the replicated settings from kv v2 metadata of a path
+func secretMetadata(secret *api.Secret) (map[string]interface{}, error) {
+	const op = apperr.Op("syncer.secretMetadata")
+
+	if secret == nil || secret.Data == nil {
+		return nil, apperr.New(fmt.Sprintf("no secret to gather metadata"), ErrInvalidMeta, op)
+	}
+
+	m := map[string]interface{}{}
+	for _, key := range metadataKeys {
+		if value, ok := secret.Data[key]; ok && value != nil {
+			m[key] = value
+		}
+	}
+	return m, nil
+}
+
+// metadataHash is sha256 of canonical json of metadata settings, saved in insight so a metadata only change is updated
+func metadataHash(m map[string]interface{}) (string, error) {
+	content, err := canonicalJSON(m)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// copyMetadata writes metadata settings of origin kv v2 data path to destination data path
+func copyMetadata(originVault *vault.Client, destinationVault *vault.Client, originPath string, destinationPath string) error {
+	const op = apperr.Op("syncer.copyMetadata")
+
+	originMetaPath := strings.Replace(originPath, "/data/", "/metadata/", 1)
+	secret, err := originVault.Logical().Read(originMetaPath)
+	if err != nil {
+		return apperr.New(fmt.Sprintf("cannot read metadata for path %q", originMetaPath), err, op, ErrInvalidPath)
+	}
+	m, err := secretMetadata(secret)
+	if err != nil {
+		return apperr.New(fmt.Sprintf("cannot gather metadata for path %q", originMetaPath), err, op, ErrInvalidMeta)
+	}
+
+	// custom metadata is replaced as a whole, so keys of vsync in destination are carried over
+	if custom, ok := m["custom_metadata"].(map[string]interface{}); ok {
+		destinationMeta, _, err := readKVV2Meta(destinationVault, destinationPath)
+		if err != nil {
+			return err
+		}
+		merged := map[string]interface{}{}
+		for key, value := range custom {
+			merged[key] = value
+		}
+		for key, value := range destinationMeta.Custom {
+			if strings.HasPrefix(key, "vsync-") {
+				merged[key] = value
+			}
+		}
+		m["custom_metadata"] = merged
+	}
+
+	destinationMetaPath := strings.Replace(destinationPath, "/data/", "/metadata/", 1)
+	_, err = destinationVault.Logical().Write(destinationMetaPath, m)
+	if err != nil {
+		return apperr.New(fmt.Sprintf("cannot write metadata for path %q", destinationMetaPath), err, op, ErrInvalidPath)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecretMetadata(t *testing.T) {
+	secret := &api.Secret{Data: map[string]interface{}{
+		"cas_required":         false,
+		"created_time":         "2019-09-15T00:58:20.680948367Z",
+		"current_version":      json.Number("3"),
+		"custom_metadata":      map[string]interface{}{"owner": "team-a", "rotate": "2020-01-01"},
+		"delete_version_after": "0s",
+		"max_versions":         json.Number("10"),
+		"updated_time":         "2019-09-15T01:10:28.275769286Z",
+		"versions":             map[string]interface{}{},
+	}}
+
+	m, err := secretMetadata(secret)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"cas_required":         false,
+		"custom_metadata":      map[string]interface{}{"owner": "team-a", "rotate": "2020-01-01"},
+		"delete_version_after": "0s",
+		"max_versions":         json.Number("10"),
+	}, m, "only replicated settings, versions and times belong to vault")
+
+	a, err := metadataHash(m)
+	require.NoError(t, err)
+	secret.Data["updated_time"] = "2019-10-01T00:00:00Z"
+	m, err = secretMetadata(secret)
+	require.NoError(t, err)
+	b, err := metadataHash(m)
+	require.NoError(t, err)
+	assert.Equal(t, a, b)
+
+	secret.Data["custom_metadata"] = map[string]interface{}{"owner": "team-b"}
+	m, err = secretMetadata(secret)
+	require.NoError(t, err)
+	c, err := metadataHash(m)
+	require.NoError(t, err)
+	assert.NotEqual(t, a, c)
+}
+
+func TestCompareMetadata(t *testing.T) {
+	insight := Insight{Version: 3, UpdateTime: "2019-09-15T00:58:20.680948367Z", Type: "kvV2", Metadata: "aa"}
+	changed := insight
+	changed.Metadata = "bb"
+	changed.UpdateTime = "2019-09-16T00:00:00Z"
+
+	_, update, _, errs := CompareBuckets(Bucket{"secret/data/app1": changed}, Bucket{"secret/data/app1": insight})
+	assert.Empty(t, errs)
+	require.Len(t, update, 1)
+	assert.Equal(t, "metadata", update[0].Op, "metadata only change must not write data again")
+
+	newer := changed
+	newer.Version = 4
+	_, update, _, _ = CompareBuckets(Bucket{"secret/data/app1": newer}, Bucket{"secret/data/app1": insight})
+	require.Len(t, update, 1)
+	assert.Equal(t, "update", update[0].Op)
+
+	h := Header{NumBuckets: 1, SecretMetadata: true}
+	assert.Equal(t, 8, h.minFormatVersion())
+}
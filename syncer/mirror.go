@@ -0,0 +1,139 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ExpediaGroup/vsync/apperr"
+	"github.com/ExpediaGroup/vsync/transformer"
+	"github.com/ExpediaGroup/vsync/vault"
+	"github.com/rs/zerolog/log"
+)
+
+// actions of strict mirror mounts for destination secrets without origin counterpart
+const (
+	MirrorReport = "report"
+	MirrorDelete = "delete"
+)
+
+// StrictMirrors maps destination mounts in strict mirror mode to their action, set once before destination sync starts
+var StrictMirrors = map[string]string{}
+
+// CheckMirrorAction returns error for unknown actions
+func CheckMirrorAction(action string) error {
+	const op = apperr.Op("syncer.CheckMirrorAction")
+
+	switch action {
+	case MirrorReport, MirrorDelete:
+		return nil
+	default:
+		return apperr.New(fmt.Sprintf("unknown strict mirror action %q, options: %s | %s", action, MirrorReport, MirrorDelete), ErrInitialize, op)
+	}
+}
+
+// Counterparts maps destination paths of all origin paths in info to origin paths, tombstones included as destination still has their versions
+// transformers cannot be reversed, so every origin path is transformed instead
+func (i *Info) Counterparts(v *vault.Client, pack transformer.Pack) map[string]string {
+	i.rw.RLock()
+	defer i.rw.RUnlock()
+
+	counterparts := map[string]string{}
+	for _, bucket := range i.buckets {
+		for path, insight := range bucket {
+			newPath, ok := pack.Transform(path)
+			if !ok {
+				continue
+			}
+			counterparts[kvPath(v, newPath, insight.Type)] = path
+		}
+	}
+	return counterparts
+}
+
+// DataPaths converts destination paths listed by a walk to paths of secret data, kv v2 paths are listed under metadata/ of mount
+func DataPaths(v *vault.Client, listed []string) []string {
+	paths := make([]string, 0, len(listed))
+	for _, path := range listed {
+		mount, version := v.KVMount(path)
+		if version == 2 {
+			path = mount + "data/" + strings.TrimPrefix(path, mount+"metadata/")
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// Unmanaged returns listed destination paths without origin counterpart, copies of conflicts and quarantined secrets made by vsync are never unmanaged
+func Unmanaged(v *vault.Client, listed []string, counterparts map[string]string) []string {
+	unmanaged := []string{}
+	for _, path := range DataPaths(v, listed) {
+		mount, version := v.KVMount(path)
+		if version == 2 && strings.HasPrefix(path, mount+"data/"+ConflictPrefix) {
+			continue
+		}
+		if QuarantineMount != "" && strings.HasPrefix(path, quarantinePath("")) {
+			continue
+		}
+		if _, ok := counterparts[path]; !ok {
+			unmanaged = append(unmanaged, path)
+		}
+	}
+	return unmanaged
+}
+
+// PruneKind is the kind of delete pruning an unmanaged destination path does, as far as delete policy of its mount allows
+// a mount allowing only soft deletes keeps the history, empty when policy blocks deletes or an already soft deleted path has nothing to delete
+func PruneKind(v *vault.Client, path string) (string, error) {
+	kind, ok := deletePolicyOf(path).mirror(DeletedMetadata)
+	if !ok {
+		log.Warn().Str("path", path).Msg("destination secret has no counterpart in origin, delete policy of mount blocks pruning it")
+		return "", nil
+	}
+	if kind == DeletedSoft && v.KVVersion(path) == 2 {
+		meta, exists, err := readKVV2Meta(v, path)
+		if err != nil {
+			return "", err
+		}
+		if !exists || meta.CurrentDeletionTime != "" || meta.Destroyed {
+			log.Debug().Str("path", path).Msg("current version is already deleted, not pruning")
+			return "", nil
+		}
+	}
+	return kind, nil
+}
+
+// Prune deletes an unmanaged destination path with kind of delete of PruneKind
+// with ownership on, only paths with provenance of vsync are deleted, with quarantine on it is archived first
+// returns kind of delete performed, empty when nothing was deleted
+func Prune(v *vault.Client, path string) (string, error) {
+	const op = apperr.Op("syncer.Prune")
+
+	kind, err := PruneKind(v, path)
+	if err != nil || kind == "" {
+		return "", err
+	}
+	if err := checkOwner(v, path); err != nil {
+		return "", err
+	}
+	if err := quarantine(v, path, "", "unmanaged secret pruned by strict mirror"); err != nil {
+		return "", err
+	}
+	if _, err := mirrorDelete(v, path, kind); err != nil {
+		return "", apperr.New(fmt.Sprintf("cannot prune path %q", path), err, op, ErrInvalidPath)
+	}
+	return kind, nil
+}
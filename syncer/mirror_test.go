@@ -0,0 +1,85 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"testing"
+
+	"github.com/ExpediaGroup/vsync/transformer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnmanaged(t *testing.T) {
+	v := kvVault(t, map[string]map[string]interface{}{})
+
+	rename, err := transformer.NewNamedRegexpTransformer("rename", `(?P<mount>secret)/(?P<meta>data)/team/(?P<app>.*)`, "mount/meta/apps/app")
+	require.NoError(t, err)
+	pack := transformer.Pack{rename, transformer.NewNilTransformer()}
+
+	info, err := NewInfo(4)
+	require.NoError(t, err)
+	_, err = info.Put("secret/data/team/app1", Insight{Type: "kvV2", Version: 1})
+	require.NoError(t, err)
+	_, err = info.Put("secret/data/gone", Insight{Type: "kvV2", Version: 2, Deleted: DeletedSoft})
+	require.NoError(t, err)
+	_, err = info.Put("legacy/app2", Insight{Type: "kvV1", Fingerprint: "f"})
+	require.NoError(t, err)
+
+	counterparts := info.Counterparts(v, pack)
+	assert.Equal(t, map[string]string{"secret/data/apps/app1": "secret/data/team/app1", "secret/data/gone": "secret/data/gone", "legacy/app2": "legacy/app2"}, counterparts)
+
+	unmanaged := Unmanaged(v, []string{
+		"secret/metadata/apps/app1",
+		"secret/metadata/gone",
+		"secret/metadata/handmade",
+		"secret/metadata/vsync-conflicts/apps/app1",
+		"legacy/app2",
+		"legacy/handmade",
+	}, counterparts)
+	assert.Equal(t, []string{"secret/data/handmade", "legacy/handmade"}, unmanaged)
+
+	assert.Error(t, CheckMirrorAction("archive"))
+}
+
+func TestPrunePolicy(t *testing.T) {
+	defer func() { DeletePolicies = map[string]DeletePolicy{} }()
+	v, secrets := memVault(t)
+	for _, path := range []string{"secret/data/a", "archive/data/b", "archive/data/c"} {
+		require.NoError(t, writeKVData(v, path, map[string]interface{}{"k": "v"}, 0))
+	}
+
+	// mounts without a policy only soft delete, history stays
+	kind, err := Prune(v, "secret/data/a")
+	require.NoError(t, err)
+	assert.Equal(t, DeletedSoft, kind)
+	require.NotNil(t, secrets["secret/a"])
+	assert.True(t, secrets["secret/a"].deleted[1])
+	kind, err = Prune(v, "secret/data/a")
+	require.NoError(t, err)
+	assert.Empty(t, kind, "soft deleted path is not pruned again")
+
+	DeletePolicies = map[string]DeletePolicy{"archive/": {DeletedMetadata: true}}
+	kind, err = Prune(v, "archive/data/b")
+	require.NoError(t, err)
+	assert.Equal(t, DeletedMetadata, kind)
+	assert.Nil(t, secrets["archive/b"])
+
+	DeletePolicies = map[string]DeletePolicy{"archive/": {Undelete: true}}
+	kind, err = Prune(v, "archive/data/c")
+	require.NoError(t, err)
+	assert.Empty(t, kind, "policy without deletes blocks pruning")
+	assert.False(t, secrets["archive/c"].deleted[1])
+}
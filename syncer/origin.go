@@ -17,7 +17,9 @@ package syncer
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -32,11 +34,20 @@ type KVV2Meta struct {
 	UpdatedTime         string
 	CurrentDeletionTime string
 	Destroyed           bool
+	// Versions still kept by vault, older versions are removed after max_versions
+	Versions map[int64]KVV2Version
+	// Custom is custom metadata of path, vsync keeps its own keys here in destination
+	Custom map[string]string
+}
+
+type KVV2Version struct {
+	DeletionTime string
+	Destroyed    bool
 }
 
 func GenerateInsight(ctx context.Context,
 	wg *sync.WaitGroup, workerId int,
-	v *vault.Client, i *Info,
+	v *vault.Client, i *Info, f *Fingerprinter,
 	inPathCh chan string, errCh chan error) {
 	const op = apperr.Op("syncer.GenerateInsight")
 
@@ -54,9 +65,33 @@ func GenerateInsight(ctx context.Context,
 			}
 			log.Debug().Str("path", path).Int("workerId", workerId).Msg("path received for generating sync info")
 
+			if v.KVVersion(path) == 1 {
+				insight, err := kvV1Insight(v, f, path)
+				if errors.Is(err, ErrVersionGone) {
+					log.Debug().Str("path", path).Int("workerId", workerId).Msg("kv v1 path deleted while generating sync info")
+					continue
+				}
+				if err != nil {
+					log.Debug().Err(err).Str("path", path).Int("workerId", workerId).Msg("cannot get insight of kv v1 path")
+					i.MarkUnknown(path)
+					errCh <- apperr.New(fmt.Sprintf("cannot get insight of kv v1 path %q", path), err, op, ErrInvalidPath)
+					continue
+				}
+				id, err := i.Put(path, insight)
+				if err != nil {
+					log.Debug().Err(err).Str("path", path).Int("workerId", workerId).Msg("cannot save insight in info")
+					i.MarkUnknown(path)
+					errCh <- apperr.New(fmt.Sprintf("cannot save insight for path %q", path), err, op, ErrInvalidMeta)
+					continue
+				}
+				log.Debug().Str("path", path).Int("workerId", workerId).Int("bucketId", id).Msg("saved kv v1 path in info under bucket")
+				continue
+			}
+
 			secret, err := v.Logical().Read(path)
 			if err != nil {
 				log.Debug().Err(err).Str("path", path).Int("workerId", workerId).Msg("cannot read metadata for path")
+				i.MarkUnknown(strings.Replace(path, "/metadata", "/data", 1))
 				errCh <- apperr.New(fmt.Sprintf("cannot read metadata for path %q", path), err, op, ErrInvalidPath)
 				continue
 			}
@@ -64,11 +99,13 @@ func GenerateInsight(ctx context.Context,
 			meta, err := getKVV2Meta(secret)
 			if err != nil {
 				log.Debug().Err(err).Str("path", path).Int("workerId", workerId).Msg("cannot get insight of metadata for path")
+				i.MarkUnknown(strings.Replace(path, "/metadata", "/data", 1))
 				errCh <- apperr.New(fmt.Sprintf("cannot gather meta info for path %q", path), err, op, ErrInvalidMeta)
 				continue
 			}
 
-			if meta.CurrentDeletionTime != "" || meta.Destroyed {
+			deleted := meta.CurrentDeletionTime != "" || meta.Destroyed
+			if deleted && !i.tombstones() {
 				// this print will bloat the log because end users will not delete the metadata and we keep track of it that it was deleted
 				log.Debug().Str("path", path).Int("workerId", workerId).Str("deletionTime", meta.CurrentDeletionTime).Msg("current version of path was deleted")
 				continue
@@ -76,13 +113,65 @@ func GenerateInsight(ctx context.Context,
 
 			path = strings.Replace(path, "/metadata", "/data", 1)
 
-			id, err := i.Put(path, Insight{
+			if deleted {
+				tombstone := Insight{
+					Type:         "kvV2",
+					Version:      meta.CurrentVersion,
+					UpdateTime:   meta.UpdatedTime,
+					Deleted:      DeletedSoft,
+					DeletionTime: meta.CurrentDeletionTime,
+				}
+				if meta.Destroyed {
+					tombstone.Deleted = DeletedDestroy
+				}
+				if tombstone.DeletionTime == "" {
+					tombstone.DeletionTime = meta.UpdatedTime
+				}
+				id, err := i.Put(path, tombstone)
+				if err != nil {
+					log.Debug().Err(err).Str("path", path).Int("workerId", workerId).Msg("cannot save tombstone in info")
+					i.MarkUnknown(path)
+					errCh <- apperr.New(fmt.Sprintf("cannot save tombstone for path %q", path), err, op, ErrInvalidMeta)
+					continue
+				}
+				log.Debug().Str("path", path).Int("workerId", workerId).Int("bucketId", id).Str("deleted", tombstone.Deleted).Msg("saved tombstone in info under bucket")
+				continue
+			}
+
+			insight := Insight{
 				Type:       "kvV2",
 				Version:    meta.CurrentVersion,
 				UpdateTime: meta.UpdatedTime,
-			})
+			}
+
+			if i.secretMetadata() {
+				m, err := secretMetadata(secret)
+				if err == nil {
+					insight.Metadata, err = metadataHash(m)
+				}
+				if err != nil {
+					log.Debug().Err(err).Str("path", path).Int("workerId", workerId).Msg("cannot hash metadata of path")
+					i.MarkUnknown(path)
+					errCh <- apperr.New(fmt.Sprintf("cannot hash metadata of path %q", path), err, op, ErrInvalidMeta)
+					continue
+				}
+			}
+
+			// fingerprint of the version in insight, so it matches what destination fetches for this version
+			if f != nil {
+				insight.Fingerprint, err = fingerprintVersion(v, f, path, meta.CurrentVersion)
+				if err != nil {
+					log.Debug().Err(err).Str("path", path).Int("workerId", workerId).Msg("cannot fingerprint path")
+					i.MarkUnknown(path)
+					errCh <- apperr.New(fmt.Sprintf("cannot fingerprint path %q", path), err, op, ErrFingerprint)
+					continue
+				}
+			}
+
+			id, err := i.Put(path, insight)
 			if err != nil {
 				log.Debug().Err(err).Str("path", path).Int("workerId", workerId).Msg("cannot save insight in info")
+				i.MarkUnknown(path)
 				errCh <- apperr.New(fmt.Sprintf("cannot save insight for path %q", path), err, op, ErrInvalidMeta)
 			}
 			log.Debug().Str("path", path).Int("workerId", workerId).Int("bucketId", id).Msg("saved path in info under bucket")
@@ -90,6 +179,36 @@ func GenerateInsight(ctx context.Context,
 	}
 }
 
+// fingerprintVersion reads data of version of a kv v2 path and returns its fingerprint
+func fingerprintVersion(v *vault.Client, f *Fingerprinter, path string, version int64) (string, error) {
+	const op = apperr.Op("syncer.fingerprintVersion")
+
+	secret, err := readVersion(v, path, version)
+	if err != nil {
+		return "", apperr.New(fmt.Sprintf("cannot read version %d of path %q", version, path), err, op)
+	}
+	data, err := secretData(secret)
+	if err != nil {
+		return "", apperr.New(fmt.Sprintf("cannot get data of version %d of path %q", version, path), err, op, ErrFingerprint)
+	}
+	return f.Fingerprint(data)
+}
+
+// readVersion reads exactly version of a kv v2 data path
+// a version deleted or destroyed meanwhile is ErrVersionGone, a newer insight comes in next cycle
+func readVersion(v *vault.Client, path string, version int64) (*api.Secret, error) {
+	const op = apperr.Op("syncer.readVersion")
+
+	secret, err := v.Logical().ReadWithData(path, map[string][]string{"version": {strconv.FormatInt(version, 10)}})
+	if err != nil {
+		return nil, apperr.New(fmt.Sprintf("cannot read version %d of path %q", version, path), err, op, ErrInvalidPath)
+	}
+	if secret == nil || secret.Data == nil || secret.Data["data"] == nil {
+		return nil, apperr.New(fmt.Sprintf("version %d of path %q is deleted or destroyed", version, path), ErrVersionGone, op)
+	}
+	return secret, nil
+}
+
 // getMetaInsight will get insights given a secret from vault.
 // It will try to recover from panic because we must not stop the sync for 1 bad secret
 // use named return values so that we can recover from panic and convert to error
@@ -153,5 +272,32 @@ func getKVV2Meta(secret *api.Secret) (meta KVV2Meta, err error) {
 		meta.Destroyed = false
 	}
 
+	meta.Versions = make(map[int64]KVV2Version, len(versions))
+	for k, value := range versions {
+		n, err := strconv.ParseInt(k, 10, 64)
+		if err != nil {
+			return meta, apperr.New(fmt.Sprintf("cannot parse version %q", k), err, op, ErrInvalidMeta)
+		}
+		details, ok := value.(map[string]interface{})
+		if !ok {
+			return meta, apperr.New(fmt.Sprintf("cannot type cast version %q to %q", k, "map[string]interface{}"), ErrInvalidMeta, op)
+		}
+		version := KVV2Version{}
+		if details["deletion_time"] != nil {
+			version.DeletionTime = fmt.Sprintf("%s", details["deletion_time"])
+		}
+		version.Destroyed, _ = details["destroyed"].(bool)
+		meta.Versions[n] = version
+	}
+
+	custom, _ := secret.Data["custom_metadata"].(map[string]interface{})
+	meta.Custom = make(map[string]string, len(custom))
+	for k, value := range custom {
+		if value == nil {
+			continue
+		}
+		meta.Custom[k] = fmt.Sprintf("%v", value)
+	}
+
 	return meta, nil
 }
@@ -0,0 +1,43 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadVersion(t *testing.T) {
+	v, f := newFakeVault(t, map[string]int{"secret/": 2})
+	f.secret("secret/app1", 1, map[string]interface{}{"password": "one"}, nil)
+	f.secret("secret/app1", 2, map[string]interface{}{"password": "two"}, nil)
+	// deleted version still has metadata but no data
+	f.secrets["secret/app1"].deleted[2] = true
+
+	secret, err := readVersion(v, "secret/data/app1", 1)
+	require.NoError(t, err)
+	data, err := secretData(secret)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"password": "one"}, data)
+
+	_, err = readVersion(v, "secret/data/app1", 2)
+	assert.True(t, errors.Is(err, ErrVersionGone), "deleted version")
+
+	_, err = readVersion(v, "secret/data/app1", 3)
+	assert.True(t, errors.Is(err, ErrVersionGone), "destroyed or unknown version")
+}
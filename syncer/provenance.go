@@ -0,0 +1,107 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ExpediaGroup/vsync/apperr"
+	"github.com/ExpediaGroup/vsync/vault"
+)
+
+// keys in custom metadata of destination secrets telling where vsync got them from
+const (
+	OriginKey        = "vsync-origin"
+	OriginPathKey    = "vsync-origin-path"
+	OriginVersionKey = "vsync-origin-version"
+	SyncTimeKey      = "vsync-sync-time"
+)
+
+// Ownership makes destinations refuse to write or delete kv v2 secrets without provenance of vsync
+var Ownership = false
+
+// Provenance of a destination secret written by vsync
+type Provenance struct {
+	Origin     string
+	OriginPath string
+	// OriginVersion is 0 for kv v1 origin paths
+	OriginVersion int64
+	// Version is destination version written by vsync
+	Version  int64
+	SyncTime time.Time
+}
+
+func (p Provenance) custom() map[string]string {
+	c := map[string]string{
+		OriginKey:     p.Origin,
+		OriginPathKey: p.OriginPath,
+		VersionKey:    strconv.FormatInt(p.Version, 10),
+		SyncTimeKey:   p.SyncTime.UTC().Format(time.RFC3339),
+	}
+	if p.OriginVersion > 0 {
+		c[OriginVersionKey] = strconv.FormatInt(p.OriginVersion, 10)
+	}
+	return c
+}
+
+// owned is true for secrets with provenance of vsync
+func owned(meta KVV2Meta) bool {
+	return meta.Custom[OriginKey] != "" || meta.Custom[VersionKey] != ""
+}
+
+// checkOwner returns ErrSecretConflict with ownership on, for kv v2 destination paths existing without provenance of vsync
+// kv v1 has no metadata for provenance, so its paths are not checked
+func checkOwner(v *vault.Client, path string) error {
+	const op = apperr.Op("syncer.checkOwner")
+
+	if !Ownership || v.KVVersion(path) == 1 {
+		return nil
+	}
+	meta, ok, err := readKVV2Meta(v, path)
+	if err != nil {
+		return err
+	}
+	if ok && !owned(meta) {
+		return apperr.New(fmt.Sprintf("path %q exists without provenance of vsync", path), ErrSecretConflict, op)
+	}
+	return nil
+}
+
+// stampProvenance saves provenance in custom metadata of kv v2 destination path, other custom metadata is kept
+func stampProvenance(v *vault.Client, path string, p Provenance) error {
+	const op = apperr.Op("syncer.stampProvenance")
+
+	meta, _, err := readKVV2Meta(v, path)
+	if err != nil {
+		return err
+	}
+	custom := map[string]interface{}{}
+	for key, value := range meta.Custom {
+		custom[key] = value
+	}
+	for key, value := range p.custom() {
+		custom[key] = value
+	}
+
+	metaPath := strings.Replace(path, "/data/", "/metadata/", 1)
+	_, err = v.Logical().Write(metaPath, map[string]interface{}{"custom_metadata": custom})
+	if err != nil {
+		return apperr.New(fmt.Sprintf("cannot save provenance in metadata for path %q", metaPath), err, op, ErrInvalidPath)
+	}
+	return nil
+}
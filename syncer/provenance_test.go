@@ -0,0 +1,65 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStampProvenance(t *testing.T) {
+	written := map[string]map[string]interface{}{}
+	v := conflictVault(t, written)
+
+	require.NoError(t, stampProvenance(v, "secret/data/edited", Provenance{
+		Origin:        "dc1",
+		OriginPath:    "secret/data/app/edited",
+		OriginVersion: 9,
+		Version:       6,
+		SyncTime:      time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC),
+	}))
+	assert.Equal(t, map[string]interface{}{
+		"custom_metadata": map[string]interface{}{
+			"team":                 "a",
+			"vsync-origin":         "dc1",
+			"vsync-origin-path":    "secret/data/app/edited",
+			"vsync-origin-version": "9",
+			"vsync-version":        "6",
+			"vsync-sync-time":      "2019-10-01T00:00:00Z",
+		},
+	}, written["/v1/secret/metadata/edited"])
+}
+
+func TestOwnership(t *testing.T) {
+	defer func() { Ownership = false }()
+	v := conflictVault(t, map[string]map[string]interface{}{})
+
+	assert.NoError(t, checkOwner(v, "secret/data/legacy"), "ownership is off")
+
+	Ownership = true
+	assert.NoError(t, checkOwner(v, "secret/data/synced"))
+	assert.NoError(t, checkOwner(v, "secret/data/new"), "new path has no owner yet")
+	assert.True(t, errors.Is(checkOwner(v, "secret/data/legacy"), ErrSecretConflict))
+
+	_, err := guardWrite(v, "secret/data/legacy")
+	assert.True(t, errors.Is(err, ErrSecretConflict))
+	cas, err := guardWrite(v, "secret/data/synced")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), cas)
+}
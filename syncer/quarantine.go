@@ -0,0 +1,243 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ExpediaGroup/vsync/apperr"
+	"github.com/ExpediaGroup/vsync/vault"
+	"github.com/rs/zerolog/log"
+)
+
+var ErrNotQuarantined = fmt.Errorf("no such quarantined secret")
+
+// QuarantineMount is kv v2 mount in destination vault where secrets are archived before vsync deletes them, empty turns quarantine off
+// set once before destination sync starts, like QuarantinePrefix
+var QuarantineMount = ""
+
+// QuarantinePrefix is added after quarantine mount to every archived secret
+var QuarantinePrefix = "vsync-quarantine/"
+
+// quarantineTimeFormat starts ids of archived secrets, so listing sorts them by time
+const quarantineTimeFormat = "20060102T150405.000000000Z"
+
+// QuarantineItem is a destination secret archived before a delete
+// id is quarantine time and destination path, so the same path can be archived many times
+type QuarantineItem struct {
+	ID         string
+	Path       string
+	OriginPath string
+	Reason     string
+	Time       time.Time
+	Version    int64
+	Data       map[string]interface{}
+	Metadata   map[string]interface{}
+}
+
+// quarantinePath is kv v2 data path of archived secret with id
+func quarantinePath(id string) string {
+	return QuarantineMount + "data/" + QuarantinePrefix + id
+}
+
+// quarantine archives newest readable data and metadata settings of destination path with the reason of delete
+// a soft deleted current version cannot be read, then the newest older version still readable is archived
+// nothing is archived when quarantine is off or path has no readable version left
+func quarantine(v *vault.Client, path string, originPath string, reason string) error {
+	const op = apperr.Op("syncer.quarantine")
+
+	if QuarantineMount == "" {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	entry := map[string]interface{}{
+		"path":           path,
+		"originPath":     originPath,
+		"reason":         reason,
+		"quarantineTime": now.Format(time.RFC3339Nano),
+	}
+	if v.KVVersion(path) == 2 {
+		metaPath := strings.Replace(path, "/data/", "/metadata/", 1)
+		secret, err := v.Logical().Read(metaPath)
+		if err != nil {
+			return apperr.New(fmt.Sprintf("cannot read metadata for path %q", metaPath), err, op, ErrInvalidPath)
+		}
+		if secret == nil {
+			return nil
+		}
+		m, err := secretMetadata(secret)
+		if err != nil {
+			return apperr.New(fmt.Sprintf("cannot gather metadata for path %q", metaPath), err, op, ErrInvalidMeta)
+		}
+		meta, err := getKVV2Meta(secret)
+		if err != nil {
+			return apperr.New(fmt.Sprintf("cannot gather meta info for path %q", metaPath), err, op, ErrInvalidMeta)
+		}
+
+		// a soft deleted current version cannot be read, a destroy or metadata delete would still remove older versions
+		version := newestReadable(meta, now)
+		if version == 0 {
+			log.Warn().Str("path", path).Int64("currentVersion", meta.CurrentVersion).Str("reason", reason).Msg("no readable version of destination secret, nothing quarantined before deleting")
+			return nil
+		}
+		data, err := readVersion(v, path, version)
+		if err != nil {
+			return err
+		}
+		entry["data"], err = secretData(data)
+		if err != nil {
+			return apperr.New(fmt.Sprintf("cannot get data of version %d of path %q", version, path), err, op, ErrInvalidPath)
+		}
+		if version != meta.CurrentVersion {
+			log.Info().Str("path", path).Int64("version", version).Int64("currentVersion", meta.CurrentVersion).Msg("current version of destination secret is deleted, quarantining newest readable version")
+		}
+		entry["metadata"] = m
+		entry["version"] = version
+	} else {
+		data, err := readKVData(v, path)
+		if err != nil || data == nil {
+			return err
+		}
+		entry["data"] = data
+	}
+
+	id := now.Format(quarantineTimeFormat) + "/" + path
+	if err := writeKVData(v, quarantinePath(id), entry, 0); err != nil {
+		return apperr.New(fmt.Sprintf("cannot quarantine path %q", path), err, op, ErrInvalidPath)
+	}
+	log.Info().Str("path", path).Str("id", id).Str("reason", reason).Msg("quarantined destination secret before deleting")
+	return nil
+}
+
+// newestReadable is the newest version of kv v2 metadata which can still be read, 0 when there is none
+func newestReadable(meta KVV2Meta, now time.Time) int64 {
+	newest := int64(0)
+	for n, version := range meta.Versions {
+		if n > newest && version.readable(now) {
+			newest = n
+		}
+	}
+	return newest
+}
+
+// Quarantined lists archived secrets in quarantine without their data
+func Quarantined(v *vault.Client) ([]QuarantineItem, []error) {
+	metaPath := QuarantineMount + "metadata/" + strings.TrimSuffix(QuarantinePrefix, "/")
+	listed, _, errs := v.GetAllPaths([]string{metaPath})
+
+	items := []QuarantineItem{}
+	for _, path := range listed {
+		item, err := ReadQuarantined(v, strings.TrimPrefix(path, metaPath+"/"))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		item.Data = nil
+		items = append(items, item)
+	}
+	return items, errs
+}
+
+// ReadQuarantined reads archived secret with id, ErrNotQuarantined when there is none
+func ReadQuarantined(v *vault.Client, id string) (QuarantineItem, error) {
+	const op = apperr.Op("syncer.ReadQuarantined")
+
+	entry, err := readKVData(v, quarantinePath(id))
+	if err != nil {
+		return QuarantineItem{}, err
+	}
+	if entry == nil {
+		return QuarantineItem{}, apperr.New(fmt.Sprintf("cannot find quarantined secret %q", id), ErrNotQuarantined, op)
+	}
+
+	item := QuarantineItem{
+		ID:         id,
+		Path:       fmt.Sprint(entry["path"]),
+		OriginPath: fmt.Sprint(entry["originPath"]),
+		Reason:     fmt.Sprint(entry["reason"]),
+	}
+	item.Time, _ = time.Parse(time.RFC3339Nano, fmt.Sprint(entry["quarantineTime"]))
+	if entry["version"] != nil {
+		item.Version, _ = strconv.ParseInt(fmt.Sprint(entry["version"]), 10, 64)
+	}
+	item.Data, _ = entry["data"].(map[string]interface{})
+	item.Metadata, _ = entry["metadata"].(map[string]interface{})
+	if item.Path == "" || item.Data == nil {
+		return QuarantineItem{}, apperr.New(fmt.Sprintf("quarantined secret %q has no path or data", id), ErrInvalidInsight, op)
+	}
+	return item, nil
+}
+
+// RestoreQuarantined writes archived secret with id back to its destination path and removes it from quarantine
+// a path with live data is not replaced unless force is true
+// restored kv v2 secret keeps its provenance with version of vsync moved to the restored version, so next write of vsync is no conflict
+func RestoreQuarantined(v *vault.Client, id string, force bool) (QuarantineItem, error) {
+	const op = apperr.Op("syncer.RestoreQuarantined")
+
+	item, err := ReadQuarantined(v, id)
+	if err != nil {
+		return item, err
+	}
+
+	live, err := readKVData(v, item.Path)
+	if err != nil {
+		return item, err
+	}
+	if live != nil && !force {
+		return item, apperr.New(fmt.Sprintf("path %q has data, restore with force to replace it", item.Path), ErrSecretConflict, op)
+	}
+
+	cas := int64(-1)
+	if v.KVVersion(item.Path) == 2 {
+		meta, _, err := readKVV2Meta(v, item.Path)
+		if err != nil {
+			return item, err
+		}
+		cas = meta.CurrentVersion
+	}
+	if err := writeKVData(v, item.Path, item.Data, cas); err != nil {
+		return item, err
+	}
+
+	// metadata after data, so that cas_required does not block writing data
+	if cas >= 0 && item.Metadata != nil {
+		if custom, ok := item.Metadata["custom_metadata"].(map[string]interface{}); ok {
+			if _, ok := custom[VersionKey]; ok {
+				custom[VersionKey] = strconv.FormatInt(cas+1, 10)
+			}
+		}
+		metaPath := strings.Replace(item.Path, "/data/", "/metadata/", 1)
+		if _, err := v.Logical().Write(metaPath, item.Metadata); err != nil {
+			return item, apperr.New(fmt.Sprintf("cannot write metadata for path %q", metaPath), err, op, ErrInvalidPath)
+		}
+	}
+
+	return item, PurgeQuarantined(v, id)
+}
+
+// PurgeQuarantined removes archived secret with id and all its versions from quarantine
+func PurgeQuarantined(v *vault.Client, id string) error {
+	const op = apperr.Op("syncer.PurgeQuarantined")
+
+	metaPath := QuarantineMount + "metadata/" + QuarantinePrefix + id
+	if _, err := v.Logical().Delete(metaPath); err != nil {
+		return apperr.New(fmt.Sprintf("cannot purge quarantined secret %q", id), err, op, ErrInvalidPath)
+	}
+	return nil
+}
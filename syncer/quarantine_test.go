@@ -0,0 +1,84 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuarantine(t *testing.T) {
+	defer func() { QuarantineMount = "" }()
+	v, secrets := memVault(t)
+
+	require.NoError(t, writeKVData(v, "secret/data/app/db", map[string]interface{}{"password": "one"}, 0))
+	require.NoError(t, stampProvenance(v, "secret/data/app/db", Provenance{Origin: "origin", OriginPath: "secret/data/app/db", OriginVersion: 1, Version: 1}))
+
+	// quarantine off keeps nothing
+	require.NoError(t, quarantine(v, "secret/data/app/db", "secret/data/app/db", "test"))
+	assert.Len(t, secrets, 1)
+
+	QuarantineMount = "archive/"
+	require.NoError(t, quarantine(v, "secret/data/app/db", "secret/data/app/db", "origin delete mirrored as delete"))
+	require.NoError(t, quarantine(v, "secret/data/gone", "secret/data/gone", "nothing to keep"))
+	_, err := mirrorDelete(v, "secret/data/app/db", DeletedMetadata)
+	require.NoError(t, err)
+
+	items, errs := Quarantined(v)
+	assert.Empty(t, errs)
+	require.Len(t, items, 1)
+	item := items[0]
+	assert.Equal(t, "secret/data/app/db", item.Path)
+	assert.Equal(t, "origin delete mirrored as delete", item.Reason)
+	assert.Equal(t, int64(1), item.Version)
+	assert.Nil(t, item.Data, "list does not show data")
+	assert.True(t, strings.HasSuffix(item.ID, "/secret/data/app/db"))
+
+	// restore does not replace live data without force
+	require.NoError(t, writeKVData(v, "secret/data/app/db", map[string]interface{}{"password": "new"}, 0))
+	_, err = RestoreQuarantined(v, item.ID, false)
+	assert.True(t, errors.Is(err, ErrSecretConflict))
+
+	_, err = RestoreQuarantined(v, item.ID, true)
+	require.NoError(t, err)
+	data, err := readKVData(v, "secret/data/app/db")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"password": "one"}, data)
+	meta, _, err := readKVV2Meta(v, "secret/data/app/db")
+	require.NoError(t, err)
+	assert.Equal(t, "2", meta.Custom[VersionKey], "vsync version follows restored version")
+	assert.Equal(t, "origin", meta.Custom[OriginKey])
+
+	_, err = ReadQuarantined(v, item.ID)
+	assert.True(t, errors.Is(err, ErrNotQuarantined), "restored secret leaves quarantine")
+
+	// soft deleted current version, a destroy would still remove version 1
+	require.NoError(t, writeKVData(v, "secret/data/app/api", map[string]interface{}{"key": "one"}, 0))
+	require.NoError(t, writeKVData(v, "secret/data/app/api", map[string]interface{}{"key": "two"}, 1))
+	_, err = mirrorDelete(v, "secret/data/app/api", DeletedSoft)
+	require.NoError(t, err)
+	require.NoError(t, quarantine(v, "secret/data/app/api", "secret/data/app/api", "origin destroy mirrored as destroy"))
+	items, errs = Quarantined(v)
+	assert.Empty(t, errs)
+	require.Len(t, items, 1)
+	assert.Equal(t, int64(1), items[0].Version, "newest readable version is archived")
+	item, err = ReadQuarantined(v, items[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"key": "one"}, item.Data)
+}
@@ -0,0 +1,170 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ExpediaGroup/vsync/apperr"
+	"github.com/ExpediaGroup/vsync/transformer"
+	"github.com/ExpediaGroup/vsync/vault"
+	"github.com/rs/zerolog/log"
+)
+
+// RebuildInsight takes insights of origin paths into destination info when destination vault already has the same data
+// paths left out are added or updated by next destination cycle, so a lost destination sync info does not rewrite every secret
+// f is used only when it has the key of origin fingerprints
+func RebuildInsight(ctx context.Context,
+	wg *sync.WaitGroup, workerId int,
+	originVault *vault.Client, destinationVault *vault.Client,
+	originfo *Info, info *Info, pack transformer.Pack, f *Fingerprinter,
+	inPathCh chan string, errCh chan error) {
+	const op = apperr.Op("syncer.RebuildInsight")
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Debug().Str("trigger", "context done").Int("workerId", workerId).Msg("closed rebuild insight worker")
+			wg.Done()
+			return
+		case path, ok := <-inPathCh:
+			if !ok {
+				log.Debug().Str("trigger", "nil channel").Int("workerId", workerId).Msg("closed rebuild insight worker")
+				wg.Done()
+				return
+			}
+
+			insight, ok, err := originfo.Get(path)
+			if err != nil || !ok {
+				continue
+			}
+
+			newPath, ok := pack.Transform(path)
+			if !ok {
+				log.Debug().Str("path", path).Int("workerId", workerId).Msg("cannot transform path for rebuilding sync info")
+				errCh <- apperr.New(fmt.Sprintf("worker %q cannot transform path %q for rebuilding sync info", workerId, path), ErrTransform, op)
+				continue
+			}
+			newPath = kvPath(destinationVault, newPath, insight.Type)
+
+			same, err := sameAsOrigin(originVault, destinationVault, f, path, newPath, insight)
+			if err != nil {
+				log.Debug().Err(err).Str("path", newPath).Int("workerId", workerId).Msg("cannot compare destination secret with origin")
+				errCh <- apperr.New(fmt.Sprintf("worker %q cannot compare path %q with origin", workerId, newPath), err, op, ErrInvalidPath)
+				continue
+			}
+			if !same {
+				log.Debug().Str("path", newPath).Int("workerId", workerId).Msg("destination secret differs from origin, left for next cycle")
+				continue
+			}
+
+			// metadata settings differing from origin are left for a metadata task of next cycle
+			if insight.Metadata != "" && destinationVault.KVVersion(newPath) == 2 {
+				hash, err := destinationMetadataHash(destinationVault, newPath)
+				if err != nil || hash != insight.Metadata {
+					insight.Metadata = ""
+				}
+			}
+
+			if _, err := info.Put(path, insight); err != nil {
+				errCh <- apperr.New(fmt.Sprintf("worker %q cannot save rebuilt insight of path %q", workerId, path), err, op, ErrInvalidBucket)
+			}
+		}
+	}
+}
+
+// sameAsOrigin is true when destination path has data of origin insight
+// provenance of vsync for the same origin version and no change since is enough, otherwise fingerprints or data are compared
+func sameAsOrigin(originVault *vault.Client, destinationVault *vault.Client, f *Fingerprinter, path string, newPath string, insight Insight) (bool, error) {
+	// tombstone holds when destination has nothing live at path
+	if insight.Deleted != "" {
+		data, err := readKVData(destinationVault, newPath)
+		return data == nil, err
+	}
+
+	if insight.Type == "kvV2" && destinationVault.KVVersion(newPath) == 2 {
+		meta, ok, err := readKVV2Meta(destinationVault, newPath)
+		if err != nil {
+			return false, err
+		}
+		if ok && meta.Custom[OriginPathKey] == path &&
+			meta.Custom[OriginVersionKey] == strconv.FormatInt(insight.Version, 10) &&
+			meta.Custom[VersionKey] == strconv.FormatInt(meta.CurrentVersion, 10) &&
+			meta.CurrentDeletionTime == "" && !meta.Destroyed {
+			return true, nil
+		}
+	}
+
+	data, err := readKVData(destinationVault, newPath)
+	if err != nil || data == nil {
+		return false, err
+	}
+
+	if f != nil && insight.Fingerprint != "" {
+		fingerprint, err := f.Fingerprint(data)
+		if err != nil {
+			return false, err
+		}
+		return fingerprint == insight.Fingerprint, nil
+	}
+
+	originData, err := readInsightData(originVault, path, insight)
+	if errors.Is(err, ErrVersionGone) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	a, err := canonicalJSON(data)
+	if err != nil {
+		return false, err
+	}
+	b, err := canonicalJSON(originData)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(a, b), nil
+}
+
+// destinationMetadataHash is metadata hash of kv v2 destination path without custom metadata keys of vsync
+func destinationMetadataHash(v *vault.Client, path string) (string, error) {
+	const op = apperr.Op("syncer.destinationMetadataHash")
+
+	metaPath := strings.Replace(path, "/data/", "/metadata/", 1)
+	secret, err := v.Logical().Read(metaPath)
+	if err != nil {
+		return "", apperr.New(fmt.Sprintf("cannot read metadata for path %q", metaPath), err, op, ErrInvalidPath)
+	}
+	m, err := secretMetadata(secret)
+	if err != nil {
+		return "", err
+	}
+	if custom, ok := m["custom_metadata"].(map[string]interface{}); ok {
+		origin := map[string]interface{}{}
+		for key, value := range custom {
+			if !strings.HasPrefix(key, "vsync-") {
+				origin[key] = value
+			}
+		}
+		m["custom_metadata"] = origin
+	}
+	return metadataHash(m)
+}
@@ -0,0 +1,56 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSameAsOrigin(t *testing.T) {
+	v := kvVault(t, map[string]map[string]interface{}{})
+
+	same, err := sameAsOrigin(v, v, nil, "secret/data/app1", "secret/data/app1", Insight{Type: "kvV2", Version: 3})
+	require.NoError(t, err)
+	assert.True(t, same, "data compared with origin version")
+
+	same, err = sameAsOrigin(v, v, nil, "secret/data/app1", "legacy/app1", Insight{Type: "kvV2", Version: 3})
+	require.NoError(t, err)
+	assert.True(t, same, "kv v2 to kv v1")
+
+	f, err := NewFingerprinter([]byte("0123456789abcdef"))
+	require.NoError(t, err)
+	fingerprint, err := f.Fingerprint(map[string]interface{}{"password": "one"})
+	require.NoError(t, err)
+	same, err = sameAsOrigin(v, v, f, "legacy/app1", "legacy/app1", Insight{Type: "kvV1", Fingerprint: fingerprint})
+	require.NoError(t, err)
+	assert.True(t, same, "same fingerprint")
+	same, err = sameAsOrigin(v, v, f, "legacy/app1", "legacy/app1", Insight{Type: "kvV1", Fingerprint: "other"})
+	require.NoError(t, err)
+	assert.False(t, same, "different fingerprint")
+
+	same, err = sameAsOrigin(v, v, nil, "secret/data/app1", "secret/data/missing", Insight{Type: "kvV2", Version: 3})
+	require.NoError(t, err)
+	assert.False(t, same, "missing in destination")
+
+	same, err = sameAsOrigin(v, v, nil, "secret/data/gone", "secret/data/gone", Insight{Type: "kvV2", Version: 2, Deleted: DeletedSoft})
+	require.NoError(t, err)
+	assert.True(t, same, "tombstone of a path missing in destination")
+	same, err = sameAsOrigin(v, v, nil, "secret/data/app1", "secret/data/app1", Insight{Type: "kvV2", Version: 3, Deleted: DeletedSoft})
+	require.NoError(t, err)
+	assert.False(t, same, "tombstone of a live destination path")
+}
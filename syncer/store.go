@@ -0,0 +1,451 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ExpediaGroup/vsync/apperr"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrInvalidStore = fmt.Errorf("invalid sync info store")
+	ErrConflict     = fmt.Errorf("sync info changed by another writer")
+	ErrInconsistent = fmt.Errorf("sync info buckets do not match index, may be read while being published")
+)
+
+const (
+	StoreConsul = "consul"
+	StoreFile   = "file"
+	StoreVault  = "vault"
+)
+
+const indexKey = "index"
+
+// KV is a key and its value in store
+type KV struct {
+	Key   string
+	Value []byte
+}
+
+// Revision is the version of a key in store, consul modify index, vault version or content of a file
+// zero value is a key which is not present
+type Revision struct {
+	present bool
+	index   uint64
+	value   []byte
+}
+
+// Store is where sync info (index and buckets) is kept so that origin can publish and destinations can consume it
+// keys are relative to the sync path the store was created with, like "index" or "0" for bucket 0
+type Store interface {
+	// Get returns the value of key, nil value without error if key is not present
+	Get(key string) ([]byte, error)
+	Put(key string, value []byte) error
+	// Delete removes key, no error if key is not present
+	Delete(key string) error
+	// Keys lists keys directly under sync path
+	Keys() ([]string, error)
+	// Revision returns the current revision of key, zero revision without error if key is not present
+	Revision(key string) (Revision, error)
+	// Publish saves kvs in order and then deletes keys, as atomically as the store allows
+	// it fails with ErrConflict if guard key is not at since revision anymore, another writer changed it after the caller read since
+	Publish(guard string, since Revision, kvs []KV, deletes []string) error
+	// Watch blocks till context is done, calling handler every time the value of key changes
+	Watch(ctx context.Context, key string, handler func()) error
+	// Checks makes sure we have permissions to create, read, list, delete in sync path
+	Checks() error
+	IsInitialized() (bool, error)
+	String() string
+}
+
+func bucketKey(id int) string {
+	return fmt.Sprintf("%d", id)
+}
+
+// WatchIndex calls handler every time sync index changes in store, index is the last key saved for sync info
+func WatchIndex(ctx context.Context, s Store, handler func()) error {
+	return s.Watch(ctx, indexKey, handler)
+}
+
+// IndexRevision is the revision of sync index, a writer reads it when its cycle starts and publishes with it as guard
+// reads of sync info later in the cycle do not move the guard
+func IndexRevision(s Store) (Revision, error) {
+	return s.Revision(indexKey)
+}
+
+// InfoToStore publishes buckets, header and index together guarded by the index at since revision
+// index is saved last because it triggers a cycle in destination
+func InfoToStore(s Store, i *Info, since Revision) error {
+	const op = apperr.Op("syncer.InfoToStore")
+
+	index, err := i.GetIndex()
+	if err != nil {
+		log.Debug().Err(err).Msg("cannot find index")
+		return apperr.New(fmt.Sprintf("cannot find index for saving"), err, op, ErrInvalidIndex)
+	}
+
+	kvs := make([]KV, 0, len(index)+2)
+	nodes := [][]string{index}
+	header := i.Header()
+	i.rw.RLock()
+	maxBucketSize := i.maxBucketSize
+	i.rw.RUnlock()
+
+	// buckets, compressed and split if too big for one key
+	shards := make([]int, len(index))
+	sharded := false
+	for id := range index {
+		bucket, err := i.GetBucket(id)
+		if err != nil {
+			log.Debug().Err(err).Int("bucketId", id).Msg("cannot get bucket")
+			return apperr.New(fmt.Sprintf("cannot find bucket %q for saving", id), err, op, ErrInvalidBucket)
+		}
+		value, err := json.Marshal(bucket)
+		if err != nil {
+			log.Debug().Err(err).Int("bucketId", id).Msg("cannot marshal bucket")
+			return apperr.New(fmt.Sprintf("cannot marshal bucket %q for saving", id), err, op, ErrInvalidBucket)
+		}
+		value, err = compress(header.Compression, value)
+		if err != nil {
+			log.Debug().Err(err).Int("bucketId", id).Str("compression", header.Compression).Msg("cannot compress bucket")
+			return apperr.New(fmt.Sprintf("cannot compress bucket %q for saving", id), err, op, ErrInvalidBucket)
+		}
+		parts := split(value, maxBucketSize)
+		for shard, part := range parts {
+			kvs = append(kvs, KV{Key: shardKey(id, shard), Value: part})
+		}
+		shards[id] = len(parts)
+		if len(parts) > 1 {
+			sharded = true
+			log.Debug().Int("bucketId", id).Int("size", len(value)).Int("shards", len(parts)).Msg("bucket split into shards")
+		}
+	}
+
+	// header
+	if sharded {
+		header.Shards = shards
+	}
+	header.FormatVersion = header.minFormatVersion()
+	value, err := json.Marshal(header)
+	if err != nil {
+		log.Debug().Err(err).Msg("cannot marshal header")
+		return apperr.New(fmt.Sprintf("cannot marshal header for saving"), err, op, ErrInvalidHeader)
+	}
+	kvs = append(kvs, KV{Key: headerKey, Value: value})
+
+	// merkle index nodes, index itself is the top level
+	top := index
+	if header.Fanout > 0 {
+		hasher, err := NewHasher(header.HashAlgorithm)
+		if err != nil {
+			return apperr.New(fmt.Sprintf("cannot get hasher for merkle index"), err, op, ErrInvalidIndex)
+		}
+		levels, err := merkleLevels(hasher, index, header.Fanout)
+		if err != nil {
+			return apperr.New(fmt.Sprintf("cannot build merkle index for saving"), err, op, ErrInvalidIndex)
+		}
+		for level := 1; level < len(levels); level++ {
+			for n := range levels[level] {
+				value, err := json.Marshal(children(levels[level-1], n, header.Fanout))
+				if err != nil {
+					return apperr.New(fmt.Sprintf("cannot marshal node %q for saving", treeKey(level, n)), err, op, ErrInvalidIndex)
+				}
+				kvs = append(kvs, KV{Key: treeKey(level, n), Value: value})
+			}
+		}
+		top = levels[len(levels)-1]
+		nodes = levels
+	}
+
+	// index
+	value, err = json.Marshal(top)
+	if err != nil {
+		log.Debug().Err(err).Msg("cannot marshal index")
+		return apperr.New(fmt.Sprintf("cannot marshal index for saving"), err, op, ErrInvalidIndex)
+	}
+	kvs = append(kvs, KV{Key: indexKey, Value: value})
+
+	// stale buckets when number of buckets is reduced and stale shards when buckets shrink
+	keys, err := s.Keys()
+	if err != nil {
+		log.Debug().Err(err).Str("store", s.String()).Msg("cannot list keys in store")
+		return apperr.New(fmt.Sprintf("cannot list keys in store %q for finding stale buckets", s), err, op, ErrInvalidStore)
+	}
+	deletes := []string{}
+	for _, key := range keys {
+		id, shard, ok := parseShardKey(key)
+		if ok && (id >= len(index) || shard >= shards[id]) {
+			deletes = append(deletes, key)
+		}
+		level, n, ok := parseTreeKey(key)
+		if ok && (level >= len(nodes) || n >= len(nodes[level])) {
+			deletes = append(deletes, key)
+		}
+	}
+
+	err = s.Publish(indexKey, since, kvs, deletes)
+	if err != nil {
+		log.Debug().Err(err).Str("store", s.String()).Msg("cannot publish sync info to store")
+		return apperr.New(fmt.Sprintf("cannot publish sync info in store %q", s), err, op, ErrInvalidInfo)
+	}
+	log.Debug().Interface("header", header).Int("staleBuckets", len(deletes)).Str("store", s.String()).Msg("published sync info in store")
+
+	return nil
+}
+
+// InfoFromStore reads header, index and all buckets of sync info
+func InfoFromStore(s Store, i *Info) error {
+	const op = apperr.Op("syncer.InfoFromStore")
+
+	err := IndexFromStore(s, i, nil)
+	if err != nil {
+		return apperr.New(fmt.Sprintf("cannot get sync info from store %q", s), err, op)
+	}
+	err = BucketsFromStore(s, i, nil)
+	if err != nil {
+		return apperr.New(fmt.Sprintf("cannot get sync info from store %q", s), err, op)
+	}
+	return nil
+}
+
+// IndexFromStore reads only header and index, buckets are read later by BucketsFromStore
+// useful to find out if anything changed before reading all buckets
+// merkle index nodes found in cache by their hash are not read from store, cache can be nil
+// nodes which do not match their hash leave bucket hashes under them empty, with ErrInconsistent returned after reading
+func IndexFromStore(s Store, i *Info, c *Cache) (err error) {
+	const op = apperr.Op("syncer.IndexFromStore")
+
+	defer func() {
+		if r := recover(); r != nil {
+			log.Debug().Msg("panic while getting sync info")
+			var ok bool
+			err, ok = r.(error)
+			if !ok {
+				err = apperr.New(fmt.Sprintf("panic while getting sync info (%v)", r), ErrInvalidInfo, op)
+			}
+			err = apperr.New(fmt.Sprintf("panic while getting sync info (%v)", r), err, op, ErrInvalidInfo)
+		}
+	}()
+
+	// header
+	value, err := s.Get(headerKey)
+	if err != nil {
+		log.Debug().Err(err).Str("store", s.String()).Msg("failure on retrieving header from store")
+		return apperr.New(fmt.Sprintf("cannot get header from store %q", s), err, op, ErrInvalidInfo)
+	}
+	if value == nil {
+		// published by older vsync, keep rest of header from new info
+		log.Debug().Str("store", s.String()).Msg("no header in store, assuming sync info format version 0")
+		i.header.FormatVersion = 0
+		i.header.Bucketing = BucketingModulo
+		i.header.HashAlgorithm = HashSHA256
+		i.header.Serialization = ""
+	} else {
+		header := Header{}
+		err = json.Unmarshal(value, &header)
+		if err != nil {
+			log.Debug().Err(err).Str("store", s.String()).Msg("cannot unmarshall header from store")
+			return apperr.New(fmt.Sprintf("cannot unmarshal header from store %q", s), err, op, ErrInvalidHeader)
+		}
+		if header.Bucketing == "" {
+			header.Bucketing = BucketingModulo
+		}
+		i.header = header
+	}
+
+	// index
+	value, err = s.Get(indexKey)
+	if err != nil {
+		log.Debug().Err(err).Str("store", s.String()).Msg("failure on retrieving index from store")
+		return apperr.New(fmt.Sprintf("cannot get index from store %q", s), err, op, ErrInvalidInfo)
+	}
+	if value == nil {
+		log.Debug().Str("store", s.String()).Msg("no response for retrieving index from store")
+		return apperr.New(fmt.Sprintf("cannot get index from store %q", s), ErrInvalidInfo, op)
+	}
+
+	err = json.Unmarshal(value, &i.index)
+	if err != nil {
+		log.Debug().Err(err).Str("store", s.String()).Msg("cannot unmarshall index from store")
+		return apperr.New(fmt.Sprintf("cannot unmarshal index from store %q", s), err, op, ErrInvalidIndex)
+	}
+
+	// merkle index has only top level in index
+	inconsistent := []string{}
+	if i.header.Fanout > 0 && i.header.FormatVersion > 0 {
+		i.index, inconsistent, err = treeFromStore(s, i.header, i.index, c)
+		if err != nil {
+			return apperr.New(fmt.Sprintf("cannot get merkle index from store %q", s), err, op, ErrInvalidIndex)
+		}
+	}
+
+	err = i.header.Validate(len(i.index))
+	if err != nil {
+		log.Debug().Err(err).Interface("header", i.header).Int("lenIndex", len(i.index)).Str("store", s.String()).Msg("invalid header in store")
+		return apperr.New(fmt.Sprintf("invalid header in store %q", s), err, op, ErrInvalidHeader)
+	}
+
+	// buckets are not read yet
+	i.buckets = map[int]Bucket{}
+
+	if len(inconsistent) > 0 {
+		log.Debug().Strs("nodes", inconsistent).Str("store", s.String()).Msg("merkle index in store is inconsistent")
+		return apperr.New(fmt.Sprintf("nodes %v do not match merkle index in store %q", inconsistent, s), ErrInconsistent, op)
+	}
+
+	return nil
+}
+
+// treeFromStore descends merkle index from top level to bucket hashes
+// it returns keys of nodes which do not match their hash, bucket hashes under them are left empty
+func treeFromStore(s Store, h Header, top []string, c *Cache) ([]string, []string, error) {
+	const op = apperr.Op("syncer.treeFromStore")
+
+	sizes := levelSizes(h.NumBuckets, h.Fanout)
+	if len(top) != sizes[len(sizes)-1] {
+		return nil, nil, apperr.New(fmt.Sprintf("index has %d hashes but %d buckets with fanout %d need %d", len(top), h.NumBuckets, h.Fanout, sizes[len(sizes)-1]), ErrCorrupted, op)
+	}
+	hasher, err := NewHasher(h.HashAlgorithm)
+	if err != nil {
+		return nil, nil, apperr.New(fmt.Sprintf("cannot get hasher for merkle index"), err, op, ErrInvalidIndex)
+	}
+
+	fetched := 0
+	inconsistent := []string{}
+	current := top
+	for level := len(sizes) - 1; level >= 1; level-- {
+		below := make([]string, 0, sizes[level-1])
+		for n, nodeHash := range current {
+			expected := h.Fanout
+			if sizes[level-1]-n*h.Fanout < expected {
+				expected = sizes[level-1] - n*h.Fanout
+			}
+
+			kids, ok := c.getNode(nodeHash)
+			if !ok {
+				value, err := s.Get(treeKey(level, n))
+				if err != nil {
+					log.Debug().Err(err).Str("node", treeKey(level, n)).Str("store", s.String()).Msg("failure on retrieving merkle index node from store")
+					return nil, nil, apperr.New(fmt.Sprintf("cannot get node %q from store %q", treeKey(level, n), s), err, op, ErrInvalidInfo)
+				}
+				fetched++
+				kids = nil
+				if value != nil {
+					if err := json.Unmarshal(value, &kids); err != nil {
+						log.Debug().Err(err).Str("node", treeKey(level, n)).Str("store", s.String()).Msg("cannot unmarshal merkle index node from store")
+						kids = nil
+					}
+				}
+				kidsHash, err := hashNode(hasher, kids)
+				if err != nil {
+					return nil, nil, apperr.New(fmt.Sprintf("cannot hash node %q", treeKey(level, n)), err, op, ErrInvalidIndex)
+				}
+				if kidsHash != nodeHash || len(kids) != expected {
+					inconsistent = append(inconsistent, treeKey(level, n))
+					kids = make([]string, expected)
+				} else {
+					c.putNode(nodeHash, kids)
+				}
+			}
+			below = append(below, kids...)
+		}
+		current = below
+	}
+	log.Debug().Int("levels", len(sizes)).Int("fetched", fetched).Str("store", s.String()).Msg("retrieved merkle index from store")
+
+	return current, inconsistent, nil
+}
+
+// BucketsFromStore reads buckets for the index read by IndexFromStore
+// buckets found in cache by their hash in index are not read from store, cache can be nil
+// all buckets are read even if some do not match index, so that caller can reindex them
+func BucketsFromStore(s Store, i *Info, c *Cache) (err error) {
+	const op = apperr.Op("syncer.BucketsFromStore")
+
+	defer func() {
+		if r := recover(); r != nil {
+			log.Debug().Msg("panic while getting sync info buckets")
+			var ok bool
+			err, ok = r.(error)
+			if !ok {
+				err = apperr.New(fmt.Sprintf("panic while getting sync info buckets (%v)", r), ErrInvalidInfo, op)
+			}
+			err = apperr.New(fmt.Sprintf("panic while getting sync info buckets (%v)", r), err, op, ErrInvalidInfo)
+		}
+	}()
+
+	// number of buckets is decided by index and not by new info
+	i.buckets = map[int]Bucket{}
+	fetched := 0
+	inconsistent := []int{}
+	for id := range i.index {
+		if bucket, ok := c.get(i.index[id]); ok {
+			i.buckets[id] = bucket
+			continue
+		}
+
+		value := []byte{}
+		for shard := 0; shard < i.header.shards(id); shard++ {
+			part, err := s.Get(shardKey(id, shard))
+			if err != nil {
+				log.Debug().Err(err).Int("bucketId", id).Int("shard", shard).Str("store", s.String()).Msg("failure on retrieving bucket from store")
+				return apperr.New(fmt.Sprintf("cannot get bucket %q from store %q", shardKey(id, shard), s), err, op, ErrInvalidInfo)
+			}
+			if part == nil {
+				log.Debug().Int("bucketId", id).Int("shard", shard).Str("store", s.String()).Msg("no response for retrieving bucket from store")
+				return apperr.New(fmt.Sprintf("cannot get bucket %q from store %q", shardKey(id, shard), s), ErrInvalidInfo, op)
+			}
+			value = append(value, part...)
+		}
+		value, err = decompress(i.header.Compression, value)
+		if err != nil {
+			log.Debug().Err(err).Int("bucketId", id).Str("compression", i.header.Compression).Str("store", s.String()).Msg("cannot decompress bucket from store")
+			return apperr.New(fmt.Sprintf("cannot decompress bucket %q from store %q", id, s), err, op, ErrInvalidInfo)
+		}
+
+		bucket := Bucket{}
+		err = json.Unmarshal(value, &bucket)
+		if err != nil {
+			log.Debug().Err(err).Int("bucketId", id).Str("store", s.String()).Msg("cannot unmarshall bucket from store")
+			return apperr.New(fmt.Sprintf("cannot unmarshal bucket %q from store %q", id, s), err, op, ErrInvalidInfo)
+		}
+		i.buckets[id] = bucket
+		fetched++
+
+		// a writer publishing in many steps can be caught in between
+		contentHash, err := hashBucket(i.header.HashAlgorithm, i.header.Serialization, bucket)
+		if err != nil {
+			return apperr.New(fmt.Sprintf("cannot hash bucket %q from store %q", id, s), err, op, ErrInvalidBucket)
+		}
+		if contentHash != i.index[id] {
+			log.Debug().Int("bucketId", id).Str("indexHash", i.index[id]).Str("contentHash", contentHash).Msg("bucket does not match index")
+			inconsistent = append(inconsistent, id)
+			continue
+		}
+		c.put(contentHash, bucket)
+	}
+	log.Debug().Int("buckets", len(i.index)).Int("fetched", fetched).Str("store", s.String()).Msg("retrieved buckets from store")
+
+	if len(inconsistent) > 0 {
+		log.Debug().Ints("bucketIds", inconsistent).Str("store", s.String()).Msg("sync info in store is inconsistent")
+		return apperr.New(fmt.Sprintf("buckets %v do not match index in store %q", inconsistent, s), ErrInconsistent, op)
+	}
+
+	return nil
+}
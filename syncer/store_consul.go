@@ -0,0 +1,317 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ExpediaGroup/vsync/apperr"
+	"github.com/ExpediaGroup/vsync/consul"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/api/watch"
+	"github.com/rs/zerolog/log"
+)
+
+// consul rejects transactions with more operations or bigger payload than these, values are counted base64 encoded
+var (
+	ConsulTxnMaxOps   = 64
+	ConsulTxnMaxBytes = 500 * 1024
+)
+
+// ConsulStore keeps sync info in consul kv under sync path
+type ConsulStore struct {
+	client   *consul.Client
+	syncPath string
+}
+
+func NewConsulStore(c *consul.Client, syncPath string) (*ConsulStore, error) {
+	const op = apperr.Op("syncer.NewConsulStore")
+	if c == nil {
+		return nil, apperr.New(fmt.Sprintf("cannot create consul store for sync path %q without consul client", syncPath), ErrInvalidStore, op, apperr.Fatal)
+	}
+
+	return &ConsulStore{
+		client:   c,
+		syncPath: syncPath,
+	}, nil
+}
+
+func (s *ConsulStore) Get(key string) ([]byte, error) {
+	const op = apperr.Op("syncer.ConsulStore.Get")
+
+	path := s.syncPath + key
+	res, _, err := s.client.KV().Get(path, nil)
+	if err != nil {
+		log.Debug().Err(err).Str("path", path).Msg("failure on retrieving key from consul")
+		return nil, apperr.New(fmt.Sprintf("cannot get consul kv path %q", path), err, op, ErrInvalidStore)
+	}
+	if res == nil {
+		return nil, nil
+	}
+
+	return res.Value, nil
+}
+
+// Revision is the modify index of key
+func (s *ConsulStore) Revision(key string) (Revision, error) {
+	const op = apperr.Op("syncer.ConsulStore.Revision")
+
+	path := s.syncPath + key
+	res, _, err := s.client.KV().Get(path, nil)
+	if err != nil {
+		log.Debug().Err(err).Str("path", path).Msg("failure on retrieving modify index of key from consul")
+		return Revision{}, apperr.New(fmt.Sprintf("cannot get consul kv path %q", path), err, op, ErrInvalidStore)
+	}
+	if res == nil {
+		return Revision{}, nil
+	}
+
+	return Revision{present: true, index: res.ModifyIndex}, nil
+}
+
+func (s *ConsulStore) Put(key string, value []byte) error {
+	const op = apperr.Op("syncer.ConsulStore.Put")
+
+	path := s.syncPath + key
+	res, err := s.client.KV().Put(&api.KVPair{
+		Key:   path,
+		Value: value,
+	}, nil)
+	if err != nil {
+		log.Debug().Err(err).Str("path", path).Msg("cannot save key to consul")
+		return apperr.New(fmt.Sprintf("cannot save consul kv path %q", path), err, op, ErrInvalidStore)
+	}
+	log.Debug().Str("timeTaken", fmt.Sprint(res.RequestTime)).Str("path", path).Msg("saved key in consul")
+
+	return nil
+}
+
+func (s *ConsulStore) Delete(key string) error {
+	const op = apperr.Op("syncer.ConsulStore.Delete")
+
+	path := s.syncPath + key
+	_, err := s.client.KV().Delete(path, nil)
+	if err != nil {
+		log.Debug().Err(err).Str("path", path).Msg("cannot delete key from consul")
+		return apperr.New(fmt.Sprintf("cannot delete consul kv path %q", path), err, op, ErrInvalidStore)
+	}
+
+	return nil
+}
+
+// Keys lists keys directly under sync path, sub folders are skipped
+func (s *ConsulStore) Keys() ([]string, error) {
+	const op = apperr.Op("syncer.ConsulStore.Keys")
+
+	paths, _, err := s.client.KV().Keys(s.syncPath, "/", nil)
+	if err != nil {
+		log.Debug().Err(err).Str("path", s.syncPath).Msg("cannot list keys from consul")
+		return nil, apperr.New(fmt.Sprintf("cannot list consul kv path %q", s.syncPath), err, op, ErrInvalidStore)
+	}
+
+	keys := []string{}
+	for _, path := range paths {
+		key := strings.TrimPrefix(path, s.syncPath)
+		if key == "" || strings.HasSuffix(key, "/") {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Publish uses consul kv transactions, every transaction is guarded by since modify index of guard key
+// kvs which do not fit in one transaction are written in earlier transactions and guard kv is written with the last one,
+// so readers following guard key see a complete sync info
+func (s *ConsulStore) Publish(guard string, since Revision, kvs []KV, deletes []string) error {
+	const op = apperr.Op("syncer.ConsulStore.Publish")
+
+	// modify index 0 is a guard key which must not exist
+	guardIndex := since.index
+
+	var guardKV *KV
+	sets := []*api.KVTxnOp{}
+	for i := range kvs {
+		if kvs[i].Key == guard {
+			guardKV = &kvs[i]
+			continue
+		}
+		sets = append(sets, &api.KVTxnOp{Verb: api.KVSet, Key: s.syncPath + kvs[i].Key, Value: kvs[i].Value})
+	}
+	if guardKV == nil {
+		return apperr.New(fmt.Sprintf("guard key %q is not published", guard), ErrInvalidStore, op)
+	}
+	cas := &api.KVTxnOp{Verb: api.KVCAS, Key: s.syncPath + guard, Value: guardKV.Value, Index: guardIndex}
+
+	// fill the last transaction first so that as many kvs as possible are written together with guard
+	chunks := [][]*api.KVTxnOp{{cas}}
+	size := base64.StdEncoding.EncodedLen(len(cas.Value))
+	for i := len(sets) - 1; i >= 0; i-- {
+		last := len(chunks) - 1
+		// one operation in every earlier chunk is reserved for checking guard
+		valueSize := base64.StdEncoding.EncodedLen(len(sets[i].Value))
+		if len(chunks[last])+1 >= ConsulTxnMaxOps || size+valueSize > ConsulTxnMaxBytes {
+			chunks = append(chunks, []*api.KVTxnOp{})
+			last++
+			size = 0
+		}
+		chunks[last] = append([]*api.KVTxnOp{sets[i]}, chunks[last]...)
+		size += valueSize
+	}
+
+	for c := len(chunks) - 1; c > 0; c-- {
+		check := &api.KVTxnOp{Verb: api.KVCheckIndex, Key: s.syncPath + guard, Index: guardIndex}
+		if guardIndex == 0 {
+			check = &api.KVTxnOp{Verb: api.KVCheckNotExists, Key: s.syncPath + guard}
+		}
+		_, err := s.txn(append([]*api.KVTxnOp{check}, chunks[c]...))
+		if err != nil {
+			return apperr.New(fmt.Sprintf("cannot publish buckets in consul %q", s.client.Dc), err, op)
+		}
+	}
+
+	// guard kv with as many deletes as fit, rest of deletes are guarded by the new modify index
+	final := chunks[0]
+	for len(deletes) > 0 && len(final) < ConsulTxnMaxOps {
+		final = append(final, &api.KVTxnOp{Verb: api.KVDelete, Key: s.syncPath + deletes[0]})
+		deletes = deletes[1:]
+	}
+	res, err := s.txn(final)
+	if err != nil {
+		return apperr.New(fmt.Sprintf("cannot publish %q in consul %q", guard, s.client.Dc), err, op)
+	}
+	for _, r := range res.Results {
+		if r.KV != nil && r.KV.Key == cas.Key {
+			guardIndex = r.KV.ModifyIndex
+		}
+	}
+
+	for len(deletes) > 0 {
+		ops := []*api.KVTxnOp{{Verb: api.KVCheckIndex, Key: s.syncPath + guard, Index: guardIndex}}
+		for len(deletes) > 0 && len(ops) < ConsulTxnMaxOps {
+			ops = append(ops, &api.KVTxnOp{Verb: api.KVDelete, Key: s.syncPath + deletes[0]})
+			deletes = deletes[1:]
+		}
+		_, err := s.txn(ops)
+		if err != nil {
+			log.Warn().Err(err).Str("store", s.String()).Msg("cannot delete stale keys after publishing, ignoring")
+			break
+		}
+	}
+
+	return nil
+}
+
+// txn runs kv operations in one consul transaction, failure of guarding operation is ErrConflict
+func (s *ConsulStore) txn(kvOps []*api.KVTxnOp) (*api.TxnResponse, error) {
+	const op = apperr.Op("syncer.ConsulStore.txn")
+
+	ops := make(api.TxnOps, 0, len(kvOps))
+	for _, kvOp := range kvOps {
+		ops = append(ops, &api.TxnOp{KV: kvOp})
+	}
+
+	ok, res, _, err := s.client.Txn().Txn(ops, nil)
+	if err != nil {
+		log.Debug().Err(err).Int("ops", len(ops)).Msg("cannot run transaction in consul")
+		return nil, apperr.New(fmt.Sprintf("cannot run transaction in consul %q", s.client.Dc), err, op, ErrInvalidStore)
+	}
+	if !ok {
+		whats := []string{}
+		conflict := false
+		for _, e := range res.Errors {
+			whats = append(whats, e.What)
+			if e.OpIndex < 0 || e.OpIndex >= len(ops) {
+				continue
+			}
+			switch ops[e.OpIndex].KV.Verb {
+			case api.KVCheckIndex, api.KVCheckNotExists, api.KVCAS:
+				conflict = true
+			}
+		}
+		log.Debug().Strs("errors", whats).Int("ops", len(ops)).Msg("transaction rolled back in consul")
+		if conflict {
+			return nil, apperr.New(fmt.Sprintf("transaction rolled back in consul %q: %s", s.client.Dc, strings.Join(whats, "; ")), ErrConflict, op)
+		}
+		return nil, apperr.New(fmt.Sprintf("transaction rolled back in consul %q: %s", s.client.Dc, strings.Join(whats, "; ")), ErrInvalidStore, op)
+	}
+
+	return res, nil
+}
+
+func (s *ConsulStore) Watch(ctx context.Context, key string, handler func()) error {
+	const op = apperr.Op("syncer.ConsulStore.Watch")
+	path := s.syncPath + key
+
+	// prepare the watch
+	plan, err := watch.Parse(map[string]interface{}{
+		"type":       "key",
+		"stale":      true,
+		"key":        path,
+		"datacenter": s.client.Dc,
+	})
+	if err != nil {
+		log.Debug().Err(err).Str("key", path).Str("dc", s.client.Dc).Msg("cannot make plan for key watch in consul")
+		return apperr.New(fmt.Sprintf("cannot make plan for key %q watch in consul %q", path, s.client.Dc), err, op, apperr.Fatal, ErrInvalidStore)
+	}
+
+	// handler to send data to another kv channel
+	plan.HybridHandler = func(blockParamVal watch.BlockingParamVal, val interface{}) {
+		// TODO: test blockParamVal https://github.com/hashicorp/consul/blob/master/api/watch/plan_test.go
+		if val == nil {
+			log.Debug().Msg("nil value received from consul watch")
+			return
+		}
+		handler()
+	}
+
+	// create a new go routine because plan run will block
+	runErrCh := make(chan error, 1)
+	go func() {
+		runErrCh <- plan.Run(s.client.Address)
+		log.Debug().Str("trigger", "context done").Str("path", path).Msg("closed consul watch")
+	}()
+
+	// lock the current go routine
+	// if context is done then stop the plan
+	select {
+	case <-ctx.Done():
+		plan.Stop()
+		time.Sleep(100 * time.Microsecond)
+		return nil
+	case err := <-runErrCh:
+		if err != nil {
+			log.Debug().Err(err).Msg("failure while performing consul watch run")
+			return apperr.New(fmt.Sprintf("failure while performing consul watch on %q in %q", path, s.client.Dc), err, op, apperr.Fatal, ErrInvalidStore)
+		}
+		return nil
+	}
+}
+
+func (s *ConsulStore) Checks() error {
+	return s.client.SyncPathChecks(s.syncPath, consul.StdCheck)
+}
+
+func (s *ConsulStore) IsInitialized() (bool, error) {
+	return s.client.IsSyncPathInitialized(s.syncPath)
+}
+
+func (s *ConsulStore) String() string {
+	return fmt.Sprintf("consul:%s/%s", s.client.Dc, s.syncPath)
+}
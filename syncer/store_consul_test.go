@@ -0,0 +1,150 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ExpediaGroup/vsync/consul"
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memConsul is a consul keeping kv in memory with modify indexes, listing and transactions
+func memConsul(t *testing.T) *consul.Client {
+	var mu sync.Mutex
+	kv := map[string]*api.KVPair{}
+	var index uint64
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+
+		switch {
+		case r.URL.Path == "/v1/txn":
+			ops := api.TxnOps{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&ops))
+
+			// check every guard before changing anything, consul rolls back the whole transaction
+			res := api.TxnResponse{}
+			for i, op := range ops {
+				pair := kv[op.KV.Key]
+				switch op.KV.Verb {
+				case api.KVCAS, api.KVCheckIndex:
+					if (pair == nil && op.KV.Index != 0) || (pair != nil && pair.ModifyIndex != op.KV.Index) {
+						res.Errors = append(res.Errors, &api.TxnError{OpIndex: i, What: "index is stale"})
+					}
+				case api.KVCheckNotExists:
+					if pair != nil {
+						res.Errors = append(res.Errors, &api.TxnError{OpIndex: i, What: "key exists"})
+					}
+				}
+			}
+			if len(res.Errors) > 0 {
+				w.WriteHeader(http.StatusConflict)
+				require.NoError(t, json.NewEncoder(w).Encode(res))
+				return
+			}
+
+			index++
+			for _, op := range ops {
+				switch op.KV.Verb {
+				case api.KVSet, api.KVCAS:
+					kv[op.KV.Key] = &api.KVPair{Key: op.KV.Key, Value: op.KV.Value, ModifyIndex: index}
+					res.Results = append(res.Results, &api.TxnResult{KV: &api.KVPair{Key: op.KV.Key, ModifyIndex: index}})
+				case api.KVDelete:
+					delete(kv, op.KV.Key)
+				}
+			}
+			require.NoError(t, json.NewEncoder(w).Encode(res))
+
+		case strings.HasPrefix(r.URL.Path, "/v1/kv/"):
+			key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+			if _, ok := r.URL.Query()["keys"]; ok {
+				keys := []string{}
+				for k := range kv {
+					if strings.HasPrefix(k, key) {
+						keys = append(keys, k)
+					}
+				}
+				sort.Strings(keys)
+				require.NoError(t, json.NewEncoder(w).Encode(keys))
+				return
+			}
+			pair := kv[key]
+			if pair == nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			require.NoError(t, json.NewEncoder(w).Encode(api.KVPairs{pair}))
+
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	config := api.DefaultConfig()
+	config.Address = server.URL
+	client, err := api.NewClient(config)
+	require.NoError(t, err)
+	return &consul.Client{Client: client, Dc: "dc1", Address: server.URL}
+}
+
+func TestConsulStorePublishConflict(t *testing.T) {
+	c := memConsul(t)
+	first, err := NewConsulStore(c, "vsync/origin/")
+	require.NoError(t, err)
+	second, err := NewConsulStore(c, "vsync/origin/")
+	require.NoError(t, err)
+
+	info, err := NewInfo(2)
+	require.NoError(t, err)
+	_, err = info.Put("secret/data/a", Insight{Version: 1, UpdateTime: "2019-09-15T00:58:20.680948367Z", Type: "kvV2"})
+	require.NoError(t, err)
+	require.NoError(t, info.Reindex())
+
+	// two origins start their cycle before anything is published
+	firstSince, err := IndexRevision(first)
+	require.NoError(t, err)
+	secondSince, err := IndexRevision(second)
+	require.NoError(t, err)
+
+	require.NoError(t, InfoToStore(first, info, firstSince))
+
+	// second reads the published info for tombstones and drop checks, it must not move its guard
+	read, err := NewInfo(2)
+	require.NoError(t, err)
+	require.NoError(t, InfoFromStore(second, read))
+
+	err = InfoToStore(second, info, secondSince)
+	assert.True(t, errors.Is(err, ErrConflict))
+
+	// next cycle of second starts after the publish of first
+	secondSince, err = IndexRevision(second)
+	require.NoError(t, err)
+	require.NoError(t, InfoToStore(second, info, secondSince))
+	err = InfoToStore(first, info, firstSince)
+	assert.True(t, errors.Is(err, ErrConflict))
+}
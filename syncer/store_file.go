@@ -0,0 +1,267 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ExpediaGroup/vsync/apperr"
+	uuid "github.com/gofrs/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// FilePollInterval is how often a file store checks a watched key for changes
+var FilePollInterval = 1 * time.Second
+
+// FileStore keeps sync info as files in a directory on local disk, each key is one file
+// useful for small or lab setups where there is no consul, origin and destination need to share the directory
+type FileStore struct {
+	dir string
+}
+
+func NewFileStore(root string, syncPath string) (*FileStore, error) {
+	const op = apperr.Op("syncer.NewFileStore")
+	if root == "" {
+		return nil, apperr.New(fmt.Sprintf("cannot create file store for sync path %q without a directory", syncPath), ErrInvalidStore, op, apperr.Fatal)
+	}
+
+	return &FileStore{
+		dir: filepath.Join(root, filepath.FromSlash(syncPath)),
+	}, nil
+}
+
+func (s *FileStore) file(key string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(key))
+}
+
+func (s *FileStore) Get(key string) ([]byte, error) {
+	const op = apperr.Op("syncer.FileStore.Get")
+
+	value, err := ioutil.ReadFile(s.file(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		log.Debug().Err(err).Str("file", s.file(key)).Msg("cannot read key from file")
+		return nil, apperr.New(fmt.Sprintf("cannot read file %q", s.file(key)), err, op, ErrInvalidStore)
+	}
+
+	return value, nil
+}
+
+// Put writes into a temporary file and renames it so that readers never see a half written value
+func (s *FileStore) Put(key string, value []byte) error {
+	const op = apperr.Op("syncer.FileStore.Put")
+
+	name := s.file(key)
+	err := os.MkdirAll(filepath.Dir(name), 0700)
+	if err != nil {
+		log.Debug().Err(err).Str("file", name).Msg("cannot create directory for key")
+		return apperr.New(fmt.Sprintf("cannot create directory for file %q", name), err, op, ErrInvalidStore)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(name), ".vsync-")
+	if err != nil {
+		log.Debug().Err(err).Str("file", name).Msg("cannot create temporary file for key")
+		return apperr.New(fmt.Sprintf("cannot create temporary file for %q", name), err, op, ErrInvalidStore)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(value)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Debug().Err(err).Str("file", tmp.Name()).Msg("cannot write temporary file for key")
+		return apperr.New(fmt.Sprintf("cannot write temporary file for %q", name), err, op, ErrInvalidStore)
+	}
+
+	err = os.Rename(tmp.Name(), name)
+	if err != nil {
+		log.Debug().Err(err).Str("file", name).Msg("cannot rename temporary file for key")
+		return apperr.New(fmt.Sprintf("cannot save file %q", name), err, op, ErrInvalidStore)
+	}
+	log.Debug().Str("file", name).Msg("saved key in file")
+
+	return nil
+}
+
+func (s *FileStore) Delete(key string) error {
+	const op = apperr.Op("syncer.FileStore.Delete")
+
+	err := os.Remove(s.file(key))
+	if err != nil && !os.IsNotExist(err) {
+		log.Debug().Err(err).Str("file", s.file(key)).Msg("cannot delete key file")
+		return apperr.New(fmt.Sprintf("cannot delete file %q", s.file(key)), err, op, ErrInvalidStore)
+	}
+
+	return nil
+}
+
+// Keys lists files directly in sync path directory, temporary files and sub directories are skipped
+func (s *FileStore) Keys() ([]string, error) {
+	const op = apperr.Op("syncer.FileStore.Keys")
+
+	files, err := ioutil.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		log.Debug().Err(err).Str("dir", s.dir).Msg("cannot list files in directory")
+		return nil, apperr.New(fmt.Sprintf("cannot list directory %q", s.dir), err, op, ErrInvalidStore)
+	}
+
+	keys := []string{}
+	for _, f := range files {
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".vsync-") {
+			continue
+		}
+		keys = append(keys, f.Name())
+	}
+	return keys, nil
+}
+
+// Revision is the content of the file of key
+func (s *FileStore) Revision(key string) (Revision, error) {
+	value, err := s.Get(key)
+	if err != nil || value == nil {
+		return Revision{}, err
+	}
+	return Revision{present: true, value: value}, nil
+}
+
+// Publish writes files one by one with guard file last, guard file must still have the content of since revision
+// a guard file present when since is of a missing key is a conflict too
+// other writers are only detected not excluded, file store is meant for single origin setups
+func (s *FileStore) Publish(guard string, since Revision, kvs []KV, deletes []string) error {
+	const op = apperr.Op("syncer.FileStore.Publish")
+
+	current, err := s.Revision(guard)
+	if err != nil {
+		return apperr.New(fmt.Sprintf("cannot read guard file %q", s.file(guard)), err, op, ErrInvalidStore)
+	}
+	if current.present != since.present || !bytes.Equal(current.value, since.value) {
+		log.Debug().Str("file", s.file(guard)).Bool("present", current.present).Bool("expected", since.present).Msg("guard file changed since it was read")
+		return apperr.New(fmt.Sprintf("guard file %q changed since it was read", s.file(guard)), ErrConflict, op)
+	}
+
+	var guardKV *KV
+	for i := range kvs {
+		if kvs[i].Key == guard {
+			guardKV = &kvs[i]
+			continue
+		}
+		if err := s.Put(kvs[i].Key, kvs[i].Value); err != nil {
+			return apperr.New(fmt.Sprintf("cannot publish %q", kvs[i].Key), err, op)
+		}
+	}
+	if guardKV != nil {
+		if err := s.Put(guardKV.Key, guardKV.Value); err != nil {
+			return apperr.New(fmt.Sprintf("cannot publish %q", guard), err, op)
+		}
+	}
+
+	for _, key := range deletes {
+		if err := s.Delete(key); err != nil {
+			log.Warn().Err(err).Str("file", s.file(key)).Msg("cannot delete stale file after publishing, ignoring")
+		}
+	}
+
+	return nil
+}
+
+// Watch polls the file of key, there is no blocking query like consul
+// handler is called for the first value found, similar to consul watch
+func (s *FileStore) Watch(ctx context.Context, key string, handler func()) error {
+	ticker := time.NewTicker(FilePollInterval)
+	defer ticker.Stop()
+
+	var last []byte
+	for {
+		value, err := s.Get(key)
+		if err != nil {
+			log.Debug().Err(err).Str("file", s.file(key)).Msg("cannot read file while watching")
+		} else if value != nil && !bytes.Equal(value, last) {
+			last = value
+			handler()
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Debug().Str("trigger", "context done").Str("file", s.file(key)).Msg("closed file watch")
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *FileStore) Checks() error {
+	const op = apperr.Op("syncer.FileStore.Checks")
+
+	id, _ := uuid.NewV4()
+	key := "vsyncChecks/" + id.String()
+
+	err := s.Put(key, []byte(id.String()))
+	if err != nil {
+		return apperr.New(fmt.Sprintf("cannot create dummy file in %q", s.dir), err, op, ErrInvalidStore)
+	}
+	log.Debug().Str("dir", s.dir).Msg("sync path is writable")
+
+	value, err := s.Get(key)
+	if err != nil {
+		return apperr.New(fmt.Sprintf("cannot read dummy file in %q", s.dir), err, op, ErrInvalidStore)
+	}
+	if string(value) != id.String() {
+		return apperr.New(fmt.Sprintf("cannot read the created dummy file in %q", s.dir), ErrInvalidStore, op)
+	}
+	log.Debug().Str("dir", s.dir).Msg("sync path is readable")
+
+	err = os.RemoveAll(filepath.Dir(s.file(key)))
+	if err != nil {
+		return apperr.New(fmt.Sprintf("cannot delete dummy file in %q", s.dir), err, op, ErrInvalidStore)
+	}
+	log.Debug().Str("dir", s.dir).Msg("sync path is deletable")
+
+	return nil
+}
+
+func (s *FileStore) IsInitialized() (bool, error) {
+	const op = apperr.Op("syncer.FileStore.IsInitialized")
+
+	files, err := ioutil.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		log.Debug().Err(err).Str("dir", s.dir).Msg("cannot check if directory is already present")
+		return false, apperr.New(fmt.Sprintf("cannot check if directory exists %q", s.dir), err, op, apperr.Fatal, ErrInitialize)
+	}
+
+	return len(files) > 0, nil
+}
+
+func (s *FileStore) String() string {
+	return "file:" + s.dir
+}
@@ -0,0 +1,209 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// publish saves sync info guarded by the index as it is now, like a cycle of the only writer
+func publish(t *testing.T, s Store, i *Info) {
+	since, err := IndexRevision(s)
+	require.NoError(t, err)
+	require.NoError(t, InfoToStore(s, i, since))
+}
+
+func TestFileStoreInfo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vsync")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := NewFileStore(dir, "vsync/origin/")
+	require.NoError(t, err)
+	require.NoError(t, s.Checks())
+
+	initialized, err := s.IsInitialized()
+	require.NoError(t, err)
+	assert.False(t, initialized, "checks must clean up after themselves")
+
+	origin, err := NewInfo(3)
+	require.NoError(t, err)
+	_, err = origin.Put("secret/data/a", Insight{Version: 1, UpdateTime: "2019-09-15T00:58:20.680948367Z", Type: "kvV2"})
+	require.NoError(t, err)
+	require.NoError(t, origin.Reindex())
+	publish(t, s, origin)
+
+	initialized, err = s.IsInitialized()
+	require.NoError(t, err)
+	assert.True(t, initialized)
+
+	destination, err := NewInfo(3)
+	require.NoError(t, err)
+	require.NoError(t, InfoFromStore(s, destination))
+
+	add, update, del, errs := origin.Compare(destination)
+	assert.Empty(t, errs)
+	assert.Empty(t, add)
+	assert.Empty(t, update)
+	assert.Empty(t, del)
+}
+
+func TestFileStorePublishConflict(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vsync")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	first, err := NewFileStore(dir, "vsync/origin/")
+	require.NoError(t, err)
+	second, err := NewFileStore(dir, "vsync/origin/")
+	require.NoError(t, err)
+
+	info, err := NewInfo(3)
+	require.NoError(t, err)
+	require.NoError(t, info.Reindex())
+	publish(t, first, info)
+
+	// second writer never read the index, so the index published by first is a conflict
+	err = InfoToStore(second, info, Revision{})
+	assert.True(t, errors.Is(err, ErrConflict))
+
+	// both cycles start, first publishes, reads of second for inspection do not move its guard
+	since, err := IndexRevision(second)
+	require.NoError(t, err)
+	_, err = info.Put("secret/data/a", Insight{Version: 1, UpdateTime: "2019-09-15T00:58:20.680948367Z", Type: "kvV2"})
+	require.NoError(t, err)
+	require.NoError(t, info.Reindex())
+	publish(t, first, info)
+
+	read, err := NewInfo(3)
+	require.NoError(t, err)
+	require.NoError(t, InfoFromStore(second, read))
+	err = InfoToStore(second, read, since)
+	assert.True(t, errors.Is(err, ErrConflict))
+
+	// bucket saved without its index, like a reader in between chunks of a publish
+	require.NoError(t, first.Put("0", []byte(`{"secret/data/b":{"version":1}}`)))
+	err = InfoFromStore(second, read)
+	assert.True(t, errors.Is(err, ErrInconsistent))
+}
+
+func TestFileStoreCompressedShards(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vsync")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := NewFileStore(dir, "vsync/origin/")
+	require.NoError(t, err)
+
+	for _, compression := range []string{CompressionGzip, CompressionZstd, CompressionNone} {
+		origin, err := NewInfo(2)
+		require.NoError(t, err)
+		require.NoError(t, origin.SetEncoding(compression, 64))
+		for i := 0; i < 200; i++ {
+			_, err = origin.Put(fmt.Sprintf("secret/data/%d", i), Insight{Version: 1, UpdateTime: "2019-09-15T00:58:20.680948367Z", Type: "kvV2"})
+			require.NoError(t, err)
+		}
+		require.NoError(t, origin.Reindex())
+		publish(t, s, origin)
+
+		destination, err := NewInfo(2)
+		require.NoError(t, err)
+		require.NoError(t, InfoFromStore(s, destination))
+		assert.Equal(t, 3, destination.Header().FormatVersion)
+		assert.Len(t, destination.header.Shards, 2, "buckets must be split")
+
+		add, update, del, errs := origin.Compare(destination)
+		assert.Empty(t, errs)
+		assert.Empty(t, add)
+		assert.Empty(t, update)
+		assert.Empty(t, del)
+	}
+
+	// buckets shrink back to one key each, continuation keys are removed
+	origin, err := NewInfo(2)
+	require.NoError(t, err)
+	require.NoError(t, origin.Reindex())
+	publish(t, s, origin)
+	keys, err := s.Keys()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"0", "1", "header", "index"}, keys)
+}
+
+type countingStore struct {
+	Store
+	gets map[string]int
+}
+
+func (s *countingStore) Get(key string) ([]byte, error) {
+	s.gets[key]++
+	return s.Store.Get(key)
+}
+
+func TestBucketsFromStoreCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vsync")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	originStore, err := NewFileStore(dir, "vsync/origin/")
+	require.NoError(t, err)
+	destinationStore, err := NewFileStore(dir, "vsync/destination/")
+	require.NoError(t, err)
+
+	insight := Insight{Version: 1, UpdateTime: "2019-09-15T00:58:20.680948367Z", Type: "kvV2"}
+	origin, err := NewInfo(4)
+	require.NoError(t, err)
+	destination, err := NewInfo(4)
+	require.NoError(t, err)
+	for i := 0; i < 50; i++ {
+		_, err = origin.Put(fmt.Sprintf("secret/data/%d", i), insight)
+		require.NoError(t, err)
+		_, err = destination.Put(fmt.Sprintf("secret/data/%d", i), insight)
+		require.NoError(t, err)
+	}
+	changed, err := origin.Put("secret/data/new", insight)
+	require.NoError(t, err)
+	require.NoError(t, origin.Reindex())
+	require.NoError(t, destination.Reindex())
+	publish(t, originStore, origin)
+	publish(t, destinationStore, destination)
+
+	cache := NewCache()
+	counter := &countingStore{Store: originStore, gets: map[string]int{}}
+
+	d, err := NewInfo(4)
+	require.NoError(t, err)
+	require.NoError(t, IndexFromStore(destinationStore, d, cache))
+	require.NoError(t, BucketsFromStore(destinationStore, d, cache))
+
+	o, err := NewInfo(4)
+	require.NoError(t, err)
+	require.NoError(t, IndexFromStore(counter, o, cache))
+	assert.False(t, o.InSync(d))
+	require.NoError(t, BucketsFromStore(counter, o, cache))
+	assert.Equal(t, map[string]int{"header": 1, "index": 1, bucketKey(changed): 1}, counter.gets, "only the changed bucket must be read")
+
+	add, update, del, errs := o.Compare(d)
+	assert.Empty(t, errs)
+	assert.Len(t, add, 1)
+	assert.Empty(t, update)
+	assert.Empty(t, del)
+}
@@ -0,0 +1,364 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ExpediaGroup/vsync/apperr"
+	"github.com/ExpediaGroup/vsync/vault"
+	"github.com/rs/zerolog/log"
+)
+
+// VaultPollInterval is how often a vault store checks the version of a watched key
+var VaultPollInterval = 5 * time.Second
+
+// VaultStore keeps sync info in a vault kv v2 mount, each key is one secret under sync path
+// values are saved base64 encoded in "value" field of the secret
+// writes are check-and-set against the version we last read or wrote, so two writers cannot overwrite each other silently
+type VaultStore struct {
+	client   *vault.Client
+	mount    string
+	syncPath string
+
+	rw       sync.Mutex
+	versions map[string]int64
+}
+
+func NewVaultStore(v *vault.Client, mount string, syncPath string) (*VaultStore, error) {
+	const op = apperr.Op("syncer.NewVaultStore")
+	if v == nil {
+		return nil, apperr.New(fmt.Sprintf("cannot create vault store for sync path %q without vault client", syncPath), ErrInvalidStore, op, apperr.Fatal)
+	}
+	if !strings.HasSuffix(mount, "/") {
+		return nil, apperr.New(fmt.Sprintf("vault store mount %q is missing a / at last", mount), ErrInvalidStore, op, apperr.Fatal)
+	}
+
+	return &VaultStore{
+		client:   v,
+		mount:    mount,
+		syncPath: syncPath,
+		rw:       sync.Mutex{},
+		versions: map[string]int64{},
+	}, nil
+}
+
+func (s *VaultStore) dataPath(key string) string {
+	return s.mount + "data/" + s.syncPath + key
+}
+
+func (s *VaultStore) metaPath(key string) string {
+	return s.mount + "metadata/" + s.syncPath + key
+}
+
+func (s *VaultStore) Get(key string) ([]byte, error) {
+	const op = apperr.Op("syncer.VaultStore.Get")
+
+	path := s.dataPath(key)
+	secret, err := s.client.Logical().Read(path)
+	if err != nil {
+		log.Debug().Err(err).Str("path", path).Msg("failure on retrieving key from vault")
+		return nil, apperr.New(fmt.Sprintf("cannot read vault path %q", path), err, op, ErrInvalidStore)
+	}
+	// latest version deleted has metadata without data
+	if secret == nil || secret.Data["data"] == nil {
+		return nil, nil
+	}
+
+	version, err := secretVersion(secret.Data["metadata"])
+	if err != nil {
+		log.Debug().Err(err).Str("path", path).Msg("cannot get version of key from vault")
+		return nil, apperr.New(fmt.Sprintf("cannot get version of vault path %q", path), err, op, ErrInvalidStore)
+	}
+
+	data, ok := secret.Data["data"].(map[string]interface{})
+	if !ok {
+		return nil, apperr.New(fmt.Sprintf("cannot type cast %q to %q in vault path %q", "data", "map[string]interface{}", path), ErrInvalidStore, op)
+	}
+	encoded, ok := data["value"].(string)
+	if !ok {
+		return nil, apperr.New(fmt.Sprintf("cannot type cast %q to %q in vault path %q", "value", "string", path), ErrInvalidStore, op)
+	}
+	value, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		log.Debug().Err(err).Str("path", path).Msg("cannot decode value of key from vault")
+		return nil, apperr.New(fmt.Sprintf("cannot decode value in vault path %q", path), err, op, ErrInvalidStore)
+	}
+
+	s.rw.Lock()
+	s.versions[key] = version
+	s.rw.Unlock()
+
+	return value, nil
+}
+
+// Put writes with check-and-set using the version we last saw for key
+// if we never saw the key, its current version is read from metadata first
+func (s *VaultStore) Put(key string, value []byte) error {
+	const op = apperr.Op("syncer.VaultStore.Put")
+
+	s.rw.Lock()
+	cas, ok := s.versions[key]
+	s.rw.Unlock()
+	if !ok {
+		var err error
+		cas, err = s.currentVersion(key)
+		if err != nil {
+			return apperr.New(fmt.Sprintf("cannot get current version of key %q for check-and-set", key), err, op, ErrInvalidStore)
+		}
+	}
+
+	return s.put(key, value, cas)
+}
+
+func (s *VaultStore) put(key string, value []byte, cas int64) error {
+	const op = apperr.Op("syncer.VaultStore.Put")
+
+	path := s.dataPath(key)
+	secret, err := s.client.Logical().Write(path, map[string]interface{}{
+		"options": map[string]interface{}{
+			"cas": cas,
+		},
+		"data": map[string]interface{}{
+			"value": base64.StdEncoding.EncodeToString(value),
+		},
+	})
+	if err != nil {
+		// forget the version so that next cycle reads what the other writer has done
+		s.rw.Lock()
+		delete(s.versions, key)
+		s.rw.Unlock()
+
+		log.Debug().Err(err).Str("path", path).Int64("cas", cas).Msg("cannot save key to vault, may be changed by another writer")
+		return apperr.New(fmt.Sprintf("cannot save vault path %q with check-and-set version %d", path, cas), err, op, ErrInvalidStore)
+	}
+
+	if secret != nil {
+		version, err := secretVersion(secret.Data)
+		if err == nil {
+			s.rw.Lock()
+			s.versions[key] = version
+			s.rw.Unlock()
+		}
+	}
+	log.Debug().Str("path", path).Int64("cas", cas).Msg("saved key in vault")
+
+	return nil
+}
+
+// Delete removes all versions and metadata of key
+func (s *VaultStore) Delete(key string) error {
+	const op = apperr.Op("syncer.VaultStore.Delete")
+
+	path := s.metaPath(key)
+	_, err := s.client.Logical().Delete(path)
+	if err != nil {
+		log.Debug().Err(err).Str("path", path).Msg("cannot delete key from vault")
+		return apperr.New(fmt.Sprintf("cannot delete vault path %q", path), err, op, ErrInvalidStore)
+	}
+
+	s.rw.Lock()
+	delete(s.versions, key)
+	s.rw.Unlock()
+
+	return nil
+}
+
+// Keys lists secrets directly under sync path, sub folders are skipped
+func (s *VaultStore) Keys() ([]string, error) {
+	const op = apperr.Op("syncer.VaultStore.Keys")
+
+	path := s.metaPath("")
+	secret, err := s.client.Logical().List(path)
+	if err != nil {
+		log.Debug().Err(err).Str("path", path).Msg("cannot list keys from vault")
+		return nil, apperr.New(fmt.Sprintf("cannot list vault path %q", path), err, op, ErrInvalidStore)
+	}
+	keys := []string{}
+	if secret == nil {
+		return keys, nil
+	}
+
+	list, ok := secret.Data["keys"].([]interface{})
+	if !ok {
+		return nil, apperr.New(fmt.Sprintf("cannot type cast %q to %q in vault path %q", "keys", "[]interface{}", path), ErrInvalidStore, op)
+	}
+	for _, k := range list {
+		key, ok := k.(string)
+		if !ok || strings.HasSuffix(key, "/") {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Revision is the current version of key from metadata
+func (s *VaultStore) Revision(key string) (Revision, error) {
+	version, err := s.currentVersion(key)
+	if err != nil || version == 0 {
+		return Revision{}, err
+	}
+	return Revision{present: true, index: uint64(version)}, nil
+}
+
+// Publish writes secrets one by one with guard secret last, vault has no transactions across secrets
+// guard is check-and-set against since version, so a concurrent writer fails the publish
+func (s *VaultStore) Publish(guard string, since Revision, kvs []KV, deletes []string) error {
+	const op = apperr.Op("syncer.VaultStore.Publish")
+
+	last := int64(since.index)
+	current, err := s.currentVersion(guard)
+	if err != nil {
+		return apperr.New(fmt.Sprintf("cannot get current version of guard key %q", guard), err, op, ErrInvalidStore)
+	}
+	if current != last {
+		log.Debug().Int64("last", last).Int64("current", current).Str("path", s.metaPath(guard)).Msg("guard key changed since it was read")
+		return apperr.New(fmt.Sprintf("guard key %q changed from version %d to %d", guard, last, current), ErrConflict, op)
+	}
+
+	var guardKV *KV
+	for i := range kvs {
+		if kvs[i].Key == guard {
+			guardKV = &kvs[i]
+			continue
+		}
+		if err := s.Put(kvs[i].Key, kvs[i].Value); err != nil {
+			return apperr.New(fmt.Sprintf("cannot publish %q", kvs[i].Key), err, op)
+		}
+	}
+	if guardKV != nil {
+		if err := s.put(guardKV.Key, guardKV.Value, last); err != nil {
+			if strings.Contains(err.Error(), "check-and-set") {
+				return apperr.New(fmt.Sprintf("guard key %q changed while publishing", guard), ErrConflict, op)
+			}
+			return apperr.New(fmt.Sprintf("cannot publish %q", guard), err, op)
+		}
+	}
+
+	for _, key := range deletes {
+		if err := s.Delete(key); err != nil {
+			log.Warn().Err(err).Str("path", s.metaPath(key)).Msg("cannot delete stale key after publishing, ignoring")
+		}
+	}
+
+	return nil
+}
+
+// Watch polls the current version of key from metadata, there is no blocking query like consul
+// handler is called for the first version found, similar to consul watch
+func (s *VaultStore) Watch(ctx context.Context, key string, handler func()) error {
+	ticker := time.NewTicker(VaultPollInterval)
+	defer ticker.Stop()
+
+	var last int64
+	for {
+		version, err := s.currentVersion(key)
+		if err != nil {
+			log.Debug().Err(err).Str("path", s.metaPath(key)).Msg("cannot get current version while watching")
+		} else if version > 0 && version != last {
+			last = version
+			handler()
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Debug().Str("trigger", "context done").Str("path", s.metaPath(key)).Msg("closed vault watch")
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *VaultStore) currentVersion(key string) (int64, error) {
+	const op = apperr.Op("syncer.VaultStore.currentVersion")
+
+	path := s.metaPath(key)
+	secret, err := s.client.Logical().Read(path)
+	if err != nil {
+		log.Debug().Err(err).Str("path", path).Msg("cannot read metadata of key from vault")
+		return 0, apperr.New(fmt.Sprintf("cannot read metadata in vault path %q", path), err, op, ErrInvalidStore)
+	}
+	if secret == nil {
+		return 0, nil
+	}
+
+	n, ok := secret.Data["current_version"].(json.Number)
+	if !ok {
+		return 0, apperr.New(fmt.Sprintf("cannot type cast %q to %q in vault path %q", "current_version", "json number", path), ErrInvalidStore, op)
+	}
+	return n.Int64()
+}
+
+func (s *VaultStore) Checks() error {
+	const op = apperr.Op("syncer.VaultStore.Checks")
+
+	m, err := s.client.GetMount(s.mount)
+	if err != nil {
+		return apperr.New(fmt.Sprintf("could not get mount %q for sync info", s.mount), err, op, ErrInvalidStore)
+	}
+	if !((m.Type == "kv" || m.Type == "generic") && m.Options["version"] == "2") {
+		log.Debug().Interface("mount", m).Str("path", s.mount).Msg("mount is not of type kv v2")
+		return apperr.New(fmt.Sprintf("mount %q for sync info is not a kv_v2", s.mount), ErrInvalidStore, op)
+	}
+
+	err = s.client.CheckTokenPermissions(s.dataPath(""), vault.CheckAll)
+	if err != nil {
+		return apperr.New(fmt.Sprintf("vault token missing permissions on sync path %q", s.dataPath("")), err, op, ErrInvalidStore)
+	}
+	err = s.client.CheckTokenPermissions(s.metaPath(""), vault.CheckAll)
+	if err != nil {
+		return apperr.New(fmt.Sprintf("vault token missing permissions on sync path %q", s.metaPath("")), err, op, ErrInvalidStore)
+	}
+
+	return nil
+}
+
+func (s *VaultStore) IsInitialized() (bool, error) {
+	const op = apperr.Op("syncer.VaultStore.IsInitialized")
+
+	keys, folders, err := s.client.DeepListPaths(s.metaPath(""))
+	if err != nil {
+		log.Debug().Err(err).Str("path", s.metaPath("")).Msg("cannot check if path is already present in vault")
+		return false, apperr.New(fmt.Sprintf("cannot check if vault path exists %q", s.metaPath("")), err, op, apperr.Fatal, ErrInitialize)
+	}
+
+	return len(keys)+len(folders) > 0, nil
+}
+
+func (s *VaultStore) String() string {
+	return fmt.Sprintf("vault:%s/%sdata/%s", s.client.Address, s.mount, s.syncPath)
+}
+
+// secretVersion gets version from kv v2 write response or metadata of read response
+func secretVersion(data interface{}) (int64, error) {
+	const op = apperr.Op("syncer.secretVersion")
+
+	m, ok := data.(map[string]interface{})
+	if !ok {
+		return 0, apperr.New(fmt.Sprintf("cannot type cast %q to %q", "metadata", "map[string]interface{}"), ErrInvalidMeta, op)
+	}
+	n, ok := m["version"].(json.Number)
+	if !ok {
+		return 0, apperr.New(fmt.Sprintf("cannot type cast %q %q to %q", m["version"], "version", "json number"), ErrInvalidMeta, op)
+	}
+	return n.Int64()
+}
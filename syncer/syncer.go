@@ -15,14 +15,12 @@
 package syncer
 
 import (
-	"encoding/json"
 	"fmt"
 	"reflect"
 	"time"
 
 	"github.com/ExpediaGroup/vsync/apperr"
 	"github.com/ExpediaGroup/vsync/consul"
-	"github.com/hashicorp/consul/api"
 	"github.com/rs/zerolog/log"
 )
 
@@ -37,14 +35,15 @@ var (
 	ErrUnknownOp      = fmt.Errorf("unknown operation")
 	ErrCorrupted      = fmt.Errorf("I got ¡™£¢∞NeuRALyzED§¶•ªº! Sync info in corrupted state")
 	ErrInitialize     = fmt.Errorf("Nope, not gonna work! Sync info not initialized")
+	ErrVersionGone    = fmt.Errorf("origin version deleted or destroyed")
 )
 
 var IgnoreDeletes = false
 
 type Task struct {
-	Path    string
-	Op      string
-	Insight Insight
+	Path    string  `json:"path"`
+	Op      string  `json:"op"`
+	Insight Insight `json:"insight"`
 }
 
 func (origin *Info) Compare(destination *Info) ([]Task, []Task, []Task, []error) {
@@ -68,40 +67,130 @@ func (origin *Info) Compare(destination *Info) ([]Task, []Task, []Task, []error)
 		return add, update, delete, errs
 	}
 
-	if len(origindex) != len(destinationIndex) {
-		errs = append(errs, apperr.New(fmt.Sprintf("non comparable indexes origin & destination %q != %q", len(origindex), len(destinationIndex)), ErrInitialize, op, apperr.Fatal))
+	err = origin.header.Comparable(destination.header)
+	if err != nil {
+		errs = append(errs, apperr.New(fmt.Sprintf("non comparable headers origin & destination"), err, op, apperr.Fatal, ErrInitialize))
 		return add, update, delete, errs
 	}
 
-	// compare indexes
-	for i, hash := range origindex {
-		if hash != destinationIndex[i] {
-			log.Debug().Int("bucketId", i).Str("originHash", hash).Str("destinationHash", destinationIndex[i]).Msg("bucket's index different")
+	// destination can have different number of buckets or bucketing than origin
+	// so assign destination paths to buckets like origin, then indexes are comparable
+	originHeader := origin.Header()
+	destinationHeader := destination.Header()
+	if !originHeader.SameLayout(destinationHeader) {
+		log.Info().Int("originBuckets", len(origindex)).Str("originBucketing", originHeader.Bucketing).Int("destinationBuckets", len(destinationIndex)).Str("destinationBucketing", destinationHeader.Bucketing).Msg("rebucketing destination sync info for comparing with origin")
 
-			originBucket, err := origin.GetBucket(i)
-			if err != nil {
-				errs = append(errs, apperr.New(fmt.Sprintf("cannot find origin bucket %q", i), err, op, ErrInvalidBucket))
-			}
+		destination, err = destination.Rebucket(originHeader)
+		if err != nil {
+			errs = append(errs, apperr.New(fmt.Sprintf("non comparable indexes origin & destination %d != %d, cannot rebucket destination", len(origindex), len(destinationIndex)), err, op, apperr.Fatal, ErrInitialize))
+			return add, update, delete, errs
+		}
+
+		destinationIndex, err = destination.GetIndex()
+		if err != nil {
+			errs = append(errs, apperr.New(fmt.Sprintf("cannot find rebucketed destination index"), err, op, ErrInvalidIndex))
+			return add, update, delete, errs
+		}
+	}
 
-			destinationBucket, err := destination.GetBucket(i)
-			if err != nil {
-				errs = append(errs, apperr.New(fmt.Sprintf("cannot find destination bucket %q", i), err, op, ErrInvalidBucket))
+	// compare indexes, merkle index descends only into changed nodes
+	changed := []int{}
+	if originHeader.Fanout > 0 {
+		hasher, err := NewHasher(originHeader.HashAlgorithm)
+		if err != nil {
+			errs = append(errs, apperr.New(fmt.Sprintf("cannot get hasher for merkle index"), err, op, ErrInvalidIndex))
+			return add, update, delete, errs
+		}
+		originLevels, err := merkleLevels(hasher, origindex, originHeader.Fanout)
+		if err != nil {
+			errs = append(errs, apperr.New(fmt.Sprintf("cannot build origin merkle index"), err, op, ErrInvalidIndex))
+			return add, update, delete, errs
+		}
+		destinationLevels, err := merkleLevels(hasher, destinationIndex, originHeader.Fanout)
+		if err != nil {
+			errs = append(errs, apperr.New(fmt.Sprintf("cannot build destination merkle index"), err, op, ErrInvalidIndex))
+			return add, update, delete, errs
+		}
+		changed = changedLeaves(originLevels, destinationLevels, originHeader.Fanout)
+	} else {
+		for i, hash := range origindex {
+			if hash != destinationIndex[i] {
+				changed = append(changed, i)
 			}
+		}
+	}
+	log.Debug().Int("buckets", len(origindex)).Int("changed", len(changed)).Msg("compared indexes")
 
-			newAdd, newUpdate, newDelete, newErrs := CompareBuckets(originBucket, destinationBucket)
-			add = append(add, newAdd...)
-			update = append(update, newUpdate...)
-			delete = append(delete, newDelete...)
-			errs = append(errs, newErrs...)
-		} else {
-			log.Debug().Int("bucketId", i).Str("hash", hash).Msg("bucket's index matched")
+	options := compareOptions{
+		fingerprints: originHeader.FingerprintKey != "" && originHeader.FingerprintKey == destinationHeader.FingerprintKey,
+		tombstones:   originHeader.Tombstones,
+		unknown:      originHeader.Unknown,
+	}
+
+	for _, i := range changed {
+		log.Debug().Int("bucketId", i).Str("originHash", origindex[i]).Str("destinationHash", destinationIndex[i]).Msg("bucket's index different")
+
+		originBucket, err := origin.GetBucket(i)
+		if err != nil {
+			errs = append(errs, apperr.New(fmt.Sprintf("cannot find origin bucket %q", i), err, op, ErrInvalidBucket))
 		}
+
+		destinationBucket, err := destination.GetBucket(i)
+		if err != nil {
+			errs = append(errs, apperr.New(fmt.Sprintf("cannot find destination bucket %q", i), err, op, ErrInvalidBucket))
+		}
+
+		newAdd, newUpdate, newDelete, newErrs := compareBuckets(originBucket, destinationBucket, options)
+		add = append(add, newAdd...)
+		update = append(update, newUpdate...)
+		delete = append(delete, newDelete...)
+		errs = append(errs, newErrs...)
 	}
 
 	return add, update, delete, errs
 }
 
+// InSync checks if origin and destination have same buckets with same hashes in index
+// then buckets need not be read or compared
+func (origin *Info) InSync(destination *Info) bool {
+	originHeader := origin.Header()
+	destinationHeader := destination.Header()
+	if !originHeader.SameLayout(destinationHeader) || origin.header.Comparable(destination.header) != nil {
+		return false
+	}
+
+	origin.rw.RLock()
+	defer origin.rw.RUnlock()
+	destination.rw.RLock()
+	defer destination.rw.RUnlock()
+
+	if len(origin.index) != len(destination.index) {
+		return false
+	}
+	for id, hash := range origin.index {
+		if hash != destination.index[id] {
+			return false
+		}
+	}
+	return true
+}
+
+// CompareBuckets compares buckets of an origin without tombstones, paths missing in origin are deleted
 func CompareBuckets(origin Bucket, destination Bucket) ([]Task, []Task, []Task, []error) {
+	return compareBuckets(origin, destination, compareOptions{fingerprints: true})
+}
+
+// compareOptions come from origin and destination headers
+type compareOptions struct {
+	// fingerprints made with different keys never match, so they are compared only with same key
+	fingerprints bool
+	// origin records deletes as tombstones, so a path missing in origin is never deleted
+	tombstones bool
+	// paths and subtrees origin could not walk or read, paths missing under them are never deleted
+	unknown []string
+}
+
+func compareBuckets(origin Bucket, destination Bucket, options compareOptions) ([]Task, []Task, []Task, []error) {
 	const op = apperr.Op("syncer.CompareBuckets")
 
 	add := []Task{}
@@ -113,17 +202,48 @@ func CompareBuckets(origin Bucket, destination Bucket) ([]Task, []Task, []Task,
 	for key, origInsight := range origin {
 
 		destinationInsight, ok := destination[key]
-		if !ok {
-			// new key
+		if ok {
+			processed[key] = true
+		}
+
+		if origInsight.Deleted != "" {
+			if ok && destinationInsight.Deleted != origInsight.Deleted {
+				// deleted in origin, or deleted further like a destroy after a soft delete
+				delete = append(delete, Task{
+					Path:    key,
+					Op:      "delete",
+					Insight: origInsight,
+				})
+			} else if !reflect.DeepEqual(origInsight, destinationInsight) {
+				// nothing to delete in destination, only remember the tombstone
+				delete = append(delete, Task{
+					Path:    key,
+					Op:      "tombstone",
+					Insight: origInsight,
+				})
+			}
+			continue
+		}
+
+		if ok && destinationInsight.Deleted == DeletedSoft && destinationInsight.Version == origInsight.Version {
+			// same version undeleted in origin
 			add = append(add, Task{
 				Path:    key,
-				Op:      "add",
+				Op:      "undelete",
 				Insight: origInsight,
 			})
 			continue
 		}
 
-		processed[key] = true
+		if !ok || destinationInsight.Deleted != "" {
+			// new key or created again after delete
+			add = append(add, Task{
+				Path:    key,
+				Op:      "add",
+				Insight: origInsight,
+			})
+			continue
+		}
 
 		if origInsight.Type != destinationInsight.Type {
 			// type itself got changed
@@ -135,6 +255,38 @@ func CompareBuckets(origin Bucket, destination Bucket) ([]Task, []Task, []Task,
 			continue
 		}
 
+		if origInsight.Type == "kvV1" {
+			// kv v1 has no version or update time, only its fingerprint shows a change
+			if origInsight.Fingerprint != destinationInsight.Fingerprint {
+				update = append(update, Task{
+					Path:    key,
+					Op:      "update",
+					Insight: origInsight,
+				})
+			}
+			continue
+		}
+
+		if options.fingerprints && origInsight.Fingerprint != "" && destinationInsight.Fingerprint != "" && origInsight.Fingerprint != destinationInsight.Fingerprint {
+			// data differs without a version change, like a secret changed outside of vsync or a recreated origin
+			update = append(update, Task{
+				Path:    key,
+				Op:      "update",
+				Insight: origInsight,
+			})
+			continue
+		}
+
+		if origInsight.Metadata != "" && origInsight.Metadata != destinationInsight.Metadata && origInsight.Version == destinationInsight.Version {
+			// only metadata settings changed, data need not be written again
+			update = append(update, Task{
+				Path:    key,
+				Op:      "metadata",
+				Insight: origInsight,
+			})
+			continue
+		}
+
 		if origInsight.Version > destinationInsight.Version {
 			// origin key updated
 			update = append(update, Task{
@@ -175,16 +327,33 @@ func CompareBuckets(origin Bucket, destination Bucket) ([]Task, []Task, []Task,
 	if len(destination) != len(processed) {
 		// some destination key are stale
 
-		for key := range destination {
+		for key, destinationInsight := range destination {
 			_, ok := origin[key]
-			if !ok {
+			if ok {
+				continue
+			}
+			if isUnknown(options.unknown, key) {
+				log.Debug().Str("key", key).Msg("path missing in origin is unknown, origin could not walk or read it, not deleting")
+				continue
+			}
+			switch {
+			case destinationInsight.Deleted != "":
+				// tombstone expired in origin
+				delete = append(delete, Task{
+					Path:    key,
+					Op:      "forget",
+					Insight: Insight{},
+				})
+			case options.tombstones:
+				// missing in origin is not a delete, may be origin could not see it
+				log.Debug().Str("key", key).Msg("path missing in origin without tombstone, not deleting")
+			default:
 				// delete key
 				delete = append(delete, Task{
 					Path:    key,
 					Op:      "delete",
 					Insight: Insight{},
 				})
-				continue
 			}
 		}
 	}
@@ -192,116 +361,25 @@ func CompareBuckets(origin Bucket, destination Bucket) ([]Task, []Task, []Task,
 	return add, update, delete, errs
 }
 
+// InfoToConsul saves sync info in consul kv under sync path, guarded by the index as it is right now
+// writers with a cycle use IndexRevision at the start of the cycle and InfoToStore instead
 func InfoToConsul(c *consul.Client, i *Info, syncPath string) error {
-	const op = apperr.Op("syncer.InfoToConsul")
-
-	index, err := i.GetIndex()
-	if err != nil {
-		log.Debug().Err(err).Msg("cannot find index")
-		return apperr.New(fmt.Sprintf("cannot find index for saving"), err, op, ErrInvalidIndex)
-	}
-
-	// buckets
-	// all buckets need to be saved first before index because index will trigger a cycle in destination
-	for id := range index {
-		syncBucket := fmt.Sprintf("%s%d", syncPath, id)
-		bucket, err := i.GetBucket(id)
-		if err != nil {
-			log.Debug().Err(err).Int("bucketId", id).Msg("cannot get bucket")
-			return apperr.New(fmt.Sprintf("cannot find bucket %q for saving", id), err, op, ErrInvalidBucket)
-		}
-		value, err := json.Marshal(bucket)
-		if err != nil {
-			log.Debug().Err(err).Int("bucketId", id).Msg("cannot marshal bucket")
-			return apperr.New(fmt.Sprintf("cannot marshal bucket %q for saving", id), err, op, ErrInvalidBucket)
-		}
-
-		res, err := c.KV().Put(&api.KVPair{
-			Key:   syncBucket,
-			Value: value,
-		}, nil)
-		if err != nil {
-			log.Debug().Err(err).Str("path", syncBucket).Msg("cannot save bucket to consul")
-			return apperr.New(fmt.Sprintf("cannot save bucket %q for saving in consul kv path %q", id, syncBucket), err, op, ErrInvalidBucket)
-		}
-		log.Debug().Str("timeTaken", fmt.Sprint(res.RequestTime)).Str("path", syncBucket).Msg("saved bucket in consul")
-	}
-
-	// index
-	value, err := json.Marshal(index)
+	s, err := NewConsulStore(c, syncPath)
 	if err != nil {
-		log.Debug().Err(err).Msg("cannot marshal index")
-		return apperr.New(fmt.Sprintf("cannot marshal index for saving"), err, op, ErrInvalidIndex)
+		return err
 	}
-
-	syncIndex := syncPath + "index"
-	res, err := c.KV().Put(&api.KVPair{
-		Key:   syncIndex,
-		Value: value,
-	}, nil)
+	since, err := IndexRevision(s)
 	if err != nil {
-		log.Debug().Err(err).Str("path", syncIndex).Msg("cannot save index to consul")
-		return apperr.New(fmt.Sprintf("cannot save index for saving to consul kv path %q", syncIndex), err, op, ErrInvalidIndex)
+		return err
 	}
-	log.Debug().Str("timeTaken", fmt.Sprint(res.RequestTime)).Str("path", syncIndex).Msg("saved index in consul")
-
-	return nil
+	return InfoToStore(s, i, since)
 }
 
-func InfoFromConsul(c *consul.Client, i *Info, syncPath string) (err error) {
-	const op = apperr.Op("syncer.InfoFromConsul")
-
-	defer func() {
-		if r := recover(); r != nil {
-			log.Debug().Msg("panic while getting sync info")
-			var ok bool
-			err, ok = r.(error)
-			if !ok {
-				err = apperr.New(fmt.Sprintf("panic while getting sync info (%v)", r), ErrInvalidInfo, op)
-			}
-			err = apperr.New(fmt.Sprintf("panic while getting sync info (%v)", r), err, op, ErrInvalidInfo)
-		}
-	}()
-
-	// index
-	syncIndex := syncPath + "index"
-	res, _, err := c.KV().Get(syncIndex, nil)
-	if err != nil {
-		log.Debug().Err(err).Str("path", syncIndex).Msg("failure on retrieving index from consul")
-		return apperr.New(fmt.Sprintf("cannot get index from consul kv path %q", syncIndex), err, op, ErrInvalidInfo)
-	}
-	if res == nil {
-		log.Debug().Str("path", syncIndex).Msg("no response for retrieving index from consul")
-		return apperr.New(fmt.Sprintf("cannot get index from consul kv path %q", syncIndex), ErrInvalidInfo, op)
-	}
-
-	err = json.Unmarshal(res.Value, &i.index)
+// InfoFromConsul gets sync info from consul kv under sync path
+func InfoFromConsul(c *consul.Client, i *Info, syncPath string) error {
+	s, err := NewConsulStore(c, syncPath)
 	if err != nil {
-		log.Debug().Err(err).Str("path", syncIndex).Msg("cannot unmarshall index from consul")
-		return apperr.New(fmt.Sprintf("cannot unmarshal index from consul kv path %q", syncIndex), err, op, ErrInvalidIndex)
+		return err
 	}
-
-	// buckets
-	for id := range i.index {
-		syncBucket := fmt.Sprintf("%s%d", syncPath, id)
-		res, _, err := c.KV().Get(syncBucket, nil)
-		if err != nil {
-			log.Debug().Err(err).Int("bucketId", id).Str("path", syncBucket).Msg("failure on retrieving bucket from consul")
-			return apperr.New(fmt.Sprintf("cannot get bucket %q from consul kv path %q", id, syncBucket), err, op, ErrInvalidInfo)
-		}
-		if res == nil {
-			log.Debug().Int("bucketId", id).Str("path", syncBucket).Msg("no response for retrieving bucket from consul")
-			return apperr.New(fmt.Sprintf("cannot get bucket %q from consul kv path %q", id, syncBucket), ErrInvalidInfo, op)
-		}
-
-		bucket := Bucket{}
-		err = json.Unmarshal(res.Value, &bucket)
-		if err != nil {
-			log.Debug().Err(err).Int("bucketId", id).Str("path", syncBucket).Msg("cannot unmarshall bucket from consul")
-			return apperr.New(fmt.Sprintf("cannot unmarshal bucket %q from consul kv path %q", id, syncBucket), err, op, ErrInvalidInfo)
-		}
-		i.buckets[id] = bucket
-	}
-
-	return nil
+	return InfoFromStore(s, i)
 }
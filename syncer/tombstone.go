@@ -0,0 +1,135 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ExpediaGroup/vsync/apperr"
+	"github.com/ExpediaGroup/vsync/vault"
+	"github.com/rs/zerolog/log"
+)
+
+// Tombstones makes origin record deleted paths as tombstones, set once before origin sync starts
+var Tombstones = false
+
+// TombstoneTTL is how long origin keeps a tombstone, then it is dropped from sync info
+var TombstoneTTL = 720 * time.Hour
+
+// Kinds of delete recorded in tombstones
+const (
+	// DeletedSoft is current version deleted, it can be undeleted
+	DeletedSoft = "delete"
+	// DeletedDestroy is current version destroyed
+	DeletedDestroy = "destroy"
+	// DeletedMetadata is path removed with all its versions
+	DeletedMetadata = "metadata"
+)
+
+// SetTombstones makes origin record deleted paths as tombstones, then destinations delete only on a tombstone
+func (i *Info) SetTombstones(enabled bool) {
+	i.rw.Lock()
+	defer i.rw.Unlock()
+
+	i.header.Tombstones = enabled
+}
+
+func (i *Info) tombstones() bool {
+	i.rw.RLock()
+	defer i.rw.RUnlock()
+
+	return i.header.Tombstones
+}
+
+// PathExists checks if a kv v2 path in info still has metadata in vault
+type PathExists func(path string) (bool, error)
+
+// VaultPathExists reads metadata of path in vault, nil metadata means path was removed with all its versions
+// kv v1 has no metadata, its path itself is read
+func VaultPathExists(v *vault.Client) PathExists {
+	return func(path string) (bool, error) {
+		if v.KVVersion(path) == 1 {
+			data, err := readKVData(v, path)
+			return data != nil, err
+		}
+		secret, err := v.Logical().Read(strings.Replace(path, "/data", "/metadata", 1))
+		if err != nil {
+			return false, err
+		}
+		return secret != nil, nil
+	}
+}
+
+// CarryTombstones brings paths of previous origin sync info missing in current into current
+// a missing path is tombstoned only if exists confirms it is gone, otherwise its previous insight is kept
+// so a walk which could not see some paths never looks like a delete
+// tombstones older than ttl are dropped, ttl 0 keeps them forever
+func CarryTombstones(current *Info, previous *Info, exists PathExists, now time.Time, ttl time.Duration) (int, []error) {
+	const op = apperr.Op("syncer.CarryTombstones")
+
+	missing := map[string]Insight{}
+	previous.rw.RLock()
+	for _, bucket := range previous.buckets {
+		for path, insight := range bucket {
+			missing[path] = insight
+		}
+	}
+	previous.rw.RUnlock()
+
+	current.rw.RLock()
+	for _, bucket := range current.buckets {
+		for path := range bucket {
+			delete(missing, path)
+		}
+	}
+	current.rw.RUnlock()
+
+	unknown := current.Header().Unknown
+	tombstoned := 0
+	errs := []error{}
+	for path, insight := range missing {
+		if isUnknown(unknown, path) {
+			// origin could not walk or read it in this cycle, last insight is kept as it is
+			log.Debug().Str("path", path).Msg("missing path is unknown, keeping its insight")
+		} else if insight.Deleted != "" {
+			deletionTime, err := time.Parse(time.RFC3339Nano, insight.DeletionTime)
+			if ttl > 0 && err == nil && now.Sub(deletionTime) > ttl {
+				log.Debug().Str("path", path).Str("deletionTime", insight.DeletionTime).Msg("tombstone expired")
+				continue
+			}
+		} else {
+			ok, err := exists(path)
+			if err != nil {
+				log.Debug().Err(err).Str("path", path).Msg("cannot check if missing path was removed, keeping its insight")
+				errs = append(errs, apperr.New(fmt.Sprintf("cannot check if missing path %q was removed", path), err, op, ErrInvalidPath))
+			}
+			if err == nil && !ok {
+				insight.Deleted = DeletedMetadata
+				insight.DeletionTime = now.UTC().Format(time.RFC3339Nano)
+				insight.Fingerprint = ""
+				insight.Metadata = ""
+				tombstoned++
+			}
+		}
+
+		if _, err := current.Put(path, insight); err != nil {
+			errs = append(errs, apperr.New(fmt.Sprintf("cannot carry path %q from previous sync info", path), err, op, ErrInvalidBucket))
+		}
+	}
+
+	return tombstoned, errs
+}
@@ -0,0 +1,127 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareTombstones(t *testing.T) {
+	live := Insight{Version: 2, UpdateTime: "2019-09-15T00:58:20.680948367Z", Type: "kvV2"}
+	tombstone := live
+	tombstone.Deleted = DeletedSoft
+	tombstone.DeletionTime = "2019-09-16T00:00:00Z"
+
+	origin := Bucket{
+		"secret/data/deleted":   tombstone,
+		"secret/data/gone":      tombstone,
+		"secret/data/recreated": live,
+	}
+	destination := Bucket{
+		"secret/data/deleted":   live,
+		"secret/data/recreated": tombstone,
+		"secret/data/unseen":    live,
+		"secret/data/expired":   tombstone,
+	}
+
+	add, update, del, errs := compareBuckets(origin, destination, compareOptions{tombstones: true})
+	assert.Empty(t, errs)
+	assert.Empty(t, update)
+	require.Len(t, add, 1)
+	assert.Equal(t, "secret/data/recreated", add[0].Path)
+
+	ops := map[string]string{}
+	for _, task := range del {
+		ops[task.Path] = task.Op
+	}
+	assert.Equal(t, map[string]string{
+		"secret/data/deleted": "delete",
+		"secret/data/gone":    "tombstone",
+		"secret/data/expired": "forget",
+	}, ops, "a path missing in origin without tombstone must never be deleted")
+
+	// origin without tombstones deletes missing paths like before
+	_, _, del, _ = compareBuckets(Bucket{}, Bucket{"secret/data/unseen": live}, compareOptions{})
+	require.Len(t, del, 1)
+	assert.Equal(t, "delete", del[0].Op)
+}
+
+func TestCarryTombstones(t *testing.T) {
+	now := time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC)
+	live := Insight{Version: 1, UpdateTime: "2019-09-15T00:58:20.680948367Z", Type: "kvV2"}
+	old := live
+	old.Deleted = DeletedDestroy
+	old.DeletionTime = now.Add(-48 * time.Hour).Format(time.RFC3339Nano)
+	recent := old
+	recent.DeletionTime = now.Add(-time.Hour).Format(time.RFC3339Nano)
+
+	previous, err := NewInfo(3)
+	require.NoError(t, err)
+	for path, insight := range map[string]Insight{
+		"secret/data/kept":    live,
+		"secret/data/removed": live,
+		"secret/data/unknown": live,
+		"secret/data/old":     old,
+		"secret/data/recent":  recent,
+	} {
+		_, err = previous.Put(path, insight)
+		require.NoError(t, err)
+	}
+
+	current, err := NewInfo(3)
+	require.NoError(t, err)
+	_, err = current.Put("secret/data/kept", live)
+	require.NoError(t, err)
+
+	exists := func(path string) (bool, error) {
+		switch path {
+		case "secret/data/removed":
+			return false, nil
+		case "secret/data/unknown":
+			return false, fmt.Errorf("permission denied")
+		}
+		return true, nil
+	}
+	tombstoned, errs := CarryTombstones(current, previous, exists, now, 24*time.Hour)
+	assert.Equal(t, 1, tombstoned)
+	assert.Len(t, errs, 1)
+
+	insight, ok, err := current.Get("secret/data/removed")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, DeletedMetadata, insight.Deleted)
+
+	insight, ok, err = current.Get("secret/data/unknown")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, live, insight, "path which cannot be checked keeps its insight")
+
+	_, ok, err = current.Get("secret/data/recent")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	_, ok, err = current.Get("secret/data/old")
+	require.NoError(t, err)
+	assert.False(t, ok, "expired tombstone must be dropped")
+
+	header := current.Header()
+	assert.Equal(t, 2, header.NumPaths)
+	assert.Equal(t, 2, header.NumTombstones)
+	assert.Equal(t, 7, header.minFormatVersion())
+}
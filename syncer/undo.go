@@ -0,0 +1,396 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ExpediaGroup/vsync/apperr"
+	"github.com/ExpediaGroup/vsync/vault"
+)
+
+var ErrNoJournal = fmt.Errorf("no journal of cycle")
+var ErrNotUndoable = fmt.Errorf("change cannot be undone")
+
+// JournalCycles is how many journals of destination cycles are kept for undo, 0 turns journals off
+// set once before destination sync starts
+var JournalCycles = 10
+
+// keys in destination store next to sync info, a journal per cycle and paths undone by an operator
+const (
+	journalKeyPrefix = "journal-"
+	undoneKey        = "undone"
+)
+
+// actions of journal entries, deletes are recorded with the kind of delete mirrored
+const (
+	JournalWrite    = "write"
+	JournalUndelete = "undelete"
+)
+
+// JournalEntry is a destination path changed by vsync in a cycle and its state before the change
+type JournalEntry struct {
+	// Path is destination path, OriginPath and Insight are of the task which changed it
+	Path       string  `json:"path"`
+	OriginPath string  `json:"originPath,omitempty"`
+	Insight    Insight `json:"insight"`
+	// PriorInsight is insight of origin path in destination sync info before the change, nil when it had none
+	PriorInsight *Insight `json:"priorInsight,omitempty"`
+	// Prune is a strict mirror prune of a destination path without origin path
+	Prune bool `json:"prune,omitempty"`
+	// Action is write, undelete or kind of delete done in destination
+	Action string `json:"action"`
+	// PriorVersion is current version before the change, 0 when path did not exist
+	PriorVersion int64 `json:"priorVersion"`
+	PriorDeleted bool  `json:"priorDeleted,omitempty"`
+	// Version is current version after the change
+	Version int64 `json:"version"`
+}
+
+// Journal records kv v2 destination paths written or deleted in a cycle, safe for workers
+// nil journal records nothing
+type Journal struct {
+	Cycle   string         `json:"cycle"`
+	Entries []JournalEntry `json:"entries"`
+	rw      sync.Mutex
+}
+
+// NewJournal of cycle started at now, cycle id is its time in UTC
+func NewJournal(now time.Time) *Journal {
+	return &Journal{
+		Cycle:   now.UTC().Format("20060102T150405Z"),
+		Entries: []JournalEntry{},
+	}
+}
+
+// Len is number of entries recorded
+func (j *Journal) Len() int {
+	if j == nil {
+		return 0
+	}
+	j.rw.Lock()
+	defer j.rw.Unlock()
+	return len(j.Entries)
+}
+
+func (j *Journal) record(e JournalEntry) {
+	if j == nil {
+		return
+	}
+	j.rw.Lock()
+	defer j.rw.Unlock()
+	j.Entries = append(j.Entries, e)
+}
+
+// prior reads current version of kv v2 destination path before vsync changes it
+// false when journal is off, path is kv v1 or its metadata cannot be read, then the change is not recorded
+func (j *Journal) prior(v *vault.Client, path string) (JournalEntry, bool) {
+	if j == nil || v.KVVersion(path) != 2 {
+		return JournalEntry{}, false
+	}
+	meta, _, err := readKVV2Meta(v, path)
+	if err != nil {
+		return JournalEntry{}, false
+	}
+	return JournalEntry{
+		Path:         path,
+		PriorVersion: meta.CurrentVersion,
+		PriorDeleted: meta.CurrentDeletionTime != "" || meta.Destroyed,
+	}, true
+}
+
+// change records entry of prior with what task did, before the task saves its insight in info
+// info is nil for prunes, they have no origin path in sync info
+func (j *Journal) change(entry JournalEntry, info *Info, task Task, action string, version int64) {
+	if j == nil {
+		return
+	}
+	entry.Insight, entry.Action, entry.Version = task.Insight, action, version
+	if info == nil {
+		entry.Prune = true
+	} else {
+		entry.OriginPath = task.Path
+		if insight, ok, err := info.Get(task.Path); err == nil && ok {
+			entry.PriorInsight = &insight
+		}
+	}
+	j.record(entry)
+}
+
+// JournalToStore saves journal of a cycle and removes journals older than the latest keep cycles
+func JournalToStore(s Store, j *Journal, keep int) error {
+	const op = apperr.Op("syncer.JournalToStore")
+
+	j.rw.Lock()
+	value, err := json.Marshal(j)
+	j.rw.Unlock()
+	if err != nil {
+		return apperr.New(fmt.Sprintf("cannot marshal journal of cycle %q", j.Cycle), err, op, ErrInvalidStore)
+	}
+	if err := s.Put(journalKeyPrefix+j.Cycle, value); err != nil {
+		return apperr.New(fmt.Sprintf("cannot save journal of cycle %q in store %q", j.Cycle, s), err, op, ErrInvalidStore)
+	}
+
+	cycles, err := Journals(s)
+	if err != nil {
+		return err
+	}
+	for len(cycles) > keep {
+		if err := s.Delete(journalKeyPrefix + cycles[0]); err != nil {
+			return apperr.New(fmt.Sprintf("cannot delete journal of cycle %q in store %q", cycles[0], s), err, op, ErrInvalidStore)
+		}
+		cycles = cycles[1:]
+	}
+	return nil
+}
+
+// Journals lists cycles with a journal in store, oldest first
+func Journals(s Store) ([]string, error) {
+	const op = apperr.Op("syncer.Journals")
+
+	keys, err := s.Keys()
+	if err != nil {
+		return nil, apperr.New(fmt.Sprintf("cannot list keys in store %q", s), err, op, ErrInvalidStore)
+	}
+	cycles := []string{}
+	for _, key := range keys {
+		if strings.HasPrefix(key, journalKeyPrefix) {
+			cycles = append(cycles, strings.TrimPrefix(key, journalKeyPrefix))
+		}
+	}
+	sort.Strings(cycles)
+	return cycles, nil
+}
+
+// JournalFromStore reads journal of cycle, ErrNoJournal when store has none
+func JournalFromStore(s Store, cycle string) (*Journal, error) {
+	const op = apperr.Op("syncer.JournalFromStore")
+
+	value, err := s.Get(journalKeyPrefix + cycle)
+	if err != nil {
+		return nil, apperr.New(fmt.Sprintf("cannot get journal of cycle %q from store %q", cycle, s), err, op, ErrInvalidStore)
+	}
+	if value == nil {
+		return nil, apperr.New(fmt.Sprintf("cycle %q has no journal in store %q", cycle, s), ErrNoJournal, op)
+	}
+	j := &Journal{}
+	if err := json.Unmarshal(value, j); err != nil {
+		return nil, apperr.New(fmt.Sprintf("cannot unmarshal journal of cycle %q from store %q", cycle, s), err, op, ErrInvalidStore)
+	}
+	return j, nil
+}
+
+// Undo puts each destination path in journal back to its version before the cycle, last change first
+// a write is rolled back by writing prior version again as a new version, or soft deleting when there was none
+// an undelete is deleted again and a soft delete undeleted, destroys and metadata deletes cannot be undone
+// paths changed again since the cycle are ErrSecretConflict and left as they are
+// returns entries undone and errors of the rest
+func Undo(v *vault.Client, j *Journal) ([]JournalEntry, []error) {
+	undone := []JournalEntry{}
+	errs := []error{}
+	for i := len(j.Entries) - 1; i >= 0; i-- {
+		if err := undoEntry(v, j.Entries[i]); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		undone = append(undone, j.Entries[i])
+	}
+	return undone, errs
+}
+
+func undoEntry(v *vault.Client, e JournalEntry) error {
+	const op = apperr.Op("syncer.undoEntry")
+
+	if v.KVVersion(e.Path) != 2 {
+		return apperr.New(fmt.Sprintf("path %q is not in a kv v2 mount", e.Path), ErrNotUndoable, op)
+	}
+	if e.Action != JournalWrite && e.Action != JournalUndelete && e.Action != DeletedSoft {
+		return apperr.New(fmt.Sprintf("%s of path %q cannot be undone, restore it from quarantine", e.Action, e.Path), ErrNotUndoable, op)
+	}
+
+	meta, ok, err := readKVV2Meta(v, e.Path)
+	if err != nil {
+		return err
+	}
+	deleted := meta.CurrentDeletionTime != "" || meta.Destroyed
+	changed := !ok || meta.CurrentVersion != e.Version
+	switch e.Action {
+	case JournalWrite, JournalUndelete:
+		changed = changed || deleted
+	case DeletedSoft:
+		changed = changed || !deleted
+	}
+	if changed {
+		return apperr.New(fmt.Sprintf("path %q changed since cycle, version %d is not version %d left by vsync", e.Path, meta.CurrentVersion, e.Version), ErrSecretConflict, op)
+	}
+
+	switch {
+	case e.Action == DeletedSoft:
+		_, err = mirrorDelete(v, e.Path, Undelete)
+	case e.Action == JournalUndelete || e.PriorVersion == 0 || e.PriorDeleted:
+		// path had no live data before the cycle
+		_, err = mirrorDelete(v, e.Path, DeletedSoft)
+	default:
+		err = rollback(v, e.Path, e.PriorVersion, e.Version)
+	}
+	if err != nil {
+		return apperr.New(fmt.Sprintf("cannot undo %s of path %q", e.Action, e.Path), err, op, ErrInvalidPath)
+	}
+	return nil
+}
+
+// rollback writes data of version prior as a new version with check-and-set on current version
+// vsync version moves to the new version, so next sync does not see a conflict
+func rollback(v *vault.Client, path string, prior int64, current int64) error {
+	const op = apperr.Op("syncer.rollback")
+
+	secret, err := readVersion(v, path, prior)
+	if err != nil {
+		return err
+	}
+	data, err := secretData(secret)
+	if err != nil {
+		return apperr.New(fmt.Sprintf("cannot get data of version %d of path %q", prior, path), err, op, ErrInvalidPath)
+	}
+	if err := writeKVData(v, path, data, current); err != nil {
+		return err
+	}
+
+	meta, _, err := readKVV2Meta(v, path)
+	if err != nil {
+		return err
+	}
+	custom := map[string]interface{}{}
+	for key, value := range meta.Custom {
+		custom[key] = value
+	}
+	custom[VersionKey] = strconv.FormatInt(current+1, 10)
+	metaPath := strings.Replace(path, "/data/", "/metadata/", 1)
+	if _, err := v.Logical().Write(metaPath, map[string]interface{}{"custom_metadata": custom}); err != nil {
+		return apperr.New(fmt.Sprintf("cannot save vsync version in metadata for path %q", metaPath), err, op, ErrInvalidPath)
+	}
+	return nil
+}
+
+// UndoneMark keeps a change undone by an operator from being synced again
+type UndoneMark struct {
+	Cycle   string  `json:"cycle"`
+	Delete  bool    `json:"delete,omitempty"`
+	Insight Insight `json:"insight"`
+}
+
+// RestoreInsights puts insights of undone entries in destination sync info back as they were before the cycle
+// sync info then describes destination vault again, tasks making the undone change again are dropped by FilterUndone
+func RestoreInsights(i *Info, undone []JournalEntry) error {
+	for _, e := range undone {
+		if e.Prune {
+			continue
+		}
+		var err error
+		if e.PriorInsight != nil {
+			_, err = i.Put(e.OriginPath, *e.PriorInsight)
+		} else {
+			_, err = i.Delete(e.OriginPath)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Mark adds marks for undone entries, by origin path or by destination path for prunes
+func Mark(marks map[string]UndoneMark, cycle string, undone []JournalEntry) {
+	for _, e := range undone {
+		key := e.OriginPath
+		if e.Prune {
+			key = e.Path
+		}
+		marks[key] = UndoneMark{
+			Cycle:   cycle,
+			Delete:  e.Action != JournalWrite && e.Action != JournalUndelete,
+			Insight: e.Insight,
+		}
+	}
+}
+
+// FilterUndone drops add, update, undelete, delete and prune tasks making a change again which was undone
+// a task with another insight means origin moved on, then its mark is removed and the task kept
+// returns kept tasks and true when marks changed
+func FilterUndone(marks map[string]UndoneMark, tasks []Task) ([]Task, bool) {
+	if len(marks) == 0 {
+		return tasks, false
+	}
+	kept := []Task{}
+	changed := false
+	for _, task := range tasks {
+		mark, ok := marks[task.Path]
+		if !ok || task.Op == "metadata" || task.Op == "tombstone" || task.Op == "forget" {
+			kept = append(kept, task)
+			continue
+		}
+		if mark.Delete == (task.Op == "delete" || task.Op == "prune") && mark.Insight == task.Insight {
+			continue
+		}
+		delete(marks, task.Path)
+		changed = true
+		kept = append(kept, task)
+	}
+	return kept, changed
+}
+
+// MarksFromStore reads marks of undone changes, empty when there are none
+func MarksFromStore(s Store) (map[string]UndoneMark, error) {
+	const op = apperr.Op("syncer.MarksFromStore")
+
+	marks := map[string]UndoneMark{}
+	value, err := s.Get(undoneKey)
+	if err != nil {
+		return marks, apperr.New(fmt.Sprintf("cannot get undone marks from store %q", s), err, op, ErrInvalidStore)
+	}
+	if value == nil {
+		return marks, nil
+	}
+	if err := json.Unmarshal(value, &marks); err != nil {
+		return marks, apperr.New(fmt.Sprintf("cannot unmarshal undone marks from store %q", s), err, op, ErrInvalidStore)
+	}
+	return marks, nil
+}
+
+// MarksToStore saves marks of undone changes, no marks removes the key
+func MarksToStore(s Store, marks map[string]UndoneMark) error {
+	const op = apperr.Op("syncer.MarksToStore")
+
+	if len(marks) == 0 {
+		if err := s.Delete(undoneKey); err != nil {
+			return apperr.New(fmt.Sprintf("cannot delete undone marks in store %q", s), err, op, ErrInvalidStore)
+		}
+		return nil
+	}
+	value, err := json.Marshal(marks)
+	if err != nil {
+		return apperr.New(fmt.Sprintf("cannot marshal undone marks"), err, op, ErrInvalidStore)
+	}
+	if err := s.Put(undoneKey, value); err != nil {
+		return apperr.New(fmt.Sprintf("cannot save undone marks in store %q", s), err, op, ErrInvalidStore)
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ExpediaGroup/vsync/transformer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUndo(t *testing.T) {
+	v, _ := memVault(t)
+	now := time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC)
+	journal := NewJournal(now)
+	assert.Equal(t, "20191001T000000Z", journal.Cycle)
+
+	require.NoError(t, writeKVData(v, "secret/data/app/db", map[string]interface{}{"password": "one"}, 0))
+	require.NoError(t, writeKVData(v, "secret/data/app/old", map[string]interface{}{"password": "old"}, 0))
+
+	// changes of a cycle as workers record them
+	change := func(path string, action string, do func() error) {
+		entry, ok := journal.prior(v, path)
+		require.True(t, ok)
+		require.NoError(t, do())
+		entry.OriginPath, entry.Action, entry.Version = path, action, entry.PriorVersion
+		if action == JournalWrite {
+			entry.Version++
+		}
+		entry.Insight = Insight{Version: entry.Version, Type: "kvV2"}
+		journal.record(entry)
+	}
+	change("secret/data/app/db", JournalWrite, func() error {
+		return writeKVData(v, "secret/data/app/db", map[string]interface{}{"password": "bad"}, 1)
+	})
+	change("secret/data/app/new", JournalWrite, func() error {
+		return writeKVData(v, "secret/data/app/new", map[string]interface{}{"password": "new"}, 0)
+	})
+	change("secret/data/app/old", DeletedSoft, func() error {
+		_, err := mirrorDelete(v, "secret/data/app/old", DeletedSoft)
+		return err
+	})
+	journal.record(JournalEntry{Path: "secret/data/app/gone", OriginPath: "secret/data/app/gone", Action: DeletedDestroy, Version: 1})
+	assert.Equal(t, 4, journal.Len())
+
+	dir, err := ioutil.TempDir("", "vsync")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	s, err := NewFileStore(dir, "vsync/destination/")
+	require.NoError(t, err)
+	require.NoError(t, JournalToStore(s, NewJournal(now.Add(-time.Minute)), 1))
+	require.NoError(t, JournalToStore(s, journal, 1))
+	cycles, err := Journals(s)
+	require.NoError(t, err)
+	assert.Equal(t, []string{journal.Cycle}, cycles, "older journals beyond keep are removed")
+
+	saved, err := JournalFromStore(s, journal.Cycle)
+	require.NoError(t, err)
+	_, err = JournalFromStore(s, "other")
+	assert.True(t, errors.Is(err, ErrNoJournal))
+
+	undone, errs := Undo(v, saved)
+	require.Len(t, errs, 1)
+	assert.True(t, errors.Is(errs[0], ErrNotUndoable), "destroy cannot be undone")
+	assert.Len(t, undone, 3)
+
+	data, err := readKVData(v, "secret/data/app/db")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"password": "one"}, data, "write rolled back to prior version")
+	meta, _, err := readKVV2Meta(v, "secret/data/app/db")
+	require.NoError(t, err)
+	assert.Equal(t, "3", meta.Custom[VersionKey])
+	data, err = readKVData(v, "secret/data/app/new")
+	require.NoError(t, err)
+	assert.Nil(t, data, "new path deleted again")
+	data, err = readKVData(v, "secret/data/app/old")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"password": "old"}, data, "soft delete undeleted")
+
+	// undone paths moved on since the cycle
+	_, errs = Undo(v, saved)
+	require.Len(t, errs, 4)
+	assert.True(t, errors.Is(errs[1], ErrSecretConflict))
+
+	marks := map[string]UndoneMark{}
+	Mark(marks, saved.Cycle, undone)
+	require.NoError(t, MarksToStore(s, marks))
+	marks, err = MarksFromStore(s)
+	require.NoError(t, err)
+	require.Len(t, marks, 3)
+
+	tasks := []Task{
+		{Path: "secret/data/app/db", Op: "update", Insight: Insight{Version: 2, Type: "kvV2"}},
+		{Path: "secret/data/app/new", Op: "add", Insight: Insight{Version: 2, Type: "kvV2"}},
+		{Path: "secret/data/app/old", Op: "delete", Insight: Insight{Version: 1, Type: "kvV2"}},
+		{Path: "secret/data/app/other", Op: "add", Insight: Insight{Version: 1, Type: "kvV2"}},
+	}
+	kept, changed := FilterUndone(marks, tasks)
+	assert.True(t, changed)
+	assert.Equal(t, tasks[1:2:2], kept[:1], "origin moved on from undone version")
+	assert.Equal(t, tasks[3], kept[1])
+	assert.Len(t, kept, 2)
+	assert.Len(t, marks, 2)
+
+	// strict mirror prunes have no origin path, marked by destination path
+	prune := Task{Path: "secret/data/stray", Op: "prune", Insight: Insight{Deleted: DeletedSoft}}
+	Mark(marks, saved.Cycle, []JournalEntry{{Path: prune.Path, Insight: prune.Insight, Action: DeletedSoft, Prune: true}})
+	kept, _ = FilterUndone(marks, []Task{prune})
+	assert.Empty(t, kept)
+}
+
+func TestUndoThenSync(t *testing.T) {
+	origin, _ := memVault(t)
+	destination, _ := memVault(t)
+	pack := transformer.Pack{transformer.NewNilTransformer()}
+	path := "secret/data/app/db"
+
+	// compare, filter undone and fetch and save of one destination cycle
+	cycle := func(originfo *Info, destinationInfo *Info, marks map[string]UndoneMark, journal *Journal) []Task {
+		require.NoError(t, originfo.Reindex())
+		require.NoError(t, destinationInfo.Reindex())
+		addTasks, updateTasks, deleteTasks, errs := originfo.Compare(destinationInfo)
+		require.Empty(t, errs)
+		tasks, _ := FilterUndone(marks, append(append(addTasks, updateTasks...), deleteTasks...))
+
+		taskCh := make(chan Task, len(tasks))
+		errCh := make(chan error, len(tasks))
+		for _, task := range tasks {
+			taskCh <- task
+		}
+		close(taskCh)
+		var wg sync.WaitGroup
+		wg.Add(1)
+		FetchAndSave(context.Background(), &wg, 0, origin, destination, destinationInfo, pack, "origin", journal, taskCh, errCh)
+		wg.Wait()
+		close(errCh)
+		for err := range errCh {
+			require.NoError(t, err)
+		}
+		return tasks
+	}
+
+	require.NoError(t, writeKVData(origin, path, map[string]interface{}{"password": "one"}, 0))
+	originfo, err := NewInfo(1)
+	require.NoError(t, err)
+	destinationInfo, err := NewInfo(1)
+	require.NoError(t, err)
+	_, err = originfo.Put(path, Insight{Version: 1, Type: "kvV2"})
+	require.NoError(t, err)
+	assert.Len(t, cycle(originfo, destinationInfo, nil, nil), 1)
+
+	// bad change synced and undone
+	require.NoError(t, writeKVData(origin, path, map[string]interface{}{"password": "bad"}, 1))
+	_, err = originfo.Put(path, Insight{Version: 2, Type: "kvV2"})
+	require.NoError(t, err)
+	journal := NewJournal(time.Now())
+	assert.Len(t, cycle(originfo, destinationInfo, nil, journal), 1)
+	require.Equal(t, 1, journal.Len())
+	require.NotNil(t, journal.Entries[0].PriorInsight)
+
+	undone, errs := Undo(destination, journal)
+	require.Empty(t, errs)
+	require.NoError(t, RestoreInsights(destinationInfo, undone))
+	insight, ok, err := destinationInfo.Get(path)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, int64(1), insight.Version, "sync info describes destination before the cycle")
+	marks := map[string]UndoneMark{}
+	Mark(marks, journal.Cycle, undone)
+
+	// next cycle does not sync the undone change again
+	assert.Empty(t, cycle(originfo, destinationInfo, marks, NewJournal(time.Now())))
+	data, err := readKVData(destination, path)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"password": "one"}, data)
+
+	// origin fixes the path, it flows again
+	require.NoError(t, writeKVData(origin, path, map[string]interface{}{"password": "fixed"}, 2))
+	_, err = originfo.Put(path, Insight{Version: 3, Type: "kvV2"})
+	require.NoError(t, err)
+	assert.Len(t, cycle(originfo, destinationInfo, marks, NewJournal(time.Now())), 1)
+	assert.Empty(t, marks)
+	data, err = readKVData(destination, path)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"password": "fixed"}, data)
+	assert.Empty(t, cycle(originfo, destinationInfo, marks, nil), "destination in sync with origin")
+}
@@ -0,0 +1,81 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"strings"
+
+	"github.com/ExpediaGroup/vsync/vault"
+)
+
+// MarkUnknown records a data path origin could not read, or a subtree ending with / it could not list, in header
+func (i *Info) MarkUnknown(path string) {
+	i.rw.Lock()
+	defer i.rw.Unlock()
+
+	for _, p := range i.header.Unknown {
+		if p == path {
+			return
+		}
+	}
+	i.header.Unknown = append(i.header.Unknown, path)
+}
+
+// UnknownSubtree is data path of subtree under a path origin walk could not list
+func UnknownSubtree(v *vault.Client, listed string) string {
+	return DataPaths(v, []string{strings.TrimSuffix(listed, "/") + "/"})[0]
+}
+
+// isUnknown is true for unknown paths and paths under unknown subtrees
+func isUnknown(unknown []string, path string) bool {
+	for _, p := range unknown {
+		if p == path || (strings.HasSuffix(p, "/") && strings.HasPrefix(path, p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// LiveUnknown counts live paths of sync info which are unknown paths or under unknown subtrees, buckets must be read
+func (i *Info) LiveUnknown(unknown []string) int {
+	i.rw.RLock()
+	defer i.rw.RUnlock()
+
+	count := 0
+	for _, bucket := range i.buckets {
+		for path, insight := range bucket {
+			if insight.Deleted == "" && isUnknown(unknown, path) {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// CountUnknown returns number of unknown subtrees and paths under mount
+func CountUnknown(unknown []string, mount string) (int, int) {
+	subtrees, paths := 0, 0
+	for _, p := range unknown {
+		if !strings.HasPrefix(p, mount) {
+			continue
+		}
+		if strings.HasSuffix(p, "/") {
+			subtrees++
+		} else {
+			paths++
+		}
+	}
+	return subtrees, paths
+}
@@ -0,0 +1,68 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareUnknown(t *testing.T) {
+	live := Insight{Version: 2, UpdateTime: "2019-09-15T00:58:20.680948367Z", Type: "kvV2"}
+	tombstone := live
+	tombstone.Deleted = DeletedSoft
+	tombstone.DeletionTime = "2019-09-16T00:00:00Z"
+
+	destination := Bucket{
+		"secret/data/team/a":  live,
+		"secret/data/team/b":  tombstone,
+		"secret/data/locked":  live,
+		"secret/data/removed": live,
+	}
+	options := compareOptions{unknown: []string{"secret/data/team/", "secret/data/locked"}}
+
+	_, _, del, errs := compareBuckets(Bucket{}, destination, options)
+	assert.Empty(t, errs)
+	require.Len(t, del, 1, "paths under unknown subtrees and unknown paths are neither deleted nor forgotten")
+	assert.Equal(t, "secret/data/removed", del[0].Path)
+
+	options.tombstones = true
+	_, _, del, _ = compareBuckets(Bucket{}, destination, options)
+	assert.Empty(t, del)
+}
+
+func TestMarkUnknown(t *testing.T) {
+	info, err := NewInfo(3)
+	require.NoError(t, err)
+	assert.True(t, info.Header().minFormatVersion() < 9)
+
+	info.MarkUnknown("secret/data/team/")
+	info.MarkUnknown("secret/data/team/")
+	info.MarkUnknown("kv/locked")
+	header := info.Header()
+	assert.Equal(t, []string{"secret/data/team/", "kv/locked"}, header.Unknown)
+	assert.Equal(t, 9, header.minFormatVersion(), "readers before format 9 would delete unknown paths")
+
+	assert.True(t, isUnknown(header.Unknown, "secret/data/team/a/b"))
+	assert.False(t, isUnknown(header.Unknown, "secret/data/teams"))
+	assert.True(t, isUnknown(header.Unknown, "kv/locked"))
+	assert.False(t, isUnknown(header.Unknown, "kv/locked/a"))
+
+	subtrees, paths := CountUnknown(header.Unknown, "secret/")
+	assert.Equal(t, 1, subtrees)
+	assert.Equal(t, 0, paths)
+}
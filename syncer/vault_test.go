@@ -0,0 +1,308 @@
+// Copyright 2019 Expedia, Inc.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+
+//     http://www.apache.org/licenses/LICENSE-2.0
+
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syncer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ExpediaGroup/vsync/vault"
+	"github.com/stretchr/testify/require"
+)
+
+// memSecret is a kv v2 secret of fakeVault
+type memSecret struct {
+	versions  map[int64]map[string]interface{}
+	deleted   map[int64]bool
+	destroyed map[int64]bool
+	current   int64
+	custom    map[string]interface{}
+}
+
+// fakeVault keeps kv mounts in memory, every test vault is a fixture built on it
+// kv v2 secrets have versions, check-and-set, soft deletes, destroys, undeletes, custom metadata and listing, kv v1 secrets are plain data
+// secrets are keyed by mount and path without data/ or metadata/, like "secret/app" for "secret/data/app"
+type fakeVault struct {
+	mounts  map[string]int
+	secrets map[string]*memSecret
+	plain   map[string]map[string]interface{}
+	// body of every write by request path
+	written map[string]map[string]interface{}
+}
+
+// newFakeVault serves kv mounts of given versions, like "secret/": 2, and passes their mount checks
+func newFakeVault(t *testing.T, mounts map[string]int) (*vault.Client, *fakeVault) {
+	f := &fakeVault{
+		mounts:  mounts,
+		secrets: map[string]*memSecret{},
+		plain:   map[string]map[string]interface{}{},
+		written: map[string]map[string]interface{}{},
+	}
+	var mu sync.Mutex
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+
+		body := map[string]interface{}{}
+		if r.Method == http.MethodPut || r.Method == http.MethodPost {
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		}
+
+		path := strings.TrimPrefix(r.URL.Path, "/v1/")
+		switch path {
+		case "sys/mounts":
+			data := map[string]interface{}{}
+			for mount, version := range f.mounts {
+				data[mount] = map[string]interface{}{"type": "kv", "options": map[string]interface{}{"version": fmt.Sprint(version)}}
+			}
+			require.NoError(t, json.NewEncoder(w).Encode(map[string]interface{}{"data": data}))
+			return
+		case "sys/capabilities-self":
+			fmt.Fprintf(w, `{"data":{%q:["create","read","update","delete","list"]}}`, body["path"])
+			return
+		}
+		if r.Method == http.MethodPut || r.Method == http.MethodPost {
+			f.written[r.URL.Path] = body
+		}
+
+		parts := strings.SplitN(path, "/", 2)
+		if len(parts) < 2 {
+			parts = append(parts, "")
+		}
+		if f.mounts[parts[0]+"/"] == 1 {
+			f.serveKVV1(t, w, r, parts[0], parts[1], body)
+			return
+		}
+		f.serveKVV2(t, w, r, path, body)
+	}))
+	t.Cleanup(server.Close)
+
+	v, err := vault.NewClient(server.URL, "token", "", "", "")
+	require.NoError(t, err)
+	for mount := range mounts {
+		require.NoError(t, v.MountChecks(mount, vault.CheckDestination, "test"))
+	}
+	return v, f
+}
+
+// secret saves version of kv v2 secret as current version, like "secret/app"
+func (f *fakeVault) secret(name string, version int64, data map[string]interface{}, custom map[string]interface{}) {
+	s := f.secrets[name]
+	if s == nil {
+		s = &memSecret{versions: map[int64]map[string]interface{}{}, deleted: map[int64]bool{}, destroyed: map[int64]bool{}}
+		f.secrets[name] = s
+	}
+	s.versions[version] = data
+	s.current = version
+	s.custom = custom
+}
+
+func notFound(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusNotFound)
+	fmt.Fprint(w, `{"errors":[]}`)
+}
+
+// list answers keys directly under prefix, sub folders end with /
+func list(t *testing.T, w http.ResponseWriter, names []string, prefix string) {
+	keys := map[string]bool{}
+	for _, n := range names {
+		if rest := strings.TrimPrefix(n, prefix); rest != n {
+			if i := strings.Index(rest, "/"); i >= 0 {
+				rest = rest[:i+1]
+			}
+			keys[rest] = true
+		}
+	}
+	if len(keys) == 0 {
+		notFound(w)
+		return
+	}
+	sorted := []string{}
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+	require.NoError(t, json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"keys": sorted}}))
+}
+
+func (f *fakeVault) serveKVV1(t *testing.T, w http.ResponseWriter, r *http.Request, mount string, key string, body map[string]interface{}) {
+	name := mount + "/" + key
+	switch {
+	case r.Method == http.MethodGet && r.URL.Query().Get("list") == "true":
+		names := []string{}
+		for n := range f.plain {
+			names = append(names, n)
+		}
+		prefix := strings.TrimSuffix(name, "/") + "/"
+		list(t, w, names, prefix)
+	case r.Method == http.MethodGet:
+		if f.plain[name] == nil {
+			notFound(w)
+			return
+		}
+		require.NoError(t, json.NewEncoder(w).Encode(map[string]interface{}{"data": f.plain[name]}))
+	case r.Method == http.MethodPut || r.Method == http.MethodPost:
+		f.plain[name] = body
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete:
+		delete(f.plain, name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		notFound(w)
+	}
+}
+
+func (f *fakeVault) serveKVV2(t *testing.T, w http.ResponseWriter, r *http.Request, path string, body map[string]interface{}) {
+	parts := strings.SplitN(path, "/", 3)
+	for len(parts) < 3 {
+		parts = append(parts, "")
+	}
+	mount, kind, key := parts[0], parts[1], parts[2]
+	name := mount + "/" + key
+	s := f.secrets[name]
+
+	write := func(v interface{}) {
+		require.NoError(t, json.NewEncoder(w).Encode(map[string]interface{}{"data": v}))
+	}
+	create := func() *memSecret {
+		if s == nil {
+			s = &memSecret{versions: map[int64]map[string]interface{}{}, deleted: map[int64]bool{}, destroyed: map[int64]bool{}}
+			f.secrets[name] = s
+		}
+		return s
+	}
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Query().Get("list") == "true":
+		names := []string{}
+		for n := range f.secrets {
+			names = append(names, n)
+		}
+		prefix := name + "/"
+		if key == "" {
+			prefix = mount + "/"
+		}
+		list(t, w, names, prefix)
+	case r.Method == http.MethodGet && kind == "data":
+		if s == nil {
+			notFound(w)
+			return
+		}
+		n := s.current
+		if version := r.URL.Query().Get("version"); version != "" {
+			fmt.Sscan(version, &n)
+		}
+		if s.deleted[n] || s.versions[n] == nil {
+			notFound(w)
+			return
+		}
+		write(map[string]interface{}{"data": s.versions[n], "metadata": map[string]interface{}{"version": n}})
+	case r.Method == http.MethodGet && kind == "metadata":
+		if s == nil {
+			notFound(w)
+			return
+		}
+		versions := map[string]interface{}{}
+		for n := range s.versions {
+			deletion := ""
+			if s.deleted[n] {
+				deletion = "2019-09-16T00:00:00Z"
+			}
+			versions[fmt.Sprint(n)] = map[string]interface{}{"deletion_time": deletion, "destroyed": s.destroyed[n]}
+		}
+		write(map[string]interface{}{"current_version": s.current, "max_versions": 0, "cas_required": false, "delete_version_after": "0s", "custom_metadata": s.custom, "versions": versions})
+	case (r.Method == http.MethodPut || r.Method == http.MethodPost) && kind == "data":
+		current := int64(0)
+		if s != nil {
+			current = s.current
+		}
+		if options, ok := body["options"].(map[string]interface{}); ok {
+			if cas, ok := options["cas"].(float64); ok && int64(cas) != current {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"errors":["check-and-set parameter did not match the current version"]}`)
+				return
+			}
+		}
+		data, _ := body["data"].(map[string]interface{})
+		s = create()
+		s.current++
+		s.versions[s.current] = data
+		write(map[string]interface{}{"version": s.current})
+	case (r.Method == http.MethodPut || r.Method == http.MethodPost) && kind == "metadata":
+		if custom, ok := body["custom_metadata"].(map[string]interface{}); ok {
+			create().custom = custom
+		} else {
+			create()
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case (r.Method == http.MethodPut || r.Method == http.MethodPost) && (kind == "undelete" || kind == "destroy"):
+		versions, _ := body["versions"].([]interface{})
+		for _, v := range versions {
+			n := int64(v.(float64))
+			switch {
+			case s == nil || s.destroyed[n]:
+			case kind == "undelete":
+				delete(s.deleted, n)
+			default:
+				s.destroyed[n] = true
+				s.versions[n] = nil
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete && kind == "data":
+		if s != nil {
+			s.deleted[s.current] = true
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete && kind == "metadata":
+		delete(f.secrets, name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		notFound(w)
+	}
+}
+
+// memVault has empty kv v2 mounts secret/ and archive/
+func memVault(t *testing.T) (*vault.Client, map[string]*memSecret) {
+	v, f := newFakeVault(t, map[string]int{"secret/": 2, "archive/": 2})
+	return v, f.secrets
+}
+
+// kvVault has kv v1 mount legacy/ and kv v2 mount secret/, both with app1 having password one, version 3 in kv v2
+func kvVault(t *testing.T, written map[string]map[string]interface{}) *vault.Client {
+	v, f := newFakeVault(t, map[string]int{"legacy/": 1, "secret/": 2})
+	f.written = written
+	f.plain["legacy/app1"] = map[string]interface{}{"password": "one"}
+	f.secret("secret/app1", 3, map[string]interface{}{"password": "one"}, nil)
+	return v
+}
+
+// conflictVault has kv v2 mount secret/ with edited (changed after vsync wrote version 4), synced (written by vsync) and legacy (never stamped)
+func conflictVault(t *testing.T, written map[string]map[string]interface{}) *vault.Client {
+	v, f := newFakeVault(t, map[string]int{"secret/": 2})
+	f.written = written
+	f.secret("secret/edited", 5, map[string]interface{}{"password": "local"}, map[string]interface{}{"team": "a", VersionKey: "4"})
+	f.secret("secret/synced", 3, map[string]interface{}{"password": "synced"}, map[string]interface{}{VersionKey: "3"})
+	f.secret("secret/legacy", 2, map[string]interface{}{"password": "legacy"}, nil)
+	return v
+}
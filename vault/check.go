@@ -47,12 +47,23 @@ func (v *Client) MountChecks(mPath string, checks int, name string) error {
 		return apperr.New(fmt.Sprintf("could not get mount in path %q, also check vault token permission for read+list on sys/mounts", mPath), err, op, apperr.Fatal, ErrInitialize)
 	}
 
-	// Currently we support only kv v2 for replication
-	if (m.Type == "kv" || m.Type == "generic") && m.Options["version"] == "2" {
+	// kv v1 has secrets right under mount, without data and metadata paths
+	switch {
+	case (m.Type == "kv" || m.Type == "generic") && m.Options["version"] == "2":
 		log.Debug().Interface("mount", m).Str("path", mPath).Msg("mount is of type kv v2")
-	} else {
-		log.Debug().Interface("mount", m).Str("path", mPath).Msg("mount is not of type kv v2")
-		return apperr.New(fmt.Sprintf("mount %q not a kv_v2", mPath), err, op, apperr.Fatal, ErrInitialize)
+		v.setKVVersion(mPath, 2)
+	case m.Type == "kv" || m.Type == "generic":
+		log.Debug().Interface("mount", m).Str("path", mPath).Msg("mount is of type kv v1")
+		err = v.CheckTokenPermissions(mPath, checks)
+		if err != nil {
+			return apperr.New(fmt.Sprintf("vault token missing permissions on kv v1 mount %q", mPath), err, op, apperr.Fatal, ErrInvalidToken)
+		}
+		log.Info().Str("path", mPath).Str("checks", fmt.Sprintf("%b", checks)).Msg("vault token has required capabilities on path")
+		v.setKVVersion(mPath, 1)
+		return nil
+	default:
+		log.Debug().Interface("mount", m).Str("path", mPath).Msg("mount is not of type kv")
+		return apperr.New(fmt.Sprintf("mount %q not a kv_v1 or kv_v2", mPath), err, op, apperr.Fatal, ErrInitialize)
 	}
 
 	// data path token permission checks
@@ -18,6 +18,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/vault/api"
@@ -40,6 +42,10 @@ type Client struct {
 	*api.Client
 	Address string
 	Mode string
+
+	// kv version of mounts which passed mount checks
+	rw         sync.RWMutex
+	kvVersions map[string]int
 }
 
 func NewClient(address string, token string, approlePath string, roleID string, secretID string) (*Client, error) {
@@ -76,11 +82,43 @@ func NewClient(address string, token string, approlePath string, roleID string,
 	client.SetToken(token)
 
 	return &Client{
-		Client:  client,
-		Address: address,
+		Client:     client,
+		Address:    address,
+		kvVersions: map[string]int{},
 	}, nil
 }
 
+func (v *Client) setKVVersion(mount string, version int) {
+	v.rw.Lock()
+	defer v.rw.Unlock()
+
+	v.kvVersions[mount] = version
+}
+
+// KVMount returns the longest checked mount of path and its kv version
+// path outside of checked mounts is treated as kv v2 with no mount
+func (v *Client) KVMount(path string) (string, int) {
+	v.rw.RLock()
+	defer v.rw.RUnlock()
+
+	mount := ""
+	for m := range v.kvVersions {
+		if strings.HasPrefix(path, m) && len(m) > len(mount) {
+			mount = m
+		}
+	}
+	if mount == "" {
+		return "", 2
+	}
+	return mount, v.kvVersions[mount]
+}
+
+// KVVersion of the mount of path, 2 for paths outside of checked mounts
+func (v *Client) KVVersion(path string) int {
+	_, version := v.KVMount(path)
+	return version
+}
+
 // DeepListPaths returns set of paths and folders
 // path is a single path which has key value pairs
 // folder is a parent set of individual paths, it can have more folders and paths
@@ -118,34 +156,39 @@ func (v *Client) DeepListPaths(path string) ([]string, []string, error) {
 }
 
 // GetAllSecretPaths recursively lists all absolute paths given a root vault kv v2 path
+// failed are paths which could not be listed, nothing under them is in paths
 // Note: do not convert this into go routines as we dont know how to kill the goroutine
-func (v *Client) GetAllPaths(metaPaths []string) ([]string, []error) {
+func (v *Client) GetAllPaths(metaPaths []string) ([]string, []string, []error) {
 	var paths []string
+	var failed []string
 	var errs []error
 
 	for _, metaPath := range metaPaths {
-		p, e := v.getAllPaths(metaPath, []string{}, []error{})
+		p, f, e := v.getAllPaths(metaPath, []string{}, []string{}, []error{})
 		paths = append(paths, p...)
+		failed = append(failed, f...)
 		errs = append(errs, e...)
 	}
 
-	return paths, errs
+	return paths, failed, errs
 }
 
 // getAllSecretPaths is the actual recursive function
-func (v *Client) getAllPaths(metaPath string, paths []string, errs []error) ([]string, []error) {
+func (v *Client) getAllPaths(metaPath string, paths []string, failed []string, errs []error) ([]string, []string, []error) {
 	const op = apperr.Op("vault.getAllPaths")
 	childFragments, childFolders, childErr := v.DeepListPaths(metaPath)
 	if childErr != nil {
 		e := apperr.New(fmt.Sprintf("cannot list secrets in data path %q", metaPath), childErr, op, apperr.Warn, ErrInvalidPath)
+		failed = append(failed, metaPath)
 		errs = append(errs, e)
-		return paths, errs
+		return paths, failed, errs
 	}
 
 	for _, folder := range childFolders {
 		folder = folder[:len(folder)-1]
-		subPaths, subErrs := v.getAllPaths(metaPath+"/"+folder, []string{}, []error{})
+		subPaths, subFailed, subErrs := v.getAllPaths(metaPath+"/"+folder, []string{}, []string{}, []error{})
 		paths = append(paths, subPaths...)
+		failed = append(failed, subFailed...)
 		errs = append(errs, subErrs...)
 	}
 
@@ -153,7 +196,7 @@ func (v *Client) getAllPaths(metaPath string, paths []string, errs []error) ([]s
 		paths = append(paths, metaPath+"/"+fragment)
 	}
 
-	return paths, errs
+	return paths, failed, errs
 }
 
 // renews origin token